@@ -9,7 +9,8 @@ import (
 	. "MOSS_backend/models"
 	. "MOSS_backend/utils"
 	"MOSS_backend/utils/auth"
-	"MOSS_backend/utils/kong"
+	"MOSS_backend/utils/sender"
+	"MOSS_backend/utils/token"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -26,7 +27,7 @@ import (
 //	@Router			/register [post]
 //	@Param			json	body		RegisterRequest	true	"json"
 //	@Success		201		{object}	TokenResponse
-//	@Failure		400		{object}	utils.MessageResponse	"验证码错误、用户已注册"
+//	@Failure		400		{object}	utils.MessageResponse	"验证码错误、用户已注册、密码强度不足"
 //	@Failure		500		{object}	utils.MessageResponse
 func Register(c *fiber.Ctx) error {
 	scope := "register"
@@ -46,7 +47,11 @@ func Register(c *fiber.Ctx) error {
 		inviteCode InviteCode
 	)
 
-	errCollection, messageCollection := GetInfoByIP(GetRealIP(c))
+	errCollection, messageCollection := GetInfo(c)
+
+	if !auth.CheckPasswordStrength(body.Password) {
+		return errCollection.ErrPasswordWeak
+	}
 
 	// check invite code config
 	var configObject Config
@@ -126,13 +131,9 @@ func Register(c *fiber.Ctx) error {
 
 	if registered {
 		if deleted {
-			err = DB.Unscoped().Model(&user).Update("DeletedAt", gorm.Expr("NULL")).Error
-			if err != nil {
-				return err
-			}
-
 			user.DeletedAt.Valid = false
 			user.DeletedAt.Time = time.Unix(0, 0)
+			user.ErasureRequestedAt = nil
 
 			user.JoinedTime = time.Now()
 			user.RegisterIP = remoteIP
@@ -143,10 +144,28 @@ func Register(c *fiber.Ctx) error {
 			if inviteRequired {
 				user.InviteCode = inviteCode.Code
 			}
-			err = DB.Save(&user).Error
+
+			// credentials of the deleted account are not inherited, as erased by eraseUser
+			user.TotpEnabled = false
+			user.TotpSecret = ""
+			user.TotpLastStep = 0
+			user.RecoveryCodes = []string{}
+
+			err = DB.Transaction(func(tx *gorm.DB) error {
+				err = tx.Unscoped().Model(&user).Update("DeletedAt", gorm.Expr("NULL")).Error
+				if err != nil {
+					return err
+				}
+				err = tx.Unscoped().Where("user_id = ?", user.ID).Delete(&UserIdentity{}).Error
+				if err != nil {
+					return err
+				}
+				return tx.Save(&user).Error
+			})
 			if err != nil {
 				return err
 			}
+			DeleteUserCacheByID(user.ID)
 		} else {
 			return errCollection.ErrRegistered
 		}
@@ -165,14 +184,14 @@ func Register(c *fiber.Ctx) error {
 			return err
 		}
 
-		err = kong.CreateUser(user.ID)
+		err = token.CreateUser(user.ID)
 		if err != nil {
 			return err
 		}
 	}
 
-	// create kong token
-	accessToken, refreshToken, err := kong.CreateToken(&user)
+	// create token
+	accessToken, refreshToken, err := token.NewSession(c, &user)
 	if err != nil {
 		return err
 	}
@@ -201,13 +220,17 @@ func Register(c *fiber.Ctx) error {
 //
 //	@Summary		reset password
 //	@Description	reset password, reset jwt credential
+//	@Description	if two-factor authentication is enabled, totp_code or recovery_code is required as login,
+//	@Description	and the verification code is kept until the password is reset
 //	@Tags			account
 //	@Accept			json
 //	@Produce		json
 //	@Router			/register [put]
 //	@Param			json	body		RegisterRequest	true	"json"
 //	@Success		200		{object}	TokenResponse
-//	@Failure		400		{object}	utils.MessageResponse	"验证码错误"
+//	@Failure		400		{object}	utils.MessageResponse	"验证码错误，或密码强度不足"
+//	@Failure		401		{object}	utils.MessageResponse	"two-factor code required or invalid"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
 //	@Failure		500		{object}	utils.MessageResponse
 func ChangePassword(c *fiber.Ctx) error {
 	scope := "reset"
@@ -220,7 +243,11 @@ func ChangePassword(c *fiber.Ctx) error {
 		return err
 	}
 
-	errCollection, messageCollection := GetInfoByIP(GetRealIP(c))
+	errCollection, messageCollection := GetInfo(c)
+
+	if !auth.CheckPasswordStrength(body.Password) {
+		return errCollection.ErrPasswordWeak
+	}
 
 	if body.PhoneModel != nil {
 		ok = auth.CheckVerificationCode(body.Phone, scope, body.Verification)
@@ -234,6 +261,15 @@ func ChangePassword(c *fiber.Ctx) error {
 		return errCollection.ErrVerificationCodeInvalid
 	}
 
+	// two-factor codes are guessed under the same throttle as login
+	account := body.account()
+	if body.TotpCode != "" || body.RecoveryCode != "" {
+		err = checkLogin(c, errCollection, account, body.Captcha)
+		if err != nil {
+			return err
+		}
+	}
+
 	var user User
 	err = DB.Transaction(func(tx *gorm.DB) error {
 		querySet := tx.Clauses(clause.Locking{Strength: "UPDATE"})
@@ -249,22 +285,31 @@ func ChangePassword(c *fiber.Ctx) error {
 			return err
 		}
 
+		// a verification code alone must not bypass two-factor authentication
+		err = checkSecondFactor(tx, errCollection, user.ID, &body.SecondFactorModel)
+		if err != nil {
+			return err
+		}
+
 		user.Password, err = auth.MakePassword(body.Password)
 		if err != nil {
 			return err
 		}
-		return tx.Save(&user).Error
+		return tx.Model(&user).Select("Password").Updates(&user).Error
 	})
 	if err != nil {
 		return err
 	}
+	if body.TotpCode != "" || body.RecoveryCode != "" {
+		loginSucceeded(c, account)
+	}
 
-	err = kong.DeleteJwtCredential(user.ID)
+	err = token.RevokeTokens(user.ID)
 	if err != nil {
 		return err
 	}
 
-	accessToken, refreshToken, err := kong.CreateToken(&user)
+	accessToken, refreshToken, err := token.NewSession(c, &user)
 	if err != nil {
 		return err
 	}
@@ -295,6 +340,7 @@ func ChangePassword(c *fiber.Ctx) error {
 //	@Param			email	query		VerifyEmailRequest	true	"email"
 //	@Success		200		{object}	VerifyResponse
 //	@Failure		400		{object}	utils.MessageResponse	"已注册“
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
 func VerifyWithEmail(c *fiber.Ctx) error {
 	var query VerifyEmailRequest
 	err := ValidateQuery(c, &query)
@@ -302,7 +348,7 @@ func VerifyWithEmail(c *fiber.Ctx) error {
 		return err
 	}
 
-	errCollection, messageCollection := GetInfoByIP(GetRealIP(c))
+	errCollection, messageCollection := GetInfo(c)
 	if IsEmailInBlacklist(query.Email) {
 		return errCollection.ErrEmailInBlacklist
 	}
@@ -390,12 +436,17 @@ func VerifyWithEmail(c *fiber.Ctx) error {
 		}
 	}
 
+	err = claimVerification(c, errCollection, query.Email, query.Captcha)
+	if err != nil {
+		return err
+	}
+
 	code, err := auth.SetVerificationCode(query.Email, scope)
 	if err != nil {
 		return err
 	}
 
-	err = SendCodeEmail(code, query.Email)
+	err = sender.SendCodeEmail(code, query.Email, scope, GetLocale(c))
 	if err != nil {
 		return err
 	}
@@ -416,14 +467,15 @@ func VerifyWithEmail(c *fiber.Ctx) error {
 //	@Param			phone	query		VerifyPhoneRequest	true	"phone"
 //	@Success		200		{object}	VerifyResponse
 //	@Failure		400		{object}	utils.MessageResponse	"已注册“
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
 func VerifyWithPhone(c *fiber.Ctx) error {
 	var query VerifyPhoneRequest
 	err := ValidateQuery(c, &query)
 	if err != nil {
-		return BadRequest("invalid phone number")
+		return BadRequest().WithMessageID("phone_invalid")
 	}
 
-	errCollection, messageCollection := GetInfoByIP(GetRealIP(c))
+	errCollection, messageCollection := GetInfo(c)
 
 	var (
 		user       User
@@ -501,12 +553,17 @@ func VerifyWithPhone(c *fiber.Ctx) error {
 			}
 		}
 	}
+	err = claimVerification(c, errCollection, query.Phone, query.Captcha)
+	if err != nil {
+		return err
+	}
+
 	code, err := auth.SetVerificationCode(query.Phone, scope)
 	if err != nil {
 		return err
 	}
 
-	err = SendCodeMessage(code, query.Phone)
+	err = sender.SendCodeMessage(code, query.Phone, scope, GetLocale(c))
 	if err != nil {
 		return err
 	}
@@ -520,7 +577,7 @@ func VerifyWithPhone(c *fiber.Ctx) error {
 // DeleteUser godoc
 //
 //	@Summary		delete user
-//	@Description	delete user and related jwt credentials
+//	@Description	delete user and related jwt credentials, personal data is erased after the grace period unless registered again
 //	@Tags			account
 //	@Router			/users/me [delete]
 //	@Param			json	body	LoginRequest	true	"email, password"
@@ -535,7 +592,13 @@ func DeleteUser(c *fiber.Ctx) error {
 		return err
 	}
 
-	errCollection, _ := GetInfoByIP(GetRealIP(c))
+	errCollection, _ := GetInfo(c)
+
+	account := body.account()
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
 
 	var user User
 	err = DB.Transaction(func(tx *gorm.DB) error {
@@ -560,12 +623,29 @@ func DeleteUser(c *fiber.Ctx) error {
 			return errCollection.ErrPasswordIncorrect
 		}
 
+		err = checkSecondFactor(tx, errCollection, user.ID, &body.SecondFactorModel)
+		if err != nil {
+			return err
+		}
+
+		// personal data is erased by EraseUsersTask after the grace period, unless registered again
+		now := time.Now()
+		user.ErasureRequestedAt = &now
+		err = tx.Model(&user).Update("erasure_requested_at", now).Error
+		if err != nil {
+			return err
+		}
+
 		return tx.Delete(&user).Error
 	})
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
 
 	DeleteUserCacheByID(user.ID)
 
-	err = kong.DeleteJwtCredential(user.ID)
+	err = token.RevokeTokens(user.ID)
 	if err != nil {
 		return err
 	}
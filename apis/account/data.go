@@ -0,0 +1,216 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/token"
+)
+
+// userDataExport is the profile part of data export, including fields hidden in the user api
+type userDataExport struct {
+	User
+	RegisterIP  string   `json:"register_ip"`
+	LastLoginIP string   `json:"last_login_ip"`
+	LoginIP     []string `json:"login_ip"`
+	InviteCode  string   `json:"invite_code"`
+}
+
+// recordDataExport includes fields hidden in the record api
+type recordDataExport struct {
+	Record
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// chatDataExport includes deleted chats
+type chatDataExport struct {
+	Chat
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func deletedTime(deletedAt gorm.DeletedAt) *time.Time {
+	if !deletedAt.Valid {
+		return nil
+	}
+	return &deletedAt.Time
+}
+
+// ExportUserData godoc
+//
+//	@Summary		export personal data
+//	@Description	a zip archive of profile, chats, records with feedback, folders and offenses, including deleted ones not yet purged
+//	@Tags			user
+//	@Produce		application/zip
+//	@Router			/users/me/data-export [get]
+//	@Success		200
+func ExportUserData(c *fiber.Ctx) error {
+	user, err := LoadUser(c)
+	if err != nil {
+		return err
+	}
+
+	var chats Chats
+	err = DB.Unscoped().Order("id").Find(&chats, "user_id = ?", user.ID).Error
+	if err != nil {
+		return err
+	}
+	err = chats.LoadTags()
+	if err != nil {
+		return err
+	}
+	chatIDs := make([]int, len(chats))
+	chatExports := make([]chatDataExport, len(chats))
+	for i := range chats {
+		chatIDs[i] = chats[i].ID
+		chatExports[i] = chatDataExport{Chat: chats[i], DeletedAt: deletedTime(chats[i].DeletedAt)}
+	}
+
+	var records Records
+	if len(chatIDs) > 0 {
+		err = DB.Unscoped().Order("id").Find(&records, "chat_id IN ?", chatIDs).Error
+		if err != nil {
+			return err
+		}
+	}
+	recordExports := make([]recordDataExport, len(records))
+	for i := range records {
+		recordExports[i] = recordDataExport{Record: records[i], DeletedAt: deletedTime(records[i].DeletedAt)}
+	}
+
+	var folders []ChatFolder
+	err = DB.Order("id").Find(&folders, "user_id = ?", user.ID).Error
+	if err != nil {
+		return err
+	}
+
+	var offenses []UserOffense
+	err = DB.Order("id").Find(&offenses, "user_id = ?", user.ID).Error
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", userDataExport{
+			User:        *user,
+			RegisterIP:  user.RegisterIP,
+			LastLoginIP: user.LastLoginIP,
+			LoginIP:     user.LoginIP,
+			InviteCode:  user.InviteCode,
+		}},
+		{"chats.json", chatExports},
+		{"records.json", recordExports},
+		{"folders.json", folders},
+		{"offenses.json", offenses},
+	}
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, file := range files {
+		fileWriter, err := writer.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(fileWriter)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(
+		`attachment; filename="moss-data-%d-%s.zip"`,
+		user.ID, time.Now().Format("20060102"),
+	))
+	return c.Send(buf.Bytes())
+}
+
+// EraseUsersTask purges all personal data of users deleted before the grace period,
+// and removes their kong consumers
+func EraseUsersTask() {
+	deadline := time.Now().AddDate(0, 0, -config.Config.ErasureGraceDays)
+
+	// users deleted before erasure was requested on deletion have no erasure_requested_at
+	var userIDs []int
+	err := DB.Unscoped().Model(&User{}).
+		Where("deleted_at IS NOT NULL AND COALESCE(erasure_requested_at, deleted_at) < ?", deadline).
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		Logger.Error("load users to erase error", zap.Error(err))
+		return
+	}
+
+	for _, userID := range userIDs {
+		err = eraseUser(userID)
+		if err != nil {
+			Logger.Error("erase user error", zap.Int("user_id", userID), zap.Error(err))
+			continue
+		}
+		Logger.Info("user erased", zap.Int("user_id", userID))
+	}
+}
+
+func eraseUser(userID int) error {
+	err := token.DeleteUser(userID)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var chatIDs []int
+		err = tx.Unscoped().Model(&Chat{}).Where("user_id = ?", userID).Pluck("id", &chatIDs).Error
+		if err != nil {
+			return err
+		}
+		if len(chatIDs) > 0 {
+			err = tx.Unscoped().Where("chat_id IN ?", chatIDs).Delete(&Record{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("chat_id IN ?", chatIDs).Delete(&ChatTag{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// direct records are saved with the kong consumer of the user, named by user id
+		err = tx.Where("consumer_username = ?", strconv.Itoa(userID)).Delete(&DirectRecord{}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []any{&Chat{}, &ChatFolder{}, &UserOffense{}, &AnnouncementDismissal{}, &Session{}, &UserIdentity{}} {
+			err = tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Delete(&User{}, userID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	DeleteUserCacheByID(userID)
+	return nil
+}
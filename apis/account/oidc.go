@@ -0,0 +1,483 @@
+package account
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/oidc"
+	"MOSS_backend/utils/token"
+)
+
+const (
+	oidcStateExpire        = 10 * time.Minute
+	oidcSecondFactorExpire = 5 * time.Minute
+)
+
+// oidcState is kept in redis between the login redirect and the callback
+type oidcState struct {
+	Provider     string  `json:"provider"`
+	Nonce        string  `json:"nonce"`
+	CodeVerifier string  `json:"code_verifier"`
+	InviteCode   *string `json:"invite_code"`
+}
+
+func oidcStateKey(state string) string {
+	return "moss_oidc_state:" + state
+}
+
+// oidcStateCookie binds the state to the browser starting the login, against login csrf
+const oidcStateCookie = "oidc_state"
+
+func oidcStateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// setOidcStateCookie sets the hash of the state, lax cookies are sent on the top-level redirect back from the provider
+func setOidcStateCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// oidcSecondFactorKey keeps the user id of a sso login waiting for the second factor
+func oidcSecondFactorKey(ticket string) string {
+	return "moss_oidc_second_factor:" + ticket
+}
+
+func loadOidcProvider(c *fiber.Ctx) (*oidc.Provider, error) {
+	provider, err := oidc.GetProvider(c.Params("provider"))
+	if err != nil {
+		if errors.Is(err, oidc.ErrProviderNotFound) {
+			return nil, NotFound().WithMessageID("sso_provider_not_found")
+		}
+		return nil, err
+	}
+	return provider, nil
+}
+
+// OidcLogin godoc
+//
+//	@Summary		login with single sign-on
+//	@Description	redirect to the oidc provider, which redirects back to the callback.
+//	@Description	The state is bound to the browser by a cookie checked in the callback.
+//	@Tags			token
+//	@Router			/oidc/{provider}/login [get]
+//	@Param			provider	path	string				true	"provider name"
+//	@Param			query		query	OidcLoginRequest	false	"query"
+//	@Success		302
+//	@Failure		404	{object}	utils.MessageResponse	"provider not found"
+func OidcLogin(c *fiber.Ctx) error {
+	var query OidcLoginRequest
+	err := ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	provider, err := loadOidcProvider(c)
+	if err != nil {
+		return err
+	}
+
+	var values [3]string
+	for i := range values {
+		values[i], err = oidc.RandomString()
+		if err != nil {
+			return err
+		}
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	data, err := json.Marshal(oidcState{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		InviteCode:   query.InviteCode,
+	})
+	if err != nil {
+		return err
+	}
+	err = config.RedisClient.Set(context.Background(), oidcStateKey(state), data, oidcStateExpire).Err()
+	if err != nil {
+		return err
+	}
+
+	setOidcStateCookie(c, oidcStateHash(state), time.Now().Add(oidcStateExpire))
+	return c.Redirect(provider.AuthCodeURL(state, nonce, codeVerifier))
+}
+
+// OidcCallback godoc
+//
+//	@Summary		callback of single sign-on
+//	@Description	verify the state cookie and the id token, link the user by verified email or register a new user.
+//	@Description	Users registered here do not share their records until they consent.
+//	@Description	Tokens are set in cookies and redirected to the frontend if configured, otherwise returned.
+//	@Description	If two-factor authentication is enabled, a second_factor_ticket is returned or added to the redirect url instead,
+//	@Description	which is exchanged for tokens with the second factor in /oidc/second-factor.
+//	@Tags			token
+//	@Produce		json
+//	@Router			/oidc/{provider}/callback [get]
+//	@Param			provider	path		string				true	"provider name"
+//	@Param			query		query		OidcCallbackRequest	true	"query"
+//	@Success		200			{object}	TokenResponse
+//	@Success		202			{object}	OidcSecondFactorResponse
+//	@Success		302
+//	@Failure		400			{object}	utils.MessageResponse
+//	@Failure		401			{object}	utils.MessageResponse	"sso login failed"
+func OidcCallback(c *fiber.Ctx) error {
+	var query OidcCallbackRequest
+	err := ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+	if query.Error != "" {
+		return Unauthorized().WithMessageID("sso_login_denied", query.Error)
+	}
+	if query.Code == "" || query.State == "" {
+		return BadRequest().WithMessageID("sso_code_required")
+	}
+
+	provider, err := loadOidcProvider(c)
+	if err != nil {
+		return err
+	}
+
+	// the callback must come back to the browser starting the login
+	stateHash := c.Cookies(oidcStateCookie)
+	setOidcStateCookie(c, "", time.Now().Add(-time.Hour))
+	if subtle.ConstantTimeCompare([]byte(stateHash), []byte(oidcStateHash(query.State))) != 1 {
+		return BadRequest().WithMessageID("sso_state_invalid")
+	}
+
+	// state can be used only once
+	data, err := config.RedisClient.GetDel(context.Background(), oidcStateKey(query.State)).Bytes()
+	if err != nil {
+		return BadRequest().WithMessageID("sso_state_invalid")
+	}
+	var state oidcState
+	err = json.Unmarshal(data, &state)
+	if err != nil || state.Provider != provider.Name {
+		return BadRequest().WithMessageID("sso_state_invalid")
+	}
+
+	claims, err := provider.Exchange(c.Context(), query.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		Logger.Warn("oidc exchange error", zap.String("provider", provider.Name), zap.Error(err))
+		return Unauthorized().WithMessageID("sso_login_failed")
+	}
+
+	errCollection, messageCollection := GetInfo(c)
+	user, err := oidcUser(c, errCollection, provider, claims, state.InviteCode)
+	if err != nil {
+		return err
+	}
+
+	// the identity provider is not trusted for the second factor
+	if user.TotpEnabled {
+		return oidcSecondFactorChallenge(c, errCollection, user.ID)
+	}
+
+	err = updateLogin(DB, c, user)
+	if err != nil {
+		return err
+	}
+
+	access, refresh, err := token.NewSession(c, user)
+	if err != nil {
+		return err
+	}
+
+	if config.Config.OidcLoginRedirectUrl == "" {
+		return c.JSON(TokenResponse{
+			Access:  access,
+			Refresh: refresh,
+			Message: messageCollection.MessageLoginSuccess,
+		})
+	}
+
+	now := time.Now()
+	for _, cookie := range []struct {
+		name    string
+		value   string
+		expires time.Time
+	}{
+		{"access", access, now.Add(time.Duration(config.Config.AccessExpireTime) * time.Minute)},
+		{"refresh", refresh, now.Add(time.Duration(config.Config.RefreshExpireTime) * 24 * time.Hour)},
+	} {
+		c.Cookie(&fiber.Cookie{
+			Name:     cookie.name,
+			Value:    cookie.value,
+			Path:     "/",
+			Expires:  cookie.expires,
+			Secure:   true,
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	return c.Redirect(config.Config.OidcLoginRedirectUrl)
+}
+
+// oidcSecondFactorChallenge returns a ticket to continue the login with the second factor
+func oidcSecondFactorChallenge(c *fiber.Ctx, errCollection *ErrCollection, userID int) error {
+	ticket, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	err = config.RedisClient.Set(context.Background(), oidcSecondFactorKey(ticket), userID, oidcSecondFactorExpire).Err()
+	if err != nil {
+		return err
+	}
+
+	if config.Config.OidcLoginRedirectUrl == "" {
+		return c.Status(fiber.StatusAccepted).JSON(OidcSecondFactorResponse{
+			Ticket:  ticket,
+			Message: LocalizeError(errCollection.ErrTotpRequired, GetLocale(c)),
+		})
+	}
+
+	redirectUrl, err := url.Parse(config.Config.OidcLoginRedirectUrl)
+	if err != nil {
+		return err
+	}
+	query := redirectUrl.Query()
+	query.Set("second_factor_ticket", ticket)
+	redirectUrl.RawQuery = query.Encode()
+	return c.Redirect(redirectUrl.String())
+}
+
+// OidcSecondFactor godoc
+//
+//	@Summary		finish single sign-on with the second factor
+//	@Description	exchange the second_factor_ticket from the callback and a totp or recovery code for tokens
+//	@Tags			token
+//	@Accept			json
+//	@Produce		json
+//	@Router			/oidc/second-factor [post]
+//	@Param			json	body		OidcSecondFactorRequest	true	"json"
+//	@Success		200		{object}	TokenResponse
+//	@Failure		400		{object}	utils.MessageResponse	"invalid or expired ticket"
+//	@Failure		401		{object}	utils.MessageResponse	"two-factor code required or invalid"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
+func OidcSecondFactor(c *fiber.Ctx) error {
+	var body OidcSecondFactorRequest
+	err := ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, messageCollection := GetInfo(c)
+
+	key := oidcSecondFactorKey(body.Ticket)
+	userID, err := config.RedisClient.Get(context.Background(), key).Int()
+	if err != nil {
+		return BadRequest().WithMessageID("sso_state_invalid")
+	}
+	var user User
+	err = DB.Take(&user, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return BadRequest().WithMessageID("sso_state_invalid")
+		}
+		return err
+	}
+
+	account := userAccount(&user)
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
+
+	err = checkSecondFactor(DB, errCollection, user.ID, &body.SecondFactorModel)
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
+
+	// the ticket can be used only once
+	deleted, err := config.RedisClient.Del(context.Background(), key).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return BadRequest().WithMessageID("sso_state_invalid")
+	}
+
+	err = updateLogin(DB, c, &user)
+	if err != nil {
+		return err
+	}
+
+	access, refresh, err := token.NewSession(c, &user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(TokenResponse{
+		Access:  access,
+		Refresh: refresh,
+		Message: messageCollection.MessageLoginSuccess,
+	})
+}
+
+// oidcUser finds the user of the identity, or links the user with the same verified email,
+// or registers a new user. The login is recorded by the caller after the second factor.
+func oidcUser(
+	c *fiber.Ctx,
+	errCollection *ErrCollection,
+	provider *oidc.Provider,
+	claims *oidc.Claims,
+	inviteCodeString *string,
+) (*User, error) {
+	var (
+		user     User
+		identity UserIdentity
+	)
+	err := DB.Take(&identity, "issuer = ? AND subject = ?", claims.Issuer, claims.Subject).Error
+	if err == nil {
+		err = DB.Take(&user, identity.UserID).Error
+		if err == nil {
+			return &user, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		// the user is deleted, link again
+		err = DB.Delete(&identity).Error
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email, ok := claims.VerifiedEmail()
+	if !ok {
+		return nil, BadRequest().WithMessageID("sso_email_unverified")
+	}
+	if IsEmailInBlacklist(email) {
+		return nil, errCollection.ErrEmailInBlacklist
+	}
+
+	var registered bool
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Unscoped().Take(&user, "email = ?", email).Error
+		if err == nil {
+			if user.DeletedAt.Valid {
+				return BadRequest().WithMessageID("sso_account_deleted")
+			}
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = registerOidcUser(c, tx, errCollection, provider, claims, email, inviteCodeString, &user)
+			registered = err == nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&UserIdentity{
+			UserID:  user.ID,
+			Issuer:  claims.Issuer,
+			Subject: claims.Subject,
+			Email:   email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the kong consumer is created after commit, not left behind if registration is rolled back
+	if registered {
+		err = token.CreateUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// registerOidcUser creates the user in tx, the caller creates the kong consumer after commit
+func registerOidcUser(
+	c *fiber.Ctx,
+	tx *gorm.DB,
+	errCollection *ErrCollection,
+	provider *oidc.Provider,
+	claims *oidc.Claims,
+	email string,
+	inviteCodeString *string,
+	user *User,
+) error {
+	var configObject Config
+	err := LoadConfig(&configObject)
+	if err != nil {
+		return err
+	}
+
+	// check invite code, unless the provider or the email suffix is trusted
+	var inviteCode InviteCode
+	inviteRequired := configObject.InviteRequired && !provider.SkipInviteCode
+	for _, emailSuffix := range config.Config.NoNeedInviteCodeEmailSuffix {
+		if strings.HasSuffix(email, emailSuffix) {
+			inviteRequired = false
+			break
+		}
+	}
+	if inviteRequired {
+		if inviteCodeString == nil {
+			return errCollection.ErrNeedInviteCode
+		}
+		err = tx.Clauses(LockingClause).Take(&inviteCode, "code = ?", *inviteCodeString).Error
+		if err != nil || !inviteCode.IsSend || inviteCode.IsActivated {
+			return errCollection.ErrInviteCodeInvalid
+		}
+	}
+
+	remoteIP := GetRealIP(c)
+	// sharing records for research is opted in by the user later
+	*user = User{
+		Email:      email,
+		Nickname:   StripContent(claims.Name, 128),
+		RegisterIP: remoteIP,
+		ModelID:    config.Config.DefaultModelID,
+	}
+	if user.Nickname == "" {
+		user.Nickname = "user"
+	}
+	user.UpdateIP(remoteIP)
+	if inviteRequired {
+		user.InviteCode = inviteCode.Code
+	}
+	err = tx.Create(user).Error
+	if err != nil {
+		return err
+	}
+
+	if inviteRequired {
+		return tx.Model(&inviteCode).Update("is_activated", true).Error
+	}
+	return nil
+}
+
+func updateLogin(tx *gorm.DB, c *fiber.Ctx, user *User) error {
+	user.UpdateIP(GetRealIP(c))
+	user.LastLogin = time.Now()
+	return tx.Model(user).Select("LastLogin", "LastLoginIP", "LoginIP").Updates(user).Error
+}
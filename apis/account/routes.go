@@ -7,6 +7,9 @@ func RegisterRoutes(routes fiber.Router) {
 	routes.Post("/login", Login)
 	routes.Get("/logout", Logout)
 	routes.Post("/refresh", Refresh)
+	routes.Get("/oidc/:provider/login", OidcLogin)
+	routes.Get("/oidc/:provider/callback", OidcCallback)
+	routes.Post("/oidc/second-factor", OidcSecondFactor)
 
 	// account management
 	routes.Get("/verify/email", VerifyWithEmail)
@@ -18,4 +21,17 @@ func RegisterRoutes(routes fiber.Router) {
 	// user info
 	routes.Get("/users/me", GetCurrentUser)
 	routes.Put("/users/me", ModifyUser)
+	routes.Put("/users/me/password", UpdatePassword)
+	routes.Get("/users/me/data-export", ExportUserData)
+
+	// two-factor authentication
+	routes.Post("/users/me/totp", EnrollTotp)
+	routes.Post("/users/me/totp/enable", EnableTotp)
+	routes.Delete("/users/me/totp", DisableTotp)
+	routes.Post("/users/me/totp/recovery-codes", RegenerateRecoveryCodes)
+
+	// sessions
+	routes.Get("/users/me/sessions", ListSessions)
+	routes.Delete("/users/me/sessions", DeleteSessions)
+	routes.Delete("/users/me/sessions/:id", DeleteSession)
 }
@@ -1,5 +1,7 @@
 package account
 
+import "time"
+
 /* account */
 
 type EmailModel struct {
@@ -14,22 +16,52 @@ type PhoneModel struct {
 	Phone string `json:"phone" query:"phone" validate:"required"` // phone number in e164 mode
 }
 
+type CaptchaModel struct {
+	Captcha string `json:"captcha" query:"captcha"` // captcha response, required if captcha is enabled
+}
+
 type VerifyEmailRequest struct {
 	EmailModel
 	ScopeModel
+	CaptchaModel
 	InviteCode *string `json:"invite_code" query:"invite_code" validate:"omitempty,min=1"`
 }
 
 type VerifyPhoneRequest struct {
 	PhoneModel
 	ScopeModel
+	CaptchaModel
 	InviteCode *string `json:"invite_code" query:"invite_code" validate:"omitempty,min=1"`
 }
 
+// SecondFactorModel is required if two-factor authentication is enabled, either of the codes
+type SecondFactorModel struct {
+	TotpCode     string `json:"totp_code" validate:"omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SecondFactorRequest confirms sensitive changes of two-factor authentication
+type SecondFactorRequest struct {
+	CaptchaModel
+	SecondFactorModel
+}
+
 type LoginRequest struct {
 	*EmailModel `validate:"omitempty"`
 	*PhoneModel `validate:"omitempty"`
-	Password    string `json:"password" minLength:"8"`
+	CaptchaModel
+	SecondFactorModel
+	Password string `json:"password" minLength:"8"`
+}
+
+// account returns the email or phone to login
+func (body *LoginRequest) account() string {
+	if body.EmailModel != nil {
+		return body.Email
+	} else if body.PhoneModel != nil {
+		return body.Phone
+	}
+	return ""
 }
 
 type TokenResponse struct {
@@ -44,6 +76,13 @@ type RegisterRequest struct {
 	InviteCode   *string `json:"invite_code" validate:"omitempty,min=1"`
 }
 
+type UpdatePasswordRequest struct {
+	CaptchaModel
+	SecondFactorModel
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required" minLength:"8"`
+}
+
 type VerifyResponse struct {
 	Message string `json:"message"`
 	Scope   string `json:"scope" enums:"register,reset"`
@@ -58,4 +97,60 @@ type ModifyUserRequest struct {
 	DisableSensitiveCheck *bool           `json:"disable_sensitive_check"`
 	ModelID               *int            `json:"model_id" validate:"omitempty,min=1"`
 	PluginConfig          map[string]bool `json:"plugin_config" validate:"omitempty"`
+	Locale                *string         `json:"locale" enums:"zh,en,"` // empty to follow the browser
+}
+
+type SessionResponse struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"` // the session of this request
+}
+
+type TotpEnrollResponse struct {
+	Secret string `json:"secret"` // base32, for manual input
+	URI    string `json:"uri"`    // otpauth uri, shown as a QR code
+}
+
+// PasswordConfirmModel confirms the password of the current user before sensitive changes
+type PasswordConfirmModel struct {
+	CaptchaModel
+	Password string `json:"password" validate:"required"`
+}
+
+type EnrollTotpRequest struct {
+	PasswordConfirmModel
+}
+
+type EnableTotpRequest struct {
+	PasswordConfirmModel
+	TotpCode string `json:"totp_code" validate:"required,len=6,numeric"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown only once
+}
+
+type OidcLoginRequest struct {
+	InviteCode *string `query:"invite_code" validate:"omitempty,min=1"` // required to register if invite is required
+}
+
+type OidcCallbackRequest struct {
+	Code  string `query:"code"`
+	State string `query:"state"`
+	Error string `query:"error"` // set by the provider if the user denied
+}
+
+// OidcSecondFactorResponse is returned by the callback instead of tokens if two-factor authentication is enabled
+type OidcSecondFactorResponse struct {
+	Ticket  string `json:"second_factor_ticket"`
+	Message string `json:"message"`
+}
+
+type OidcSecondFactorRequest struct {
+	CaptchaModel
+	SecondFactorModel
+	Ticket string `json:"second_factor_ticket" validate:"required"`
 }
@@ -0,0 +1,106 @@
+package account
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/token"
+)
+
+func revokeSession(userID, sessionID int) error {
+	var session Session
+	err := DB.Take(&session, "id = ? AND user_id = ?", sessionID, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return NotFound().WithMessageID("session_not_found")
+		}
+		return err
+	}
+	return token.RevokeSession(&session)
+}
+
+// ListSessions godoc
+//
+//	@Summary		list active sessions of current user
+//	@Tags			user
+//	@Produce		json
+//	@Router			/users/me/sessions [get]
+//	@Success		200	{array}		SessionResponse
+//	@Failure		500	{object}	utils.MessageResponse
+func ListSessions(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var sessions []Session
+	err = DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen desc").Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+
+	currentID := token.SessionID(c)
+	var responses = make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, SessionResponse{
+			ID:        session.ID,
+			CreatedAt: session.CreatedAt,
+			Device:    session.Device,
+			IP:        session.IP,
+			LastSeen:  session.LastSeen,
+			Current:   session.ID == currentID,
+		})
+	}
+	return c.JSON(responses)
+}
+
+// DeleteSession godoc
+//
+//	@Summary		revoke a session of current user, logout the device
+//	@Tags			user
+//	@Router			/users/me/sessions/{id} [delete]
+//	@Param			id	path	int	true	"session id"
+//	@Success		204
+//	@Failure		404	{object}	utils.MessageResponse	"session not found"
+func DeleteSession(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	err = revokeSession(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(204)
+}
+
+// DeleteSessions godoc
+//
+//	@Summary		revoke all sessions of current user, logout everywhere
+//	@Tags			user
+//	@Router			/users/me/sessions [delete]
+//	@Success		204
+func DeleteSessions(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	err = token.RevokeTokens(userID)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,66 @@
+package account
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/auth"
+)
+
+// checkLogin rejects attempts to login the account after too many failures, otherwise the attempt
+// is reserved and counted as a failure unless loginSucceeded is called
+func checkLogin(c *fiber.Ctx, errCollection *ErrCollection, account, captcha string) error {
+	status := auth.ReserveLogin(account, GetRealIP(c))
+	if status.Locked {
+		return RetryAfter(errCollection.ErrAccountLocked, status.RetryAfter)
+	}
+	if status.RetryAfter > 0 {
+		return RetryAfter(errCollection.ErrLoginTooFrequent, status.RetryAfter)
+	}
+	if status.CaptchaRequired {
+		return checkCaptcha(c, errCollection, captcha)
+	}
+	return nil
+}
+
+// userAccount is the account of the user to count login failures
+func userAccount(user *User) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	return user.Phone
+}
+
+func loginSucceeded(c *fiber.Ctx, account string) {
+	auth.LoginSucceeded(account, GetRealIP(c))
+}
+
+func checkCaptcha(c *fiber.Ctx, errCollection *ErrCollection, captcha string) error {
+	ok, err := auth.VerifyCaptcha(captcha, GetRealIP(c))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errCollection.ErrCaptchaRequired
+	}
+	return nil
+}
+
+// claimVerification rejects sending verification codes to the target too frequently,
+// captcha is always required if enabled because sending costs
+func claimVerification(c *fiber.Ctx, errCollection *ErrCollection, target, captcha string) error {
+	err := checkCaptcha(c, errCollection, captcha)
+	if err != nil {
+		return err
+	}
+
+	status := auth.ClaimVerification(target, GetRealIP(c))
+	if status.Daily {
+		return RetryAfter(errCollection.ErrVerificationDailyLimit, status.RetryAfter)
+	}
+	if status.RetryAfter > 0 {
+		return RetryAfter(errCollection.ErrVerificationTooFrequent, status.RetryAfter)
+	}
+	return nil
+}
@@ -4,10 +4,11 @@ import (
 	. "MOSS_backend/models"
 	. "MOSS_backend/utils"
 	"MOSS_backend/utils/auth"
-	"MOSS_backend/utils/kong"
+	"MOSS_backend/utils/token"
 	"errors"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"time"
 )
 
 // Login godoc
@@ -22,6 +23,7 @@ import (
 //	@Success		200		{object}	TokenResponse
 //	@Failure		400		{object}	utils.MessageResponse
 //	@Failure		404		{object}	utils.MessageResponse	"User Not Found"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
 //	@Failure		500		{object}	utils.MessageResponse
 func Login(c *fiber.Ctx) error {
 	var body LoginRequest
@@ -30,19 +32,26 @@ func Login(c *fiber.Ctx) error {
 		return err
 	}
 
-	errCollection, messageCollection := GetInfoByIP(GetRealIP(c))
+	errCollection, messageCollection := GetInfo(c)
+
+	account := body.account()
+	if account == "" {
+		return BadRequest()
+	}
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
 
 	var user User
 	if body.EmailModel != nil {
 		err = DB.Where("email = ?", body.Email).Take(&user).Error
-	} else if body.PhoneModel != nil {
-		err = DB.Where("phone = ?", body.Phone).Take(&user).Error
 	} else {
-		return BadRequest()
+		err = DB.Where("phone = ?", body.Phone).Take(&user).Error
 	}
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return NotFound("User Not Found")
+			return NotFound().WithMessageID("user_not_found")
 		} else {
 			return err
 		}
@@ -56,14 +65,31 @@ func Login(c *fiber.Ctx) error {
 		return errCollection.ErrPasswordIncorrect
 	}
 
-	// update login time and ip
+	err = checkSecondFactor(DB, errCollection, user.ID, &body.SecondFactorModel)
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
+
+	// update login time and ip, other fields may be changed by the second factor check
 	user.UpdateIP(GetRealIP(c))
-	err = DB.Save(&user).Error
+	user.LastLogin = time.Now()
+	fields := []string{"LastLogin", "LastLoginIP", "LoginIP"}
+
+	// upgrade the password hash to the configured hasher and costs
+	if auth.NeedsRehash(user.Password) {
+		user.Password, err = auth.MakePassword(body.Password)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "Password")
+	}
+	err = DB.Model(&user).Select(fields).Updates(&user).Error
 	if err != nil {
 		return err
 	}
 
-	access, refresh, err := kong.CreateToken(&user)
+	access, refresh, err := token.NewSession(c, &user)
 	if err != nil {
 		return err
 	}
@@ -78,7 +104,7 @@ func Login(c *fiber.Ctx) error {
 // Logout
 //
 //	@Summary		Logout
-//	@Description	Logout, clear jwt credential and return successful message, logout, login required
+//	@Description	Logout, revoke the current session and return successful message, login required
 //	@Tags			token
 //	@Produce		json
 //	@Router			/logout [get]
@@ -89,15 +115,15 @@ func Logout(c *fiber.Ctx) error {
 		return err
 	}
 
-	_, messageCollection := GetInfoByIP(GetRealIP(c))
+	_, messageCollection := GetInfo(c)
 
-	var user User
-	err = LoadUserByIDFromCache(userID, &user)
-	if err != nil {
-		return err
+	// tokens issued before sessions can only be revoked all together
+	sessionID := token.SessionID(c)
+	if sessionID == 0 {
+		err = token.RevokeTokens(userID)
+	} else {
+		err = revokeSession(userID, sessionID)
 	}
-
-	err = kong.DeleteJwtCredential(userID)
 	if err != nil {
 		return err
 	}
@@ -108,13 +134,14 @@ func Logout(c *fiber.Ctx) error {
 // Refresh
 //
 //	@Summary		Refresh jwt token
-//	@Description	Refresh jwt token with refresh token in header, login required
+//	@Description	Refresh jwt token with refresh token in header, the refresh token is rotated and can not be used again
 //	@Tags			token
 //	@Produce		json
 //	@Router			/refresh [post]
 //	@Success		200	{object}	TokenResponse
 func Refresh(c *fiber.Ctx) error {
-	user, err := GetUserByRefreshToken(c)
+	_, messageCollection := GetInfo(c)
+	user, access, refresh, err := token.RotateSession(c)
 	if err != nil {
 		return err
 	}
@@ -126,13 +153,9 @@ func Refresh(c *fiber.Ctx) error {
 		return err
 	}
 
-	access, refresh, err := kong.CreateToken(user)
-	if err != nil {
-		return err
-	}
 	return c.JSON(TokenResponse{
 		Access:  access,
 		Refresh: refresh,
-		Message: "refresh successful",
+		Message: messageCollection.MessageRefreshSuccess,
 	})
 }
@@ -0,0 +1,309 @@
+package account
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/auth"
+)
+
+const recoveryCodesCount = 10
+
+// checkSecondFactor verifies the totp code or a recovery code if two-factor authentication
+// is enabled for the user. The used code is consumed.
+func checkSecondFactor(tx *gorm.DB, errCollection *ErrCollection, userID int, body *SecondFactorModel) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Clauses(LockingClause).Take(&user, userID).Error
+		if err != nil {
+			return err
+		}
+		if !user.TotpEnabled {
+			return nil
+		}
+
+		if body.TotpCode != "" {
+			step, ok := auth.ValidateTotp(user.TotpSecret, body.TotpCode, time.Now(), user.TotpLastStep)
+			if !ok {
+				return errCollection.ErrTotpInvalid
+			}
+			return tx.Model(&user).Update("totp_last_step", step).Error
+		}
+
+		if body.RecoveryCode != "" {
+			index := slices.Index(user.RecoveryCodes, auth.HashRecoveryCode(body.RecoveryCode))
+			if index < 0 {
+				return errCollection.ErrTotpInvalid
+			}
+			user.RecoveryCodes = slices.Delete(user.RecoveryCodes, index, index+1)
+			return tx.Model(&user).Select("RecoveryCodes").Updates(&user).Error
+		}
+
+		return errCollection.ErrTotpRequired
+	})
+}
+
+// confirmPassword checks the password of the user before changes of two-factor authentication,
+// so that a stolen access token is not enough. Wrong passwords count as login failures of the account.
+func confirmPassword(c *fiber.Ctx, errCollection *ErrCollection, userID int, body *PasswordConfirmModel) error {
+	// load from database, password is not cached
+	var user User
+	err := DB.Take(&user, userID).Error
+	if err != nil {
+		return err
+	}
+
+	account := userAccount(&user)
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
+
+	ok, err := auth.CheckPassword(body.Password, user.Password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errCollection.ErrPasswordIncorrect
+	}
+	loginSucceeded(c, account)
+	return nil
+}
+
+// EnrollTotp godoc
+//
+//	@Summary		start enrolling two-factor authentication
+//	@Description	generate a totp secret, which is enabled after verified with a code from the authenticator.
+//	@Description	the password is required, users registered by single sign-on reset their password first
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Router			/users/me/totp [post]
+//	@Param			json	body		EnrollTotpRequest	true	"password"
+//	@Success		200		{object}	TotpEnrollResponse
+//	@Failure		400		{object}	utils.MessageResponse	"already enabled"
+//	@Failure		401		{object}	utils.MessageResponse	"wrong password"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
+func EnrollTotp(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body EnrollTotpRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, _ := GetInfo(c)
+
+	err = confirmPassword(c, errCollection, userID, &body.PasswordConfirmModel)
+	if err != nil {
+		return err
+	}
+
+	secret, err := auth.NewTotpSecret()
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Clauses(LockingClause).Take(&user, userID).Error
+		if err != nil {
+			return err
+		}
+		if user.TotpEnabled {
+			return BadRequest().WithMessageID("totp_already_enabled")
+		}
+		return tx.Model(&user).Update("totp_secret", secret).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(TotpEnrollResponse{
+		Secret: secret,
+		URI:    auth.TotpURI(secret, userAccount(&user)),
+	})
+}
+
+// EnableTotp godoc
+//
+//	@Summary		enable two-factor authentication
+//	@Description	verify the code from the authenticator to finish enrolling, recovery codes are returned only once
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Router			/users/me/totp/enable [post]
+//	@Param			json	body		EnableTotpRequest	true	"json"
+//	@Success		200		{object}	RecoveryCodesResponse
+//	@Failure		400		{object}	utils.MessageResponse
+//	@Failure		401		{object}	utils.MessageResponse	"wrong password or code"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
+func EnableTotp(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body EnableTotpRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, _ := GetInfo(c)
+
+	err = confirmPassword(c, errCollection, userID, &body.PasswordConfirmModel)
+	if err != nil {
+		return err
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err = tx.Clauses(LockingClause).Take(&user, userID).Error
+		if err != nil {
+			return err
+		}
+		if user.TotpEnabled {
+			return BadRequest().WithMessageID("totp_already_enabled")
+		}
+		if user.TotpSecret == "" {
+			return BadRequest().WithMessageID("totp_not_enrolled")
+		}
+
+		step, ok := auth.ValidateTotp(user.TotpSecret, body.TotpCode, time.Now(), 0)
+		if !ok {
+			return errCollection.ErrTotpInvalid
+		}
+
+		user.TotpEnabled = true
+		user.TotpLastStep = step
+		user.RecoveryCodes = hashes
+		return tx.Model(&user).Select("TotpEnabled", "TotpLastStep", "RecoveryCodes").Updates(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	DeleteUserCacheByID(userID)
+	return c.JSON(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTotp godoc
+//
+//	@Summary		disable two-factor authentication
+//	@Tags			user
+//	@Accept			json
+//	@Router			/users/me/totp [delete]
+//	@Param			json	body	SecondFactorRequest	true	"totp code or recovery code"
+//	@Success		204
+//	@Failure		401	{object}	utils.MessageResponse	"invalid code"
+//	@Failure		429	{object}	utils.MessageResponse	"too many attempts, retry after"
+func DisableTotp(c *fiber.Ctx) error {
+	user, err := LoadUser(c)
+	if err != nil {
+		return err
+	}
+	userID := user.ID
+
+	var body SecondFactorRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, _ := GetInfo(c)
+
+	// codes are guessed under the same throttle as login
+	account := userAccount(user)
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = checkSecondFactor(tx, errCollection, userID, &body.SecondFactorModel)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{ID: userID}).Select("TotpEnabled", "TotpSecret", "TotpLastStep", "RecoveryCodes").
+			Updates(&User{TotpEnabled: false, TotpSecret: "", TotpLastStep: 0, RecoveryCodes: []string{}}).Error
+	})
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
+
+	DeleteUserCacheByID(userID)
+	return c.SendStatus(204)
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		regenerate recovery codes of two-factor authentication
+//	@Description	previous recovery codes are invalidated, new codes are returned only once
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Router			/users/me/totp/recovery-codes [post]
+//	@Param			json	body		SecondFactorRequest	true	"totp code or recovery code"
+//	@Success		200		{object}	RecoveryCodesResponse
+//	@Failure		400		{object}	utils.MessageResponse	"not enabled"
+//	@Failure		401		{object}	utils.MessageResponse	"invalid code"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, err := LoadUser(c)
+	if err != nil {
+		return err
+	}
+	if !user.TotpEnabled {
+		return BadRequest().WithMessageID("totp_not_enabled")
+	}
+
+	var body SecondFactorRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, _ := GetInfo(c)
+
+	// codes are guessed under the same throttle as login
+	account := userAccount(user)
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = checkSecondFactor(tx, errCollection, user.ID, &body.SecondFactorModel)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{ID: user.ID}).Select("RecoveryCodes").Updates(&User{RecoveryCodes: hashes}).Error
+	})
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
+
+	return c.JSON(RecoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -5,6 +5,8 @@ import (
 	. "MOSS_backend/models"
 	. "MOSS_backend/utils"
 	"MOSS_backend/utils/auth"
+	"MOSS_backend/utils/i18n"
+	"MOSS_backend/utils/token"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -67,7 +69,7 @@ func ModifyUser(c *fiber.Ctx) error {
 		if body.EmailModel != nil && body.Email != user.Email {
 			ok := auth.CheckVerificationCode(body.Email, scope, body.Verification)
 			if !ok {
-				return BadRequest("verification code error")
+				return BadRequest().WithMessageID("verification_code_invalid")
 			}
 
 			user.Email = body.Email
@@ -76,12 +78,19 @@ func ModifyUser(c *fiber.Ctx) error {
 		if body.PhoneModel != nil && body.Phone != user.Phone {
 			ok := auth.CheckVerificationCode(body.Phone, scope, body.Verification)
 			if !ok {
-				return BadRequest("verification code error")
+				return BadRequest().WithMessageID("verification_code_invalid")
 			}
 
 			user.Phone = body.Phone
 		}
 
+		if body.Locale != nil {
+			if *body.Locale != "" && !i18n.Supported(*body.Locale) {
+				return BadRequest()
+			}
+			user.Locale = *body.Locale
+		}
+
 		if body.DisableSensitiveCheck != nil {
 			if !user.IsAdmin {
 				return Forbidden()
@@ -108,7 +117,7 @@ func ModifyUser(c *fiber.Ctx) error {
 			// init plugin config
 			defaultPluginConfig, err = GetPluginConfig(user.ModelID)
 			if err != nil {
-				return InternalServerError("Failed to change plugin config, please try again later")
+				return InternalServerError().WithMessageID("plugin_config_failed")
 			}
 			if user.PluginConfig == nil {
 				user.PluginConfig = defaultPluginConfig
@@ -135,3 +144,95 @@ func ModifyUser(c *fiber.Ctx) error {
 
 	return c.JSON(user)
 }
+
+// UpdatePassword godoc
+//
+//	@Summary		change password
+//	@Description	change password with the old password, other sessions are revoked and new tokens are returned
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Router			/users/me/password [put]
+//	@Param			json	body		UpdatePasswordRequest	true	"json"
+//	@Success		200		{object}	TokenResponse
+//	@Failure		400		{object}	utils.MessageResponse	"密码强度不足"
+//	@Failure		401		{object}	utils.MessageResponse	"密码错误"
+//	@Failure		429		{object}	utils.MessageResponse	"too many attempts, retry after"
+func UpdatePassword(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body UpdatePasswordRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	errCollection, messageCollection := GetInfo(c)
+
+	if !auth.CheckPasswordStrength(body.NewPassword) {
+		return errCollection.ErrPasswordWeak
+	}
+
+	// load from database, password is not cached
+	var user User
+	err = DB.Take(&user, userID).Error
+	if err != nil {
+		return err
+	}
+
+	// wrong old passwords count as login failures of the account
+	account := userAccount(&user)
+	err = checkLogin(c, errCollection, account, body.Captcha)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Clauses(LockingClause).Take(&user, userID).Error
+		if err != nil {
+			return err
+		}
+
+		ok, err := auth.CheckPassword(body.OldPassword, user.Password)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errCollection.ErrPasswordIncorrect
+		}
+
+		err = checkSecondFactor(tx, errCollection, user.ID, &body.SecondFactorModel)
+		if err != nil {
+			return err
+		}
+
+		user.Password, err = auth.MakePassword(body.NewPassword)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&user).Select("Password").Updates(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+	loginSucceeded(c, account)
+
+	err = token.RevokeTokens(user.ID)
+	if err != nil {
+		return err
+	}
+
+	access, refresh, err := token.NewSession(c, &user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(TokenResponse{
+		Access:  access,
+		Refresh: refresh,
+		Message: messageCollection.MessageChangePasswordSuccess,
+	})
+}
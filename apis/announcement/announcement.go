@@ -0,0 +1,222 @@
+package announcement
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm/clause"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// ListAnnouncements
+// @Summary list current announcements for the user, not dismissed
+// @Description login is optional, announcements targeting models or new users need login
+// @Tags announcement
+// @Router /announcements [get]
+// @Success 200 {array} Response
+func ListAnnouncements(c *fiber.Ctx) error {
+	var user *User
+	if userID, err := GetUserID(c); err == nil {
+		user, err = LoadUserByID(userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	region := RegionGlobal
+	if ok, _ := IsInChina(GetRealIP(c)); ok {
+		region = RegionCN
+	}
+
+	var announcements []Announcement
+	now := time.Now()
+	err := DB.Where("start_time <= ? AND end_time > ?", now, now).Order("start_time desc").Find(&announcements).Error
+	if err != nil {
+		return err
+	}
+
+	var dismissed []int
+	if user != nil {
+		err = DB.Model(&AnnouncementDismissal{}).Where("user_id = ?", user.ID).Pluck("announcement_id", &dismissed).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var responses = []Response{}
+	for i := range announcements {
+		announcement := &announcements[i]
+		if !announcement.Targets(user, region) {
+			continue
+		}
+		if announcement.Dismissible && slices.Contains(dismissed, announcement.ID) {
+			continue
+		}
+		title, content := announcement.Localize(GetLocale(c))
+		responses = append(responses, Response{
+			ID:          announcement.ID,
+			StartTime:   announcement.StartTime,
+			EndTime:     announcement.EndTime,
+			Severity:    announcement.Severity,
+			Title:       title,
+			Content:     content,
+			Dismissible: announcement.Dismissible,
+		})
+	}
+
+	return c.JSON(responses)
+}
+
+// DismissAnnouncement
+// @Summary dismiss an announcement, it will not be listed again for the user
+// @Tags announcement
+// @Router /announcements/{announcement_id}/dismiss [post]
+// @Param announcement_id path int true "announcement id"
+// @Success 204
+func DismissAnnouncement(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	announcementID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var announcement Announcement
+	err = DB.Take(&announcement, announcementID).Error
+	if err != nil {
+		return err
+	}
+	if !announcement.Dismissible {
+		return BadRequest().WithMessageID("announcement_undismissible")
+	}
+
+	err = DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&AnnouncementDismissal{UserID: userID, AnnouncementID: announcementID}).Error
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
+
+// ListAllAnnouncements
+// @Summary list all announcements, admin only
+// @Tags announcement
+// @Router /announcements/all [get]
+// @Success 200 {array} models.Announcement
+func ListAllAnnouncements(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var announcements = []Announcement{}
+	err = DB.Order("start_time desc").Find(&announcements).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(announcements)
+}
+
+// AddAnnouncement
+// @Summary add an announcement, admin only
+// @Tags announcement
+// @Router /announcements [post]
+// @Param json body CreateModel true "json"
+// @Success 201 {object} models.Announcement
+func AddAnnouncement(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body CreateModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	var announcement Announcement
+	body.apply(&announcement)
+	err = DB.Create(&announcement).Error
+	if err != nil {
+		return err
+	}
+
+	return c.Status(201).JSON(announcement)
+}
+
+// ModifyAnnouncement
+// @Summary replace an announcement, admin only
+// @Tags announcement
+// @Router /announcements/{announcement_id} [put]
+// @Param announcement_id path int true "announcement id"
+// @Param json body CreateModel true "json"
+// @Success 200 {object} models.Announcement
+func ModifyAnnouncement(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	announcementID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var body CreateModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	var announcement Announcement
+	err = DB.Take(&announcement, announcementID).Error
+	if err != nil {
+		return err
+	}
+
+	body.apply(&announcement)
+	err = DB.Save(&announcement).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(announcement)
+}
+
+// DeleteAnnouncement
+// @Summary delete an announcement, admin only
+// @Tags announcement
+// @Router /announcements/{announcement_id} [delete]
+// @Param announcement_id path int true "announcement id"
+// @Success 204
+func DeleteAnnouncement(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	announcementID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	err = DB.Where("announcement_id = ?", announcementID).Delete(&AnnouncementDismissal{}).Error
+	if err != nil {
+		return err
+	}
+	err = DB.Delete(&Announcement{}, announcementID).Error
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
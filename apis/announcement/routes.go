@@ -0,0 +1,14 @@
+package announcement
+
+import "github.com/gofiber/fiber/v2"
+
+func RegisterRoutes(routes fiber.Router) {
+	routes.Get("/announcements", ListAnnouncements)
+	routes.Post("/announcements/:id/dismiss", DismissAnnouncement)
+
+	// admin
+	routes.Get("/announcements/all", ListAllAnnouncements)
+	routes.Post("/announcements", AddAnnouncement)
+	routes.Put("/announcements/:id", ModifyAnnouncement)
+	routes.Delete("/announcements/:id", DeleteAnnouncement)
+}
@@ -0,0 +1,46 @@
+package announcement
+
+import (
+	"time"
+
+	"MOSS_backend/models"
+)
+
+type CreateModel struct {
+	StartTime          time.Time `json:"start_time" validate:"required"`
+	EndTime            time.Time `json:"end_time" validate:"required,gtfield=StartTime"`
+	Severity           string    `json:"severity" default:"info" validate:"oneof=info warning critical"`
+	Title              string    `json:"title" validate:"required"`
+	Content            string    `json:"content"`
+	TitleCN            string    `json:"title_cn"`
+	ContentCN          string    `json:"content_cn"`
+	Dismissible        bool      `json:"dismissible"`
+	ModelIDs           []int     `json:"model_ids" validate:"omitempty,dive,min=1"`
+	Region             string    `json:"region" validate:"omitempty,oneof=cn global"`
+	NewUsersWithinDays int       `json:"new_users_within_days" validate:"min=0"`
+}
+
+func (body *CreateModel) apply(announcement *models.Announcement) {
+	announcement.StartTime = body.StartTime
+	announcement.EndTime = body.EndTime
+	announcement.Severity = body.Severity
+	announcement.Title = body.Title
+	announcement.Content = body.Content
+	announcement.TitleCN = body.TitleCN
+	announcement.ContentCN = body.ContentCN
+	announcement.Dismissible = body.Dismissible
+	announcement.ModelIDs = body.ModelIDs
+	announcement.Region = body.Region
+	announcement.NewUsersWithinDays = body.NewUsersWithinDays
+}
+
+// Response is an announcement localized for the user
+type Response struct {
+	ID          int       `json:"id"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Severity    string    `json:"severity"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	Dismissible bool      `json:"dismissible"`
+}
@@ -11,9 +11,10 @@ import (
 )
 
 // ListChats
-// @Summary list user's chats
+// @Summary list user's chats, pinned first
 // @Tags chat
 // @Router /chats [get]
+// @Param object query ListChatsQuery false "filters"
 // @Success 200 {array} models.Chat
 func ListChats(c *fiber.Ctx) error {
 	userID, err := GetUserID(c)
@@ -21,15 +22,38 @@ func ListChats(c *fiber.Ctx) error {
 		return err
 	}
 
-	// delete empty chats
-	err = DB.Where("user_id = ? and count = 0", userID).Delete(&Chat{}).Error
+	var query ListChatsQuery
+	err = ValidateQuery(c, &query)
 	if err != nil {
 		return err
 	}
 
-	// get all chats
+	// get chats, empty chats are hidden and purged later
+	querySet := DB.Where("user_id = ? AND count > 0 AND archived = ?", userID, query.Archived)
+	if query.FolderID != nil {
+		if *query.FolderID == 0 {
+			querySet = querySet.Where("folder_id IS NULL")
+		} else {
+			querySet = querySet.Where("folder_id = ?", *query.FolderID)
+		}
+	}
+	if query.Pinned != nil {
+		querySet = querySet.Where("pinned = ?", *query.Pinned)
+	}
+	if query.Tag != "" {
+		querySet = querySet.Where("id IN (?)", DB.Model(&ChatTag{}).Select("chat_id").Where("name = ?", query.Tag))
+	}
+	if query.Name != "" {
+		querySet = querySet.Where("name LIKE ? ESCAPE '!'", "%"+EscapeLike(query.Name)+"%")
+	}
+
 	var chats = Chats{}
-	err = DB.Order("updated_at desc").Find(&chats, "user_id = ?", userID).Error
+	err = querySet.Order("pinned desc, updated_at desc").Find(&chats).Error
+	if err != nil {
+		return err
+	}
+
+	err = chats.LoadTags()
 	if err != nil {
 		return err
 	}
@@ -94,6 +118,25 @@ func ModifyChat(c *fiber.Ctx) error {
 
 		if body.Name != nil {
 			chat.Name = *body.Name
+			chat.NameModified = true
+		}
+		if body.FolderID != nil {
+			chat.FolderID, err = checkFolder(tx, userID, *body.FolderID)
+			if err != nil {
+				return err
+			}
+		}
+		if body.Pinned != nil {
+			chat.Pinned = *body.Pinned
+		}
+		if body.Archived != nil {
+			chat.Archived = *body.Archived
+		}
+		if body.Tags != nil {
+			err = chat.SetTags(tx, *body.Tags)
+			if err != nil {
+				return err
+			}
 		}
 
 		return tx.Save(&chat).Error
@@ -102,6 +145,15 @@ func ModifyChat(c *fiber.Ctx) error {
 		return err
 	}
 
+	if chat.Tags == nil {
+		var chats = Chats{chat}
+		err = chats.LoadTags()
+		if err != nil {
+			return err
+		}
+		chat = chats[0]
+	}
+
 	return c.JSON(chat)
 }
 
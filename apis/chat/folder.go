@@ -0,0 +1,244 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// checkFolder returns the folder id to set on chats, nil if folderID is 0
+func checkFolder(tx *gorm.DB, userID int, folderID int) (*int, error) {
+	if folderID == 0 {
+		return nil, nil
+	}
+	var folder ChatFolder
+	err := tx.Take(&folder, folderID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NotFound().WithMessageID("folder_not_found")
+		}
+		return nil, err
+	}
+	if folder.UserID != userID {
+		return nil, Forbidden()
+	}
+	return &folder.ID, nil
+}
+
+// ListFolders
+// @Summary list user's chat folders
+// @Tags chat
+// @Router /folders [get]
+// @Success 200 {array} models.ChatFolder
+func ListFolders(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var folders = []ChatFolder{}
+	err = DB.Order("id").Find(&folders, "user_id = ?", userID).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(folders)
+}
+
+// AddFolder
+// @Summary add a chat folder
+// @Tags chat
+// @Router /folders [post]
+// @Param json body FolderModel true "json"
+// @Success 201 {object} models.ChatFolder
+func AddFolder(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body FolderModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	folder := ChatFolder{UserID: userID, Name: body.Name}
+	err = DB.Create(&folder).Error
+	if err != nil {
+		return err
+	}
+
+	return c.Status(201).JSON(folder)
+}
+
+// ModifyFolder
+// @Summary rename a chat folder
+// @Tags chat
+// @Router /folders/{folder_id} [put]
+// @Param folder_id path int true "folder id"
+// @Param json body FolderModel true "json"
+// @Success 200 {object} models.ChatFolder
+func ModifyFolder(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	folderID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var body FolderModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	var folder ChatFolder
+	err = DB.Take(&folder, folderID).Error
+	if err != nil {
+		return err
+	}
+	if folder.UserID != userID {
+		return Forbidden()
+	}
+
+	folder.Name = body.Name
+	err = DB.Save(&folder).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(folder)
+}
+
+// DeleteFolder
+// @Summary delete a chat folder, its chats are moved out of it
+// @Tags chat
+// @Router /folders/{folder_id} [delete]
+// @Param folder_id path int true "folder id"
+// @Success 204
+func DeleteFolder(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	folderID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var folder ChatFolder
+		err = tx.Take(&folder, folderID).Error
+		if err != nil {
+			return err
+		}
+		if folder.UserID != userID {
+			return Forbidden()
+		}
+
+		err = tx.Unscoped().Model(&Chat{}).Where("folder_id = ?", folderID).UpdateColumn("folder_id", nil).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&folder).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
+
+// ModifyChats
+// @Summary move, pin or archive many chats
+// @Tags chat
+// @Router /chats [put]
+// @Param json body BulkModifyModel true "json"
+// @Success 200 {array} models.Chat
+func ModifyChats(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body BulkModifyModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	var chats = Chats{}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var updates = map[string]any{}
+		if body.FolderID != nil {
+			folderID, err := checkFolder(tx, userID, *body.FolderID)
+			if err != nil {
+				return err
+			}
+			updates["folder_id"] = folderID
+		}
+		if body.Pinned != nil {
+			updates["pinned"] = *body.Pinned
+		}
+		if body.Archived != nil {
+			updates["archived"] = *body.Archived
+		}
+		if len(updates) == 0 {
+			return BadRequest().WithMessageID("nothing_to_modify")
+		}
+
+		// UpdateColumns leaves updated_at as is, moving chats to a folder or archiving them does not reorder the chat list
+		err = tx.Model(&Chat{}).Where("user_id = ? AND id IN ?", userID, body.IDs).UpdateColumns(updates).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Order("pinned desc, updated_at desc").Find(&chats, "user_id = ? AND id IN ?", userID, body.IDs).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	err = chats.LoadTags()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(chats)
+}
+
+// DeleteChats
+// @Summary delete many chats
+// @Tags chat
+// @Router /chats [delete]
+// @Param json body BulkDeleteModel true "json"
+// @Success 204
+func DeleteChats(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var body BulkDeleteModel
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Where("user_id = ? AND id IN ?", userID, body.IDs).Delete(&Chat{}).Error
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
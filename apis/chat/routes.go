@@ -9,10 +9,22 @@ func RegisterRoutes(routes fiber.Router) {
 	// chat
 	routes.Get("/chats", ListChats)
 	routes.Post("/chats", AddChat)
+	routes.Put("/chats", ModifyChats)
+	routes.Delete("/chats", DeleteChats)
 	routes.Put("/chats/:id/regenerate", record.RetryRecord)
 	routes.Put("/chats/:id", ModifyChat)
 	routes.Delete("/chats/:id", DeleteChat)
+	routes.Post("/chats/:id/restore", RestoreChat)
 	routes.Get("/chats/:id/screenshots", GenerateChatScreenshot)
 
+	// trash
+	routes.Get("/trash/chats", ListTrashChats)
+
+	// folder
+	routes.Get("/folders", ListFolders)
+	routes.Post("/folders", AddFolder)
+	routes.Put("/folders/:id", ModifyFolder)
+	routes.Delete("/folders/:id", DeleteFolder)
+
 	routes.Static("/screenshots", "./screenshots")
 }
@@ -1,5 +1,44 @@
 package chat
 
+import (
+	"time"
+
+	"MOSS_backend/models"
+)
+
 type ModifyModel struct {
-	Name *string `json:"name" validate:"omitempty,min=1"`
+	Name     *string   `json:"name" validate:"omitempty,min=1"`
+	FolderID *int      `json:"folder_id" validate:"omitempty,min=0"` // 0 to remove from folder
+	Pinned   *bool     `json:"pinned"`
+	Archived *bool     `json:"archived"`
+	Tags     *[]string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=64"` // replace all tags
+}
+
+type ListChatsQuery struct {
+	FolderID *int   `json:"folder_id" query:"folder_id" validate:"omitempty,min=0"` // 0 for chats not in any folder
+	Pinned   *bool  `json:"pinned" query:"pinned"`
+	Archived bool   `json:"archived" query:"archived"` // list archived chats instead
+	Tag      string `json:"tag" query:"tag"`
+	Name     string `json:"name" query:"name"` // search by name
+}
+
+type BulkModifyModel struct {
+	IDs      []int `json:"ids" validate:"required,min=1,max=500"`
+	FolderID *int  `json:"folder_id" validate:"omitempty,min=0"` // 0 to remove from folder
+	Pinned   *bool `json:"pinned"`
+	Archived *bool `json:"archived"`
+}
+
+type BulkDeleteModel struct {
+	IDs []int `json:"ids" validate:"required,min=1,max=500"`
+}
+
+type FolderModel struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+}
+
+type TrashChatResponse struct {
+	models.Chat
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
 }
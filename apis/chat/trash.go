@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// ListTrashChats
+// @Summary list user's deleted chats, which are purged after the retention days
+// @Tags chat
+// @Router /trash/chats [get]
+// @Success 200 {array} TrashChatResponse
+func ListTrashChats(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var chats = Chats{}
+	err = DB.Unscoped().
+		Where("user_id = ? AND count > 0 AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at desc").
+		Find(&chats).Error
+	if err != nil {
+		return err
+	}
+
+	err = chats.LoadTags()
+	if err != nil {
+		return err
+	}
+
+	var response = make([]TrashChatResponse, 0, len(chats))
+	for _, chat := range chats {
+		response = append(response, TrashChatResponse{
+			Chat:      chat,
+			DeletedAt: chat.DeletedAt.Time,
+			PurgeAt:   TrashPurgeTime(chat.DeletedAt.Time),
+		})
+	}
+
+	return c.JSON(response)
+}
+
+// RestoreChat
+// @Summary restore a deleted chat
+// @Tags chat
+// @Router /chats/{chat_id}/restore [post]
+// @Param chat_id path int true "chat id"
+// @Success 200 {object} models.Chat
+func RestoreChat(c *fiber.Ctx) error {
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	chatID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var chat Chat
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Unscoped().Clauses(LockingClause).Take(&chat, chatID).Error
+		if err != nil {
+			return err
+		}
+
+		if chat.UserID != userID {
+			return Forbidden()
+		}
+		if !chat.DeletedAt.Valid {
+			return BadRequest().WithMessageID("chat_not_deleted")
+		}
+
+		chat.DeletedAt = gorm.DeletedAt{}
+		return tx.Unscoped().Model(&chat).UpdateColumn("deleted_at", nil).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	var chats = Chats{chat}
+	err = chats.LoadTags()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(chats[0])
+}
@@ -41,7 +41,7 @@ func GetConfig(c *fiber.Ctx) error {
 }
 
 // PatchConfig
-// @Summary update global config
+// @Summary update global config, admin only
 // @Tags Config
 // @Accept json
 // @Produce json
@@ -51,10 +51,15 @@ func GetConfig(c *fiber.Ctx) error {
 // @Failure 400 {object} Response
 // @Failure 500 {object} Response
 func PatchConfig(c *fiber.Ctx) error {
+	user, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
 	var configObject Config
-	err := LoadConfig(&configObject)
+	err = LoadConfig(&configObject)
 	if err != nil {
-		return InternalServerError("Failed to load config")
+		return InternalServerError().WithMessageID("config_load_failed")
 	}
 
 	var body ModifyModelConfigRequest
@@ -72,6 +77,9 @@ func PatchConfig(c *fiber.Ctx) error {
 	if body.Notice != nil {
 		configObject.Notice = *body.Notice
 	}
+	if body.AdminTotpRequired != nil {
+		configObject.AdminTotpRequired = *body.AdminTotpRequired
+	}
 	if body.ModelConfig != nil {
 		newModelCfg := body.ModelConfig
 		for _, newSingleCfg := range newModelCfg {
@@ -97,18 +105,67 @@ func PatchConfig(c *fiber.Ctx) error {
 						}
 
 					}
+					if newSingleCfg.Url != nil {
+						configObject.ModelConfig[i].Url = *(newSingleCfg.Url)
+					}
+					if newSingleCfg.Endpoints != nil {
+						configObject.ModelConfig[i].Endpoints = newSingleCfg.Endpoints
+					}
+					if newSingleCfg.MaxContextLength != nil {
+						configObject.ModelConfig[i].MaxContextLength = *(newSingleCfg.MaxContextLength)
+					}
+					if newSingleCfg.TruncateStrategy != nil {
+						configObject.ModelConfig[i].TruncateStrategy = *(newSingleCfg.TruncateStrategy)
+					}
+					if newSingleCfg.KeepLastTurns != nil {
+						configObject.ModelConfig[i].KeepLastTurns = *(newSingleCfg.KeepLastTurns)
+					}
+					if newSingleCfg.SummarizeThreshold != nil {
+						configObject.ModelConfig[i].SummarizeThreshold = *(newSingleCfg.SummarizeThreshold)
+					}
+					if newSingleCfg.SummaryKeepTurns != nil {
+						configObject.ModelConfig[i].SummaryKeepTurns = *(newSingleCfg.SummaryKeepTurns)
+					}
+					if newSingleCfg.GenerateTitle != nil {
+						configObject.ModelConfig[i].GenerateTitle = *(newSingleCfg.GenerateTitle)
+					}
 				}
 			}
 		}
 	}
 
+	if body.RateLimitRules != nil {
+		configObject.RateLimitRules = body.RateLimitRules
+	}
+
 	// 将更新后的 configObject 保存到数据库中
-	err = UpdateConfig(&configObject)
+	err = UpdateConfig(&configObject, user.ID, body.Comment)
 	if err != nil {
-		return InternalServerError("Failed to update config")
+		return InternalServerError().WithMessageID("config_update_failed")
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"success": "Config updated successfully",
 	})
 }
+
+// ReloadConfigs
+// @Summary reload config from database on all replicas, admin only
+// @Description for changes made to the database directly
+// @Tags Config
+// @Produce json
+// @Router /config/reload [post]
+// @Success 200 {object} ReloadResponse
+func ReloadConfigs(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	err = InvalidateConfig()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(ReloadResponse{Version: ConfigVersion()})
+}
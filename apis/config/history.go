@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+func loadConfigHistory(c *fiber.Ctx) (*ConfigHistory, error) {
+	version, err := c.ParamsInt("version")
+	if err != nil {
+		return nil, err
+	}
+
+	var history ConfigHistory
+	err = DB.Take(&history, "version = ?", version).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NotFound().WithMessageID("config_version_not_found")
+		}
+		return nil, err
+	}
+	return &history, nil
+}
+
+// ListConfigVersions
+// @Summary list config change history, admin only
+// @Tags Config
+// @Produce json
+// @Router /config/versions [get]
+// @Success 200 {array} models.ConfigHistory
+func ListConfigVersions(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var histories = []ConfigHistory{}
+	err = DB.Omit("snapshot").Order("version desc").Find(&histories).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(histories)
+}
+
+// GetConfigVersion
+// @Summary get a config version with its snapshot, admin only
+// @Tags Config
+// @Produce json
+// @Router /config/versions/{version} [get]
+// @Param version path int true "version"
+// @Success 200 {object} models.ConfigHistory
+func GetConfigVersion(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	history, err := loadConfigHistory(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(history)
+}
+
+// RollbackConfig
+// @Summary roll back config to a previous version, admin only
+// @Description the rollback is recorded as a new version. Models added after the version are kept.
+// @Tags Config
+// @Accept json
+// @Produce json
+// @Router /config/versions/{version}/rollback [post]
+// @Param version path int true "version"
+// @Param json body RollbackRequest false "body"
+// @Success 200 {object} ReloadResponse
+func RollbackConfig(c *fiber.Ctx) error {
+	user, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body RollbackRequest
+	err = ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	history, err := loadConfigHistory(c)
+	if err != nil {
+		return err
+	}
+	if history.Snapshot == nil {
+		return BadRequest().WithMessageID("config_snapshot_not_found")
+	}
+
+	comment := fmt.Sprintf("rollback to version %d", history.Version)
+	if body.Comment != "" {
+		comment += ": " + body.Comment
+	}
+	err = UpdateConfig(history.Snapshot, user.ID, comment)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(ReloadResponse{Version: ConfigVersion()})
+}
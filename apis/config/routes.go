@@ -6,4 +6,10 @@ func RegisterRoutes(routes fiber.Router) {
 	routes.Get("/config", GetConfig)
 	// redis update & config update
 	routes.Patch("/config", PatchConfig)
+	routes.Post("/config/reload", ReloadConfigs)
+
+	// history
+	routes.Get("/config/versions", ListConfigVersions)
+	routes.Get("/config/versions/:version", GetConfigVersion)
+	routes.Post("/config/versions/:version/rollback", RollbackConfig)
 }
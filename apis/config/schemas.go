@@ -9,6 +9,10 @@ type Response struct {
 	ModelConfig    []ModelConfigResponse `json:"model_config"`
 }
 
+type ReloadResponse struct {
+	Version int `json:"version"`
+}
+
 type ModelConfigResponse struct {
 	ID                  int             `json:"id"`
 	Description         string          `json:"description"`
@@ -28,15 +32,30 @@ func FromModelConfig(modelConfig []models.ModelConfig) []ModelConfigResponse {
 }
 
 type ModelConfigRequest struct {
-	ID                       *int            `json:"id" validate:"min=1"`
-	InnerThoughtsPostprocess *bool           `json:"inner_thoughts_postprocess" validate:"omitempty,oneof=true false"`
-	Description              *string         `json:"description" validate:"omitempty"`
-	DefaultPluginConfig      *map[string]bool `json:"default_plugin_config" validate:"omitempty"`
+	ID                       *int                  `json:"id" validate:"required,min=1"`
+	InnerThoughtsPostprocess *bool                 `json:"inner_thoughts_postprocess" validate:"omitempty,oneof=true false"`
+	Description              *string               `json:"description" validate:"omitempty"`
+	DefaultPluginConfig      *map[string]bool      `json:"default_plugin_config" validate:"omitempty"`
+	Url                      *string               `json:"url" validate:"omitempty,url"`
+	Endpoints                models.ModelEndpoints `json:"endpoints" validate:"omitempty,dive"` // replace the whole endpoint pool if not null
+	MaxContextLength         *int                  `json:"max_context_length" validate:"omitempty,min=0"`
+	TruncateStrategy         *string               `json:"truncate_strategy" validate:"omitempty,oneof=drop_oldest keep_last_n summarize"`
+	KeepLastTurns            *int                  `json:"keep_last_turns" validate:"omitempty,min=0"`
+	SummarizeThreshold       *int                  `json:"summarize_threshold" validate:"omitempty,min=0"`
+	SummaryKeepTurns         *int                  `json:"summary_keep_turns" validate:"omitempty,min=0"`
+	GenerateTitle            *bool                 `json:"generate_title" validate:"omitempty,oneof=true false"`
 }
 
 type ModifyModelConfigRequest struct {
-	InviteRequired *bool                 `json:"invite_required" validate:"omitempty,oneof=true false"`
-	OffenseCheck   *bool                 `json:"offense_check" validate:"omitempty,oneof=true false"`
-	Notice         *string               `json:"notice" validate:"omitempty"`
-	ModelConfig    []*ModelConfigRequest `json:"model_config" validate:"omitempty"`
+	InviteRequired    *bool                  `json:"invite_required" validate:"omitempty,oneof=true false"`
+	OffenseCheck      *bool                  `json:"offense_check" validate:"omitempty,oneof=true false"`
+	Notice            *string                `json:"notice" validate:"omitempty"`
+	AdminTotpRequired *bool                  `json:"admin_totp_required" validate:"omitempty,oneof=true false"`
+	ModelConfig       []*ModelConfigRequest  `json:"model_config" validate:"omitempty,dive"`
+	RateLimitRules    []models.RateLimitRule `json:"rate_limit_rules" validate:"omitempty"` // replace all rules if not null
+	Comment           string                 `json:"comment"`                               // recorded in config history
+}
+
+type RollbackRequest struct {
+	Comment string `json:"comment"`
 }
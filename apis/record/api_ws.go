@@ -1,45 +1,26 @@
 package record
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
-	"sync"
 	"sync/atomic"
-	"time"
 
 	"MOSS_backend/config"
 	. "MOSS_backend/models"
 	. "MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
 	"MOSS_backend/utils/sensitive"
+	"MOSS_backend/utils/token"
 
 	"github.com/gofiber/websocket/v2"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-var userLockMap sync.Map
-
-type UserLockValue struct {
-	LockTime time.Time
-}
-
-func UserLockCheck() {
-	ticker := time.NewTicker(time.Hour)
-	for range ticker.C {
-		userLockMap.Range(func(key, value interface{}) bool {
-			userLockValue := value.(UserLockValue)
-			// delete lock before 1 minute
-			if userLockValue.LockTime.Before(time.Now().Add(-time.Minute)) {
-				userLockMap.Delete(key)
-			}
-			return true
-		})
-	}
-}
-
 // AddRecordAsync
 // @Summary add a record
 // @Tags Websocket
@@ -55,6 +36,7 @@ func AddRecordAsync(c *websocket.Conn) {
 		user    *User
 		banned  bool
 		chat    Chat
+		titleCh <-chan string
 	)
 
 	defer func() {
@@ -63,10 +45,11 @@ func AddRecordAsync(c *websocket.Conn) {
 				"client websocket return with error",
 				zap.Error(err),
 			)
-			response := InferResponseModel{Status: -1, Output: err.Error()}
+			response := InferResponseModel{Status: -1, Output: LocalizeError(err, wsLocale(c))}
 			var httpError *HttpError
 			if errors.As(err, &httpError) {
 				response.StatusCode = httpError.Code
+				response.RetryAfter = httpError.RetryAfter
 			}
 			_ = c.WriteJSON(response)
 		}
@@ -75,7 +58,7 @@ func AddRecordAsync(c *websocket.Conn) {
 	procedure := func() error {
 		// get chatID
 		if chatID, err = strconv.Atoi(c.Params("id")); err != nil {
-			return BadRequest("invalid chat_id")
+			return BadRequest().WithMessageID("invalid_chat_id")
 		}
 
 		// read body
@@ -91,35 +74,31 @@ func AddRecordAsync(c *websocket.Conn) {
 		}
 
 		if body.Request == "" {
-			return BadRequest("request is empty")
+			return BadRequest().WithMessageID("request_empty")
 		}
 		//if len([]rune(body.Request)) > 2048 {
 		//	return maxInputExceededError
 		//}
 
 		// get user id
-		user, err = LoadUserFromWs(c)
+		user, err = token.LoadUserFromWs(c)
 		if err != nil {
 			return Unauthorized()
 		}
 
 		// check user lock
-		if _, ok := userLockMap.LoadOrStore(user.ID, UserLockValue{LockTime: time.Now()}); ok {
+		unlock, ok := lockUser(user.ID)
+		if !ok {
 			return userRequestingError
 		}
-		defer userLockMap.Delete(user.ID)
-
-		// infer limiter
-		if !inferLimiter.Allow() {
-			return unknownError
-		}
+		defer unlock()
 
 		banned, err = user.CheckUserOffense()
 		if err != nil {
 			return err
 		}
 		if banned {
-			return Forbidden(OffenseMessage)
+			return ErrUserBanned
 		}
 
 		// load chat
@@ -133,6 +112,16 @@ func AddRecordAsync(c *websocket.Conn) {
 			return Forbidden()
 		}
 
+		// wait in the infer queue until admitted or the client leaves
+		watcher := watchWs(c)
+		defer watcher.stop()
+		var release func()
+		release, err = admitInfer(watcher, user, user.ModelID, queueReporter(c))
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		record := Record{
 			ChatID:  chatID,
 			Request: body.Request,
@@ -150,7 +139,7 @@ func AddRecordAsync(c *websocket.Conn) {
 			if banned {
 				err = c.WriteJSON(InferResponseModel{
 					Status: -2, // banned
-					Output: OffenseMessage,
+					Output: i18n.T(wsLocale(c), "user_banned"),
 				})
 			} else {
 				err = c.WriteJSON(InferResponseModel{
@@ -164,28 +153,32 @@ func AddRecordAsync(c *websocket.Conn) {
 		} else {
 			/* infer */
 
-			// find record prefix to make dialogs, without sensitive content
+			// find old records to make dialogs, without sensitive content
 			var oldRecords Records
-			err = DB.Last(&oldRecords, "chat_id = ? AND request_sensitive = ? AND response_sensitive = ?", chatID, false, false).Error
+			err = DB.Find(&oldRecords, "chat_id = ? AND request_sensitive = ? AND response_sensitive = ?", chatID, false, false).Error
 			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 				return err
 			}
 
+			prefix, postRecords := chatContext(&chat, oldRecords)
+
 			// async infer
 			err = InferAsync(
 				c,
-				oldRecords.GetPrefix(),
+				watcher,
+				prefix,
 				&record,
-				oldRecords.ToRecordModel(),
+				postRecords,
 				user,
 				body.Param,
 			)
 			if err != nil && !errors.Is(err, ErrSensitive) {
-				//if httpError, ok := err.(*HttpError); ok && httpError.MessageType == MaxLength {
-				//	DB.Model(&chat).Update("max_length_exceeded", true)
-				//}
+				if errors.Is(err, maxInputExceededFromInferError) {
+					DB.Model(&chat).Update("max_length_exceeded", true)
+				}
 				return err
 			}
+			consumeTokens(user, record.TokenCount)
 		}
 
 		// store into database
@@ -200,16 +193,22 @@ func AddRecordAsync(c *websocket.Conn) {
 				return err
 			}
 
-			if chat.Count == 0 {
+			if chat.Count == 0 && !chat.NameModified {
 				chat.Name = StripContent(record.Request, config.Config.ChatNameLength)
 			}
 			chat.Count += 1
+			chat.MaxLengthExceeded = false
 			return tx.Save(&chat).Error
 		})
 		if err != nil {
 			return err
 		}
 
+		if chat.Count == 1 && !chat.NameModified {
+			titleCh = generateTitle(chat.ID, user, &record)
+		}
+		go summarizeChat(chat.ID, user)
+
 		// return a total record structure
 		err = c.WriteJSON(record)
 		if err != nil {
@@ -220,6 +219,11 @@ func AddRecordAsync(c *websocket.Conn) {
 	}
 
 	err = procedure()
+
+	// notify the generated title, after the user lock and queue slot are released
+	if err == nil && titleCh != nil {
+		sendTitle(c, titleCh)
+	}
 }
 
 // RegenerateAsync
@@ -243,9 +247,10 @@ func RegenerateAsync(c *websocket.Conn) {
 				"client websocket return with error",
 				zap.Error(err),
 			)
-			response := InferResponseModel{Status: -1, Output: err.Error()}
+			response := InferResponseModel{Status: -1, Output: LocalizeError(err, wsLocale(c))}
 			if httpError, ok := err.(*HttpError); ok {
 				response.StatusCode = httpError.Code
+				response.RetryAfter = httpError.RetryAfter
 			}
 			err = c.WriteJSON(response)
 			if err != nil {
@@ -257,32 +262,28 @@ func RegenerateAsync(c *websocket.Conn) {
 	procedure := func() error {
 		// get chatID
 		if chatID, err = strconv.Atoi(c.Params("id")); err != nil {
-			return BadRequest("invalid chat_id")
+			return BadRequest().WithMessageID("invalid_chat_id")
 		}
 
 		// get user id
-		user, err = LoadUserFromWs(c)
+		user, err = token.LoadUserFromWs(c)
 		if err != nil {
 			return Unauthorized()
 		}
 
 		// check user lock
-		if _, ok := userLockMap.LoadOrStore(user.ID, UserLockValue{LockTime: time.Now()}); ok {
+		unlock, ok := lockUser(user.ID)
+		if !ok {
 			return userRequestingError
 		}
-		defer userLockMap.Delete(user.ID)
-
-		// infer limiter
-		if !inferLimiter.Allow() {
-			return unknownError
-		}
+		defer unlock()
 
 		banned, err = user.CheckUserOffense()
 		if err != nil {
 			return err
 		}
 		if banned {
-			return Forbidden(OffenseMessage)
+			return ErrUserBanned
 		}
 
 		// load chat
@@ -296,6 +297,16 @@ func RegenerateAsync(c *websocket.Conn) {
 			return Forbidden()
 		}
 
+		// wait in the infer queue until admitted or the client leaves
+		watcher := watchWs(c)
+		defer watcher.stop()
+		var release func()
+		release, err = admitInfer(watcher, user, user.ModelID, queueReporter(c))
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		// get the latest record
 		var oldRecord Record
 		err = DB.Last(&oldRecord, "chat_id = ?", chatID).Error
@@ -312,7 +323,7 @@ func RegenerateAsync(c *websocket.Conn) {
 				if banned {
 					err = c.WriteJSON(InferResponseModel{
 						Status: -2, // banned
-						Output: OffenseMessage,
+						Output: i18n.T(wsLocale(c), "user_banned"),
 					})
 				} else {
 					err = c.WriteJSON(InferResponseModel{
@@ -327,35 +338,39 @@ func RegenerateAsync(c *websocket.Conn) {
 		}
 
 		record := Record{
-			ChatID:  chatID,
-			Request: oldRecord.Request,
+			ChatID:            chatID,
+			Request:           oldRecord.Request,
+			RegeneratedFromID: oldRecord.ID,
 		}
 
 		/* infer */
 
 		// find old records to make dialogs, without sensitive content
 		var oldRecords Records
-		err = DB.Last(&oldRecords, "chat_id = ? AND request_sensitive = false AND response_sensitive = false AND id < ?", chatID, oldRecord.ID).Error
+		err = DB.Find(&oldRecords, "chat_id = ? AND request_sensitive = false AND response_sensitive = false AND id < ?", chatID, oldRecord.ID).Error
 		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
 
+		prefix, postRecords := chatContext(&chat, oldRecords)
+
 		// async infer
 		err = InferAsync(
 			c,
-			oldRecords.GetPrefix(),
+			watcher,
+			prefix,
 			&record,
-			oldRecords.ToRecordModel(),
+			postRecords,
 			user,
 			nil,
 		)
 		if err != nil && !errors.Is(err, ErrSensitive) {
-			//
-			//if httpError, ok := err.(*HttpError); ok && httpError.MessageType == MaxLength {
-			//	DB.Model(&chat).Update("max_length_exceeded", true)
-			//}
+			if errors.Is(err, maxInputExceededFromInferError) {
+				DB.Model(&chat).Update("max_length_exceeded", true)
+			}
 			return err
 		}
+		consumeTokens(user, record.TokenCount)
 
 		// store into database
 		err = DB.Transaction(func(tx *gorm.DB) error {
@@ -374,12 +389,15 @@ func RegenerateAsync(c *websocket.Conn) {
 				return err
 			}
 
+			chat.MaxLengthExceeded = false
 			return tx.Save(&chat).Error
 		})
 		if err != nil {
 			return err
 		}
 
+		go summarizeChat(chat.ID, user)
+
 		// return a total record structure
 		err = c.WriteJSON(record)
 		if err != nil {
@@ -392,20 +410,41 @@ func RegenerateAsync(c *websocket.Conn) {
 	err = procedure()
 }
 
-func interrupt(c *websocket.Conn, interruptChan chan any, connectionClosed *atomic.Bool) {
+// wsWatcher reads the websocket in one goroutine after the request is received. It is done when the client
+// interrupts or disconnects, so that the request leaves the infer queue or the inference stops.
+type wsWatcher struct {
+	context.Context
+	cancel           context.CancelFunc
+	connectionClosed *atomic.Bool // if set, stop all goroutines
+}
+
+func watchWs(c *websocket.Conn) *wsWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &wsWatcher{Context: ctx, cancel: cancel, connectionClosed: new(atomic.Bool)}
+	go w.interrupt(c)
+	return w
+}
+
+// stop is called when the request returns
+func (w *wsWatcher) stop() {
+	w.connectionClosed.Store(true)
+	w.cancel()
+}
+
+func (w *wsWatcher) interrupt(c *websocket.Conn) {
 	var message []byte
 	var err error
-	defer connectionClosed.Store(true)
+	defer w.connectionClosed.Store(true)
+	defer w.cancel()
 	for {
-		if connectionClosed.Load() {
+		if w.connectionClosed.Load() {
 			return
 		}
 		if _, message, err = c.ReadMessage(); err != nil {
-			if connectionClosed.Load() {
+			if w.connectionClosed.Load() {
 				return
 			}
 			Logger.Error("receive from client error", zap.Error(err))
-			close(interruptChan)
 			return
 		}
 
@@ -421,7 +460,6 @@ func interrupt(c *websocket.Conn, interruptChan chan any, connectionClosed *atom
 		}
 
 		if interruptModel.Interrupt {
-			close(interruptChan)
 			return
 		}
 	}
@@ -446,10 +484,11 @@ func InferWithoutLoginAsync(c *websocket.Conn) {
 				"client websocket return with error",
 				zap.Error(err),
 			)
-			response := InferResponseModel{Status: -1, Output: err.Error()}
+			response := InferResponseModel{Status: -1, Output: LocalizeError(err, wsLocale(c))}
 			var httpError *HttpError
 			if errors.As(err, &httpError) {
 				response.StatusCode = httpError.Code
+				response.RetryAfter = httpError.RetryAfter
 			}
 			_ = c.WriteJSON(response)
 		}
@@ -470,16 +509,21 @@ func InferWithoutLoginAsync(c *websocket.Conn) {
 		}
 
 		if body.Request == "" {
-			return BadRequest("request is empty")
+			return BadRequest().WithMessageID("request_empty")
 		}
 		//if len([]rune(body.Request)) > 2048 {
 		//	return maxInputExceededError
 		//}
 
-		// infer limiter
-		if !inferLimiter.Allow() {
-			return unknownError
+		// wait in the infer queue until admitted or the client leaves
+		watcher := watchWs(c)
+		defer watcher.stop()
+		var release func()
+		release, err = admitInfer(watcher, nil, body.ModelID, queueReporter(c))
+		if err != nil {
+			return err
 		}
+		defer release()
 
 		// sensitive request check
 		if sensitive.IsSensitive(body.Context, &User{}) {
@@ -497,6 +541,7 @@ func InferWithoutLoginAsync(c *websocket.Conn) {
 			record.Request = body.Request
 			err = InferAsync(
 				c,
+				watcher,
 				body.Context,
 				&record,
 				body.Records,
@@ -510,11 +555,13 @@ func InferWithoutLoginAsync(c *websocket.Conn) {
 
 		// store into database
 		directRecord := DirectRecord{
-			Duration:  record.Duration,
-			Context:   record.Prefix,
-			Request:   record.Request,
-			Response:  record.Response,
-			ExtraData: record.ExtraData,
+			Duration:         record.Duration,
+			ConsumerUsername: c.Headers("X-Consumer-Username"),
+			ModelID:          record.ModelID,
+			Context:          record.Prefix,
+			Request:          record.Request,
+			Response:         record.Response,
+			ExtraData:        record.ExtraData,
 		}
 		_ = DB.Create(&directRecord).Error
 
@@ -2,7 +2,6 @@ package record
 
 import (
 	"errors"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/exp/slices"
@@ -71,7 +70,7 @@ func AddRecord(c *fiber.Ctx) error {
 	}
 
 	if body.Request == "" {
-		return BadRequest("request is empty")
+		return BadRequest().WithMessageID("request_empty")
 	}
 	//if len([]rune(body.Request)) > 2048 {
 	//	return maxInputExceededError
@@ -83,22 +82,18 @@ func AddRecord(c *fiber.Ctx) error {
 	}
 
 	// check user lock
-	if _, ok := userLockMap.LoadOrStore(user.ID, UserLockValue{LockTime: time.Now()}); ok {
+	unlock, ok := lockUser(user.ID)
+	if !ok {
 		return userRequestingError
 	}
-	defer userLockMap.Delete(user.ID)
-
-	// infer limiter
-	if !inferLimiter.Allow() {
-		return unknownError
-	}
+	defer unlock()
 
 	banned, err := user.CheckUserOffense()
 	if err != nil {
 		return err
 	}
 	if banned {
-		return Forbidden(OffenseMessage)
+		return ErrUserBanned
 	}
 
 	var chat Chat
@@ -112,6 +107,13 @@ func AddRecord(c *fiber.Ctx) error {
 		return Forbidden()
 	}
 
+	// wait in the infer queue until admitted
+	release, err := admitInfer(c.Context(), user, user.ModelID, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	record := Record{
 		ChatID:  chatID,
 		Request: body.Request,
@@ -127,7 +129,7 @@ func AddRecord(c *fiber.Ctx) error {
 			return err
 		}
 		if banned {
-			return Forbidden(OffenseMessage)
+			return ErrUserBanned
 		}
 	} else {
 		/* infer */
@@ -139,21 +141,23 @@ func AddRecord(c *fiber.Ctx) error {
 			return err
 		}
 
+		prefix, postRecords := chatContext(&chat, oldRecords)
+
 		// infer request
 		err = Infer(
 			&record,
-			oldRecords.GetPrefix(),
-			oldRecords.ToRecordModel(),
+			prefix,
+			postRecords,
 			user,
 			body.Param,
 		)
 		if err != nil {
-			//if errors.Is(err, maxLengthExceededError) {
-			//	chat.MaxLengthExceeded = true
-			//	DB.Save(&chat)
-			//}
+			if errors.Is(err, maxInputExceededFromInferError) {
+				DB.Model(&chat).Update("max_length_exceeded", true)
+			}
 			return err
 		}
+		consumeTokens(user, record.TokenCount)
 
 		if sensitive.IsSensitive(record.Response, user) {
 			record.ResponseSensitive = true
@@ -163,7 +167,7 @@ func AddRecord(c *fiber.Ctx) error {
 				return err
 			}
 			if banned {
-				return Forbidden(OffenseMessage)
+				return ErrUserBanned
 			}
 		}
 	}
@@ -179,16 +183,22 @@ func AddRecord(c *fiber.Ctx) error {
 			return err
 		}
 
-		if chat.Count == 0 {
+		if chat.Count == 0 && !chat.NameModified {
 			chat.Name = StripContent(record.Request, config.Config.ChatNameLength)
 		}
 		chat.Count += 1
+		chat.MaxLengthExceeded = false
 		return tx.Save(&chat).Error
 	})
 	if err != nil {
 		return err
 	}
 
+	if chat.Count == 1 && !chat.NameModified {
+		generateTitle(chat.ID, user, &record)
+	}
+	go summarizeChat(chat.ID, user)
+
 	return Serialize(c.Status(201), &record)
 }
 
@@ -216,22 +226,18 @@ func RetryRecord(c *fiber.Ctx) error {
 	}
 
 	// check user lock
-	if _, ok := userLockMap.LoadOrStore(user.ID, UserLockValue{LockTime: time.Now()}); ok {
+	unlock, ok := lockUser(user.ID)
+	if !ok {
 		return userRequestingError
 	}
-	defer userLockMap.Delete(user.ID)
-
-	// infer limiter
-	if !inferLimiter.Allow() {
-		return unknownError
-	}
+	defer unlock()
 
 	banned, err := user.CheckUserOffense()
 	if err != nil {
 		return err
 	}
 	if banned {
-		return Forbidden(OffenseMessage)
+		return ErrUserBanned
 	}
 
 	// permission
@@ -239,6 +245,13 @@ func RetryRecord(c *fiber.Ctx) error {
 		return Forbidden()
 	}
 
+	// wait in the infer queue until admitted
+	release, err := admitInfer(c.Context(), user, user.ModelID, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// get the latest record
 	var oldRecord Record
 	err = DB.Last(&oldRecord, "chat_id = ?", chat.ID).Error
@@ -253,7 +266,7 @@ func RetryRecord(c *fiber.Ctx) error {
 				return err
 			}
 			if banned {
-				return Forbidden(OffenseMessage)
+				return ErrUserBanned
 			}
 
 			// old record request is sensitive
@@ -275,21 +288,23 @@ func RetryRecord(c *fiber.Ctx) error {
 		return err
 	}
 
+	prefix, postRecords := chatContext(&chat, oldRecords)
+
 	// infer request
 	err = Infer(
 		&record,
-		oldRecords.GetPrefix(),
-		oldRecords.ToRecordModel(),
+		prefix,
+		postRecords,
 		user,
 		nil,
 	)
 	if err != nil {
-		//if errors.Is(err, maxLengthExceededError) {
-		//	chat.MaxLengthExceeded = true
-		//	DB.Save(&chat)
-		//}
+		if errors.Is(err, maxInputExceededFromInferError) {
+			DB.Model(&chat).Update("max_length_exceeded", true)
+		}
 		return err
 	}
+	consumeTokens(user, record.TokenCount)
 
 	if sensitive.IsSensitive(record.Response, user) {
 		record.ResponseSensitive = true
@@ -299,7 +314,7 @@ func RetryRecord(c *fiber.Ctx) error {
 			return err
 		}
 		if banned {
-			return Forbidden(OffenseMessage)
+			return ErrUserBanned
 		}
 	}
 
@@ -319,12 +334,15 @@ func RetryRecord(c *fiber.Ctx) error {
 			return err
 		}
 
+		chat.MaxLengthExceeded = false
 		return tx.Save(&chat).Error
 	})
 	if err != nil {
 		return err
 	}
 
+	go summarizeChat(chat.ID, user)
+
 	return Serialize(c, &record)
 }
 
@@ -352,7 +370,7 @@ func ModifyRecord(c *fiber.Ctx) error {
 		return err
 	}
 
-	if body.Feedback == nil && body.Like == nil {
+	if body.Feedback == nil && body.Like == nil && body.Categories == nil && body.Rating == nil && body.CorrectedResponse == nil {
 		return BadRequest()
 	}
 
@@ -381,7 +399,21 @@ func ModifyRecord(c *fiber.Ctx) error {
 			record.LikeData = *body.Like
 		}
 
-		return tx.Model(&record).Select("Feedback", "LikeData").Updates(&record).Error
+		if body.Categories != nil {
+			record.FeedbackCategories = *body.Categories
+		}
+
+		if body.Rating != nil {
+			record.Rating = *body.Rating
+		}
+
+		if body.CorrectedResponse != nil {
+			record.CorrectedResponse = *body.CorrectedResponse
+		}
+
+		return tx.Model(&record).
+			Select("Feedback", "LikeData", "FeedbackCategories", "Rating", "CorrectedResponse").
+			Updates(&record).Error
 	})
 
 	if err != nil {
@@ -405,22 +437,24 @@ func InferWithoutLogin(c *fiber.Ctx) error {
 	}
 
 	if body.Request == "" {
-		return BadRequest("request is empty")
+		return BadRequest().WithMessageID("request_empty")
 	}
 	//if len([]rune(body.Request)) > 2048 {
 	//	return maxInputExceededError
 	//}
 
-	// infer limiter
-	if !inferLimiter.Allow() {
-		return unknownError
+	// wait in the infer queue until admitted
+	release, err := admitInfer(c.Context(), nil, body.ModelID, nil)
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	consumerUsername := c.Get("X-Consumer-Username")
 	passSensitiveCheck := slices.Contains(config.Config.PassSensitiveCheckUsername, consumerUsername)
 
 	if !passSensitiveCheck && sensitive.IsSensitive(body.Context, &User{}) {
-		return BadRequest(DefaultResponse).WithMessageType(Sensitive)
+		return BadRequest().WithMessageID("sensitive_request").WithMessageType(Sensitive)
 	}
 
 	record := Record{Request: body.Request}
@@ -437,12 +471,13 @@ func InferWithoutLogin(c *fiber.Ctx) error {
 	}
 
 	if !passSensitiveCheck && sensitive.IsSensitive(record.Response, &User{}) {
-		return BadRequest(DefaultResponse).WithMessageType(Sensitive)
+		return BadRequest().WithMessageID("sensitive_request").WithMessageType(Sensitive)
 	}
 
 	directRecord := DirectRecord{
 		Duration:         record.Duration,
 		ConsumerUsername: consumerUsername,
+		ModelID:          record.ModelID,
 		Context:          record.Prefix,
 		Request:          record.Request,
 		Response:         record.Response,
@@ -0,0 +1,253 @@
+package record
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+type breakerBucket struct {
+	second   int64
+	success  int
+	failures int
+}
+
+// breakerProbe is taken by a request allowed in half-open state, and passed back to Record or Cancel.
+// It is the round of the half-open state, 0 for requests allowed in closed state.
+type breakerProbe uint64
+
+// circuitBreaker counts results in a sliding window of one-second buckets.
+// It opens when the failure ratio of the window or the consecutive failures exceed the threshold,
+// lets a few probe requests through after the open duration (half-open),
+// and closes again when all probes succeed. Results of other requests do not change the half-open state.
+type circuitBreaker struct {
+	sync.Mutex
+	model    string // labels
+	endpoint string
+
+	maxConsecutiveFailures int // 0 to disable
+
+	state               breakerState
+	buckets             []breakerBucket // ring buffer
+	consecutiveFailures int
+	openUntil           time.Time
+	round               breakerProbe // increased whenever probes are given out again
+	probes              int          // requests allowed in this round
+	probeSuccesses      int
+}
+
+var modelBreakers sync.Map // key: model id, value: *circuitBreaker
+
+func newCircuitBreaker(model, endpoint string, maxConsecutiveFailures int) *circuitBreaker {
+	b := &circuitBreaker{
+		model:                  model,
+		endpoint:               endpoint,
+		maxConsecutiveFailures: maxConsecutiveFailures,
+		buckets:                make([]breakerBucket, max(1, config.Config.BreakerWindow)),
+	}
+	b.setState(breakerClosed)
+	return b
+}
+
+func loadModelBreaker(model *ModelConfig) *circuitBreaker {
+	value, ok := modelBreakers.Load(model.ID)
+	if ok {
+		return value.(*circuitBreaker)
+	}
+	value, _ = modelBreakers.LoadOrStore(model.ID, newCircuitBreaker(model.Description, "", 0))
+	return value.(*circuitBreaker)
+}
+
+// modelBreaker returns the breaker of the model once requests are recorded, 0 for the default model
+func modelBreaker(modelID int) (*circuitBreaker, bool) {
+	if modelID == 0 {
+		modelID = config.Config.DefaultModelID
+	}
+	value, ok := modelBreakers.Load(modelID)
+	if !ok {
+		return nil, false
+	}
+	return value.(*circuitBreaker), true
+}
+
+// modelAvailable checks the breaker of the model before a request is queued
+func modelAvailable(modelID int) bool {
+	breaker, ok := modelBreaker(modelID)
+	return !ok || breaker.available()
+}
+
+// setState must be called with lock held
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state != state {
+		Logger.Warn(
+			"circuit breaker state changed",
+			zap.String("model", b.model),
+			zap.String("endpoint", b.endpoint),
+			zap.Stringer("from", b.state),
+			zap.Stringer("to", state),
+		)
+	}
+	b.state = state
+	circuitBreakerStateGauge.WithLabelValues(b.model, b.endpoint).Set(float64(state))
+}
+
+// available reports whether Allow may pass, without taking a probe
+func (b *circuitBreaker) available() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return !time.Now().Before(b.openUntil)
+	case breakerHalfOpen:
+		return b.probes < config.Config.BreakerHalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. In half-open state it takes a probe,
+// which must be passed to Record with the result, or to Cancel if the request is not sent.
+func (b *circuitBreaker) Allow() (probe breakerProbe, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	if b.state == breakerOpen {
+		if now.Before(b.openUntil) {
+			return 0, false
+		}
+		b.newRound(now)
+		b.setState(breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.probes >= config.Config.BreakerHalfOpenRequests {
+			if now.Before(b.openUntil) {
+				return 0, false
+			}
+			// probes lost without result, try again
+			b.newRound(now)
+		}
+		b.probes++
+		return b.round, true
+	}
+	return 0, true
+}
+
+// Cancel returns a probe taken by Allow
+func (b *circuitBreaker) Cancel(probe breakerProbe) {
+	b.Lock()
+	defer b.Unlock()
+	if b.isProbe(probe) && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// Record counts the result of a request, with the probe taken by Allow
+func (b *circuitBreaker) Record(success bool, probe breakerProbe) {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now().Unix()
+	bucket := &b.buckets[now%int64(len(b.buckets))]
+	if bucket.second != now {
+		*bucket = breakerBucket{second: now}
+	}
+	if success {
+		bucket.success++
+		b.consecutiveFailures = 0
+	} else {
+		bucket.failures++
+		b.consecutiveFailures++
+	}
+
+	switch b.state {
+	case breakerHalfOpen:
+		if !b.isProbe(probe) {
+			// sent before the breaker opened, or a probe of an earlier round
+			return
+		}
+		if !success {
+			b.trip()
+		} else if b.probeSuccesses++; b.probeSuccesses >= config.Config.BreakerHalfOpenRequests {
+			b.reset()
+		}
+	case breakerClosed:
+		if b.maxConsecutiveFailures > 0 && b.consecutiveFailures >= b.maxConsecutiveFailures {
+			b.trip()
+			return
+		}
+		var total, failures int
+		for _, item := range b.buckets {
+			if now-item.second < int64(len(b.buckets)) {
+				total += item.success + item.failures
+				failures += item.failures
+			}
+		}
+		if total >= config.Config.BreakerMinRequests && float64(failures)/float64(total) > config.Config.BreakerFailureRatio {
+			b.trip()
+		}
+	}
+}
+
+// Ready ends the open duration early, e.g. on successful health check. The breaker turns half-open
+// on the next Allow, and is closed only after probes with real requests succeed.
+func (b *circuitBreaker) Ready() {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == breakerOpen {
+		b.openUntil = time.Now()
+	}
+}
+
+// newRound gives out the probes again, must be called with lock held
+func (b *circuitBreaker) newRound(now time.Time) {
+	b.round++
+	b.probes = 0
+	b.probeSuccesses = 0
+	b.openUntil = now.Add(time.Duration(config.Config.BreakerOpenDuration) * time.Second) // probe deadline
+}
+
+// isProbe reports whether the probe is taken in this round of half-open state, must be called with lock held
+func (b *circuitBreaker) isProbe(probe breakerProbe) bool {
+	return b.state == breakerHalfOpen && probe != 0 && probe == b.round
+}
+
+// trip must be called with lock held
+func (b *circuitBreaker) trip() {
+	b.openUntil = time.Now().Add(time.Duration(config.Config.BreakerOpenDuration) * time.Second)
+	b.consecutiveFailures = 0
+	b.setState(breakerOpen)
+	circuitBreakerTripCounter.WithLabelValues(b.model, b.endpoint).Inc()
+}
+
+// reset must be called with lock held
+func (b *circuitBreaker) reset() {
+	clear(b.buckets)
+	b.consecutiveFailures = 0
+	b.probes = 0
+	b.probeSuccesses = 0
+	b.setState(breakerClosed)
+}
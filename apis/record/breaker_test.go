@@ -0,0 +1,184 @@
+package record
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"MOSS_backend/config"
+)
+
+func setBreakerConfig(t *testing.T) {
+	saved := config.Config
+	t.Cleanup(func() { config.Config = saved })
+	config.Config.BreakerWindow = 30
+	config.Config.BreakerMinRequests = 4
+	config.Config.BreakerFailureRatio = 0.5
+	config.Config.BreakerOpenDuration = 60
+	config.Config.BreakerHalfOpenRequests = 2
+}
+
+// halfOpen trips the breaker and ends its open duration, the probe turning it half-open is returned
+func halfOpen(t *testing.T, b *circuitBreaker) breakerProbe {
+	b.Lock()
+	b.trip()
+	b.Unlock()
+	b.Ready()
+	probe, ok := b.Allow()
+	if !ok || probe == 0 || b.state != breakerHalfOpen {
+		t.Fatalf("breaker %v, want half-open", b.state)
+	}
+	return probe
+}
+
+func TestBreakerOpensOnConsecutiveFailures(t *testing.T) {
+	setBreakerConfig(t)
+	config.Config.BreakerMinRequests = 100
+	b := newCircuitBreaker("test", "consecutive", 3)
+
+	b.Record(false, 0)
+	b.Record(false, 0)
+	b.Record(true, 0) // resets consecutive failures
+	b.Record(false, 0)
+	b.Record(false, 0)
+	if b.state != breakerClosed {
+		t.Fatalf("breaker %v after 2 consecutive failures, want closed", b.state)
+	}
+	b.Record(false, 0)
+	if b.state != breakerOpen {
+		t.Fatalf("breaker %v after 3 consecutive failures, want open", b.state)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("open breaker allowed a request")
+	}
+	if b.available() {
+		t.Fatal("open breaker available")
+	}
+}
+
+func TestBreakerOpensOnFailureRatio(t *testing.T) {
+	setBreakerConfig(t)
+	b := newCircuitBreaker("test", "ratio", 0)
+
+	b.Record(true, 0)
+	b.Record(false, 0)
+	b.Record(false, 0)
+	if b.state != breakerClosed {
+		t.Fatalf("breaker %v below min requests, want closed", b.state)
+	}
+	b.Record(true, 0)
+	if b.state != breakerClosed {
+		t.Fatalf("breaker %v at failure ratio 0.5, want closed", b.state)
+	}
+	b.Record(false, 0)
+	if b.state != breakerOpen {
+		t.Fatalf("breaker %v at failure ratio 0.6, want open", b.state)
+	}
+}
+
+func TestBreakerHalfOpenCloses(t *testing.T) {
+	setBreakerConfig(t)
+	b := newCircuitBreaker("test", "close", 0)
+	first := halfOpen(t, b)
+	second, ok := b.Allow()
+	if !ok {
+		t.Fatal("half-open breaker gave no second probe")
+	}
+	if _, ok = b.Allow(); ok {
+		t.Fatal("half-open breaker allowed more requests than probes")
+	}
+	if b.available() {
+		t.Fatal("half-open breaker available without probes left")
+	}
+
+	b.Record(true, first)
+	if b.state != breakerHalfOpen {
+		t.Fatalf("breaker %v after one probe succeeded, want half-open", b.state)
+	}
+	b.Record(true, second)
+	if b.state != breakerClosed {
+		t.Fatalf("breaker %v after all probes succeeded, want closed", b.state)
+	}
+	if probe, ok := b.Allow(); !ok || probe != 0 {
+		t.Fatal("closed breaker gave a probe or rejected a request")
+	}
+}
+
+func TestBreakerHalfOpenReopens(t *testing.T) {
+	setBreakerConfig(t)
+	b := newCircuitBreaker("test", "reopen", 0)
+	probe := halfOpen(t, b)
+	b.Record(false, probe)
+	if b.state != breakerOpen {
+		t.Fatalf("breaker %v after a probe failed, want open", b.state)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("reopened breaker allowed a request")
+	}
+}
+
+func TestBreakerIgnoresResultsOfOtherRequests(t *testing.T) {
+	setBreakerConfig(t)
+	b := newCircuitBreaker("test", "stale", 0)
+	stale := halfOpen(t, b)
+
+	// the probes of the earlier round are lost, and given out again
+	probe := halfOpen(t, b)
+
+	// requests sent before the breaker opened, and probes of the earlier round
+	b.Record(true, 0)
+	b.Record(true, 0)
+	b.Record(true, stale)
+	b.Record(false, 0)
+	b.Record(false, stale)
+	if b.state != breakerHalfOpen {
+		t.Fatalf("breaker %v after results without probes, want half-open", b.state)
+	}
+
+	b.Cancel(stale)
+	b.Cancel(0)
+	if b.probes != 1 {
+		t.Fatalf("%d probes taken after cancelling results without probes, want 1", b.probes)
+	}
+	b.Cancel(probe)
+	if b.probes != 0 {
+		t.Fatalf("%d probes taken after cancelling the probe, want 0", b.probes)
+	}
+}
+
+func TestBreakerReady(t *testing.T) {
+	setBreakerConfig(t)
+	b := newCircuitBreaker("test", "ready", 0)
+	b.Lock()
+	b.trip()
+	b.Unlock()
+
+	b.Ready()
+	if b.state != breakerOpen || !b.available() {
+		t.Fatalf("breaker %v, want open and available after ready", b.state)
+	}
+	// a healthy endpoint is probed by requests, not closed at once
+	b.Ready()
+	if _, ok := b.Allow(); !ok || b.state != breakerHalfOpen {
+		t.Fatalf("breaker %v, want half-open", b.state)
+	}
+}
+
+func TestHealthCheckFailuresUseThreshold(t *testing.T) {
+	setBreakerConfig(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	state := &endpointState{url: server.URL, healthUrl: server.URL, weight: 1, breaker: newCircuitBreaker("test", server.URL, 3)}
+
+	checkEndpoint("test", state)
+	checkEndpoint("test", state)
+	if state.breaker.state != breakerClosed {
+		t.Fatalf("breaker %v after 2 failed health checks, want closed", state.breaker.state)
+	}
+	checkEndpoint("test", state)
+	if state.breaker.state != breakerOpen {
+		t.Fatalf("breaker %v after 3 failed health checks, want open", state.breaker.state)
+	}
+}
@@ -0,0 +1,170 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+const (
+	mossTurnSeparator  = "<|Human|>:"
+	summaryMarker      = "Summary of the earlier conversation: "
+	summaryInstruction = "请简要总结以下对话的要点，保留人名、数字、结论等关键信息，不超过 200 字。Summarize the key points of the following conversation briefly."
+
+	// maxTruncateRetries is the times to retry with a smaller context when the inference server reports 400
+	maxTruncateRetries = 2
+)
+
+func renderTurn(postRecord RecordModel) string {
+	return fmt.Sprintf("User: %s\nAssistant: %s\n", postRecord.Request, postRecord.Response)
+}
+
+// splitMossPrefix splits a MOSS prompt into the meta instruction, the summary of dropped turns and the turns
+func splitMossPrefix(prefix string) (system, summary string, turns []string) {
+	index := strings.Index(prefix, mossTurnSeparator)
+	if index < 0 {
+		system = prefix
+	} else {
+		system = prefix[:index]
+		for _, turn := range strings.Split(prefix[index+len(mossTurnSeparator):], mossTurnSeparator) {
+			turns = append(turns, mossTurnSeparator+turn)
+		}
+	}
+	system, summary, _ = strings.Cut(system, summaryMarker)
+	summary = strings.TrimSuffix(summary, "\n")
+	return
+}
+
+// keepTurns returns the index of the first turn to keep within budget
+func keepTurns(model *ModelConfig, costs []int, budget int) int {
+	start := 0
+	if model.TruncateStrategy == TruncateKeepLastN && model.KeepLastTurns > 0 {
+		start = max(0, len(costs)-model.KeepLastTurns)
+	}
+	var total int
+	for _, cost := range costs[start:] {
+		total += cost
+	}
+	for start < len(costs) && total > budget {
+		total -= costs[start]
+		start++
+	}
+	return start
+}
+
+// summarizeContext summarizes dropped turns in a slot of its own.
+// The request is holding a slot already, so the summary does not wait in the queue:
+// if no slot is free the turns are dropped as drop_oldest, keeping the earlier summary.
+func summarizeContext(model *ModelConfig, content string) (string, error) {
+	if !modelAvailable(model.ID) {
+		return "", inferUnavailableError
+	}
+	release, ok := inferQueue.TryAcquire()
+	if !ok {
+		return "", errors.New("no inference slot free for summary")
+	}
+	defer release()
+	return inferPlain(model, summaryInstruction, content)
+}
+
+// fitContext truncates the history to the context limit of the model.
+// Each level halves the limit, for retrying after the inference server rejects the input as too long.
+// The summary of the chat or of dropped turns is put into the prefix for MOSS models, and returned for openai models.
+func fitContext(
+	model *ModelConfig,
+	prefix string,
+	postRecords RecordModels,
+	request string,
+	level int,
+) (
+	newPrefix string,
+	newPostRecords RecordModels,
+	summary string,
+) {
+	system, summary, turns := splitMossPrefix(prefix)
+	if model.MaxContextLength <= 0 && level == 0 {
+		return prefix, postRecords, summary
+	}
+
+	if model.APIType == APITypeOpenAI {
+		system = model.OpenAISystemPrompt
+		turns = make([]string, 0, len(postRecords))
+		for _, postRecord := range postRecords {
+			turns = append(turns, renderTurn(postRecord))
+		}
+	}
+
+	costs := make([]int, len(turns))
+	var total int
+	for i, turn := range turns {
+		costs[i] = EstimateTokens(turn)
+		total += costs[i]
+	}
+	fixedCost := EstimateTokens(system) + EstimateTokens(request)
+
+	limit := model.MaxContextLength
+	if limit <= 0 {
+		limit = fixedCost + EstimateTokens(summary) + total
+	}
+	budget := limit>>level - fixedCost
+	if model.TruncateStrategy == TruncateSummarize {
+		budget -= budget / 4 // room for summary
+	} else {
+		budget -= EstimateTokens(summary)
+	}
+
+	start := keepTurns(model, costs, budget)
+	if start == 0 {
+		return prefix, postRecords, summary
+	}
+
+	Logger.Info(
+		"truncate context",
+		zap.String("model", model.Description),
+		zap.Int("level", level),
+		zap.Int("turns", len(turns)),
+		zap.Int("dropped", start),
+	)
+
+	if model.TruncateStrategy == TruncateSummarize {
+		var builder strings.Builder
+		if summary != "" {
+			builder.WriteString(summaryMarker)
+			builder.WriteString(summary)
+			builder.WriteString("\n")
+		}
+		for _, turn := range turns[:start] {
+			builder.WriteString(turn)
+		}
+		newSummary, err := summarizeContext(model, builder.String())
+		if err != nil {
+			Logger.Error("summarize context error", zap.String("model", model.Description), zap.Error(err))
+		} else {
+			summary = newSummary
+		}
+	}
+
+	if model.APIType == APITypeOpenAI {
+		return prefix, postRecords[start:], summary
+	}
+
+	var builder strings.Builder
+	builder.WriteString(system)
+	if summary != "" {
+		if system != "" && !strings.HasSuffix(system, "\n") {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(summaryMarker)
+		builder.WriteString(summary)
+		builder.WriteString("\n")
+	}
+	for _, turn := range turns[start:] {
+		builder.WriteString(turn)
+	}
+	return builder.String(), postRecords, summary
+}
@@ -0,0 +1,237 @@
+package record
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
+)
+
+// sftTurn is a turn in MOSS SFT format, with the feedback of the user
+type sftTurn struct {
+	Human         string `json:"Human"`
+	InnerThoughts string `json:"Inner Thoughts"`
+	Commands      string `json:"Commands"`
+	ToolResponses string `json:"Tool Responses"`
+	MOSS          string `json:"MOSS"`
+	RecordID      int    `json:"record_id,omitempty"`
+	ModelID       int    `json:"model_id,omitempty"`
+	LikeData      int    `json:"like_data"`
+	Feedback      string `json:"feedback,omitempty"`
+}
+
+// sftConversation is a line of the dataset, a chat or a direct record
+type sftConversation struct {
+	ConversationID  string             `json:"conversation_id"`
+	MetaInstruction string             `json:"meta_instruction"`
+	NumTurns        int                `json:"num_turns"`
+	Chat            map[string]sftTurn `json:"chat"`
+}
+
+var sftSegments = []struct {
+	tag string
+	end string
+}{
+	{"<|Human|>:", "<eoh>"},
+	{"<|Inner Thoughts|>:", "<eot>"},
+	{"<|Commands|>:", "<eoc>"},
+	{"<|Results|>:", "<eor>"},
+	{"<|MOSS|>:", "<eom>"},
+}
+
+// parseSFTTurn splits the MOSS text of a turn into the segments of SFT format
+func parseSFTTurn(raw string, scrub bool) (turn sftTurn) {
+	fields := []*string{&turn.Human, &turn.InnerThoughts, &turn.Commands, &turn.ToolResponses, &turn.MOSS}
+	for i, segment := range sftSegments {
+		start := strings.Index(raw, segment.tag)
+		if start < 0 {
+			*fields[i] = segment.tag + " None" + segment.end + "\n"
+			continue
+		}
+		text := raw[start:]
+		if end := strings.Index(text, segment.end); end >= 0 {
+			text = text[:end+len(segment.end)]
+		}
+		if scrub {
+			text = ScrubPII(text)
+		}
+		*fields[i] = text + "\n"
+	}
+	return
+}
+
+func (conversation *sftConversation) addTurn(turn sftTurn) {
+	conversation.NumTurns++
+	conversation.Chat[fmt.Sprintf("turn_%d", conversation.NumTurns)] = turn
+}
+
+// ExportDataset
+// @Summary export records of users who consented to share as a dataset, admin only
+// @Description JSONL in MOSS SFT format, a chat each line. Sensitive, deleted records and chats are excluded.
+// If the export fails after it started, the last line is {"error": message} and the file is incomplete.
+// @Tags record
+// @Produce application/jsonl
+// @Router /records/dataset [get]
+// @Param object query DatasetQuery false "query"
+// @Success 200
+func ExportDataset(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var query DatasetQuery
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	// errors of the first query are returned before the response starts
+	rows, err := query.filter(consentedRecords(DB), "record").
+		Order("record.chat_id, record.id").
+		Rows()
+	if err != nil {
+		return err
+	}
+
+	locale := GetLocale(c)
+	c.Set(fiber.HeaderContentType, "application/jsonl")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="moss-dataset.jsonl"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		err := exportChatRecords(encoder, rows, &query)
+		if err == nil && query.IncludeDirect {
+			err = exportDirectRecords(encoder, &query)
+		}
+		if err != nil {
+			Logger.Error("export dataset error", zap.Error(err))
+			writeExportError(encoder, locale)
+		}
+		_ = w.Flush()
+	})
+	return nil
+}
+
+// exportError is the last line of an export failed after the response started,
+// telling the file is incomplete as the status code can no longer change
+type exportError struct {
+	Error string `json:"error"`
+}
+
+func writeExportError(encoder *json.Encoder, locale string) {
+	_ = encoder.Encode(exportError{Error: i18n.T(locale, "export_interrupted")})
+}
+
+// consentedRecords selects non-sensitive records in chats of users who consented to share
+func consentedRecords(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&Record{}).
+		Select("record.*").
+		Joins("JOIN chat ON chat.id = record.chat_id AND chat.deleted_at IS NULL").
+		Joins("JOIN user ON user.id = chat.user_id AND user.deleted_at IS NULL AND user.share_consent = ?", true).
+		Where("record.request_sensitive = ? AND record.response_sensitive = ?", false, false)
+}
+
+// exportChatRecords writes the chats of rows ordered by chat, and closes rows
+func exportChatRecords(encoder *json.Encoder, rows *sql.Rows, query *DatasetQuery) error {
+	defer func() { _ = rows.Close() }()
+
+	var conversation *sftConversation
+	var chatID int
+	for rows.Next() {
+		var record Record
+		err := DB.ScanRows(rows, &record)
+		if err != nil {
+			return err
+		}
+
+		if conversation == nil || record.ChatID != chatID {
+			if conversation != nil {
+				err = encoder.Encode(conversation)
+				if err != nil {
+					return err
+				}
+			}
+			system, _, _ := splitMossPrefix(record.Prefix)
+			conversation = &sftConversation{
+				ConversationID:  fmt.Sprintf("chat-%d", record.ChatID),
+				MetaInstruction: system,
+				Chat:            map[string]sftTurn{},
+			}
+			chatID = record.ChatID
+		}
+
+		turn := parseSFTTurn(mossRawContent(&record), query.Scrub)
+		turn.RecordID = record.ID
+		turn.ModelID = record.ModelID
+		turn.LikeData = record.LikeData
+		turn.Feedback = record.Feedback
+		if query.Scrub {
+			turn.Feedback = ScrubPII(turn.Feedback)
+		}
+		conversation.addTurn(turn)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if conversation != nil {
+		return encoder.Encode(conversation)
+	}
+	return nil
+}
+
+func exportDirectRecords(encoder *json.Encoder, query *DatasetQuery) error {
+	if len(config.Config.DatasetConsumers) == 0 {
+		return nil
+	}
+
+	rows, err := query.filter(DB.Model(&DirectRecord{}), "direct_record").
+		Where("consumer_username IN ?", config.Config.DatasetConsumers).
+		Order("id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var record DirectRecord
+		err = DB.ScanRows(rows, &record)
+		if err != nil {
+			return err
+		}
+
+		// the context contains the turns before and the turn of this record
+		system, _, turns := splitMossPrefix(record.Context)
+		if len(turns) == 0 {
+			turns = []string{mossRawContent(&Record{Request: record.Request, Response: record.Response})}
+		}
+		conversation := sftConversation{
+			ConversationID:  fmt.Sprintf("direct-%d", record.ID),
+			MetaInstruction: system,
+			Chat:            map[string]sftTurn{},
+		}
+		for _, text := range turns {
+			turn := parseSFTTurn(text, query.Scrub)
+			turn.ModelID = record.ModelID
+			conversation.addTurn(turn)
+		}
+
+		err = encoder.Encode(conversation)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,192 @@
+package record
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// endpointState is immutable once stored, it is replaced with the breaker kept if the config is changed
+type endpointState struct {
+	url       string
+	healthUrl string
+	weight    int
+
+	breaker *circuitBreaker
+}
+
+var endpointStates sync.Map // key: url, value: *endpointState
+
+// endpointAttempt is a request sent to an endpoint, holding the probes taken from the breakers
+type endpointAttempt struct {
+	*endpointState
+	probe      breakerProbe // of the endpoint breaker
+	modelProbe breakerProbe // of the model breaker
+}
+
+var errNoEndpoint = InternalServerError().WithMessageID("infer_unavailable")
+
+func loadEndpointState(modelName string, endpoint ModelEndpoint) *endpointState {
+	weight := endpoint.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	healthUrl := endpoint.HealthUrl
+	if healthUrl == "" {
+		healthUrl = endpoint.Url
+	}
+	value, ok := endpointStates.Load(endpoint.Url)
+	if !ok {
+		value, _ = endpointStates.LoadOrStore(endpoint.Url, &endpointState{
+			url:       endpoint.Url,
+			healthUrl: healthUrl,
+			weight:    weight,
+			breaker:   newCircuitBreaker(modelName, endpoint.Url, config.Config.EndpointMaxFailures),
+		})
+	}
+	state := value.(*endpointState)
+	for state.weight != weight || state.healthUrl != healthUrl {
+		// config is changed
+		updated := &endpointState{
+			url:       endpoint.Url,
+			healthUrl: healthUrl,
+			weight:    weight,
+			breaker:   state.breaker,
+		}
+		if endpointStates.CompareAndSwap(endpoint.Url, state, updated) {
+			return updated
+		}
+		// replaced by another goroutine
+		value, _ = endpointStates.Load(endpoint.Url)
+		state = value.(*endpointState)
+	}
+	return state
+}
+
+// reportSuccess records a successful request to this endpoint of the model
+func (e *endpointAttempt) reportSuccess(model *ModelConfig, latency time.Duration) {
+	inferSuccessCounter.Inc()
+	e.breaker.Record(true, e.probe)
+	loadModelBreaker(model).Record(true, e.modelProbe)
+	endpointRequestCounter.WithLabelValues(model.Description, e.url, "success").Inc()
+	endpointLatencyHistogram.WithLabelValues(model.Description, e.url).Observe(latency.Seconds())
+}
+
+// reportFailure records a 5xx or timeout of this endpoint of the model
+func (e *endpointAttempt) reportFailure(model *ModelConfig, latency time.Duration) {
+	inferFailureCounter.Inc()
+	e.breaker.Record(false, e.probe)
+	loadModelBreaker(model).Record(false, e.modelProbe)
+	endpointRequestCounter.WithLabelValues(model.Description, e.url, "failure").Inc()
+	endpointLatencyHistogram.WithLabelValues(model.Description, e.url).Observe(latency.Seconds())
+}
+
+// pickEndpoint chooses an endpoint of the model by weight, skipping tried ones and those with open breakers,
+// and takes the breakers of the endpoint and the model for the request about to be sent.
+// Every attempt must be reported with reportSuccess or reportFailure.
+func pickEndpoint(model *ModelConfig, tried map[string]bool) (*endpointAttempt, error) {
+	var candidates []*endpointState
+	for _, endpoint := range model.EndpointPool() {
+		if tried[endpoint.Url] {
+			continue
+		}
+		state := loadEndpointState(model.Description, endpoint)
+		if state.breaker.available() {
+			candidates = append(candidates, state)
+		}
+	}
+
+	for len(candidates) > 0 {
+		var totalWeight int
+		for _, candidate := range candidates {
+			totalWeight += candidate.weight
+		}
+		index := len(candidates) - 1
+		n := rand.Intn(totalWeight)
+		for i, candidate := range candidates {
+			n -= candidate.weight
+			if n < 0 {
+				index = i
+				break
+			}
+		}
+		chosen := candidates[index]
+
+		probe, ok := chosen.breaker.Allow()
+		if !ok {
+			// the probes of the half-open breaker are taken since checked, try the others
+			candidates = slices.Delete(candidates, index, index+1)
+			continue
+		}
+		modelProbe, ok := loadModelBreaker(model).Allow()
+		if !ok {
+			chosen.breaker.Cancel(probe)
+			return nil, inferUnavailableError
+		}
+		return &endpointAttempt{endpointState: chosen, probe: probe, modelProbe: modelProbe}, nil
+	}
+	return nil, errNoEndpoint
+}
+
+// maxInferAttempts is the number of endpoints a request may try
+func maxInferAttempts(model *ModelConfig) int {
+	return max(1, min(len(model.EndpointPool()), config.Config.InferMaxRetries+1))
+}
+
+// isRetryableError reports whether err is a timeout or connection error
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+var healthCheckClient = http.Client{Timeout: 5 * time.Second}
+
+// EndpointHealthCheck actively checks all inference endpoints periodically.
+// An endpoint answering with status code < 500 is considered healthy, and probed by requests at once if its breaker is open.
+// Failed checks count as failed requests, so the breaker opens on the same thresholds.
+func EndpointHealthCheck() {
+	interval := time.Duration(config.Config.EndpointHealthCheckInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		modelConfigs, err := LoadModelConfigs()
+		if err != nil {
+			Logger.Error("health check load model config error", zap.Error(err))
+			continue
+		}
+		for _, model := range modelConfigs {
+			for _, endpoint := range model.EndpointPool() {
+				go checkEndpoint(model.Description, loadEndpointState(model.Description, endpoint))
+			}
+		}
+	}
+}
+
+func checkEndpoint(modelName string, state *endpointState) {
+	rsp, err := healthCheckClient.Get(state.healthUrl)
+	if err != nil {
+		endpointHealthCheckCounter.WithLabelValues(modelName, state.url, "error").Inc()
+		state.breaker.Record(false, 0)
+		return
+	}
+	_ = rsp.Body.Close()
+	endpointHealthCheckCounter.WithLabelValues(modelName, state.url, strconv.Itoa(rsp.StatusCode)).Inc()
+	if rsp.StatusCode >= 500 {
+		state.breaker.Record(false, 0)
+	} else {
+		state.breaker.Ready()
+	}
+}
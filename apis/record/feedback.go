@@ -0,0 +1,246 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+var feedbackGroupColumns = map[string]string{
+	"model": "CAST(model_id AS CHAR)",
+	"tools": "tools",
+	"date":  "CAST(DATE(created_at) AS CHAR)",
+}
+
+// FeedbackAnalytics
+// @Summary aggregate likes, ratings and feedback categories of records, admin only
+// @Description records replaced by regeneration are included
+// @Tags record
+// @Router /analytics/feedback [get]
+// @Param object query FeedbackAnalyticsQuery false "query"
+// @Success 200 {array} FeedbackAnalyticsItem
+func FeedbackAnalytics(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var query FeedbackAnalyticsQuery
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+	if query.GroupBy == "" {
+		query.GroupBy = "model"
+	}
+	column := feedbackGroupColumns[query.GroupBy]
+
+	fields := []string{
+		column + " AS group_key",
+		"COUNT(*) AS total",
+		"SUM(CASE WHEN like_data = 1 THEN 1 ELSE 0 END) AS likes",
+		"SUM(CASE WHEN like_data = -1 THEN 1 ELSE 0 END) AS dislikes",
+		"SUM(CASE WHEN rating > 0 THEN 1 ELSE 0 END) AS rated",
+		"COALESCE(AVG(CASE WHEN rating > 0 THEN rating END), 0) AS average_rating",
+		"SUM(CASE WHEN corrected_response <> '' THEN 1 ELSE 0 END) AS corrected",
+	}
+	for _, category := range FeedbackCategories {
+		fields = append(fields, fmt.Sprintf(`SUM(CASE WHEN feedback_categories LIKE '%%"%s"%%' THEN 1 ELSE 0 END) AS %s`, category, category))
+	}
+
+	var items = []FeedbackAnalyticsItem{}
+	err = query.filter(DB.Unscoped().Model(&Record{}), "record").
+		Select(strings.Join(fields, ", ")).
+		Group(column).
+		Order(column).
+		Scan(&items).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if items[i].Total > 0 {
+			items[i].LikeRate = float64(items[i].Likes) / float64(items[i].Total)
+			items[i].DislikeRate = float64(items[i].Dislikes) / float64(items[i].Total)
+		}
+	}
+
+	return c.JSON(items)
+}
+
+// ExportPreferencePairs
+// @Summary export preference pairs from regenerated and corrected answers of users who consented to share, admin only
+// @Description JSONL, a pair each line
+// If the export fails after it started, the last line is {"error": message} and the file is incomplete.
+// @Tags record
+// @Produce application/jsonl
+// @Router /records/preference-pairs [get]
+// @Param object query PreferencePairsQuery false "query"
+// @Success 200 {object} PreferencePair
+func ExportPreferencePairs(c *fiber.Ctx) error {
+	_, err := LoadAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var query PreferencePairsQuery
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	// errors of the first batch are returned before the response starts
+	records, err := preferencePairsBatch(&query, 0)
+	if err != nil {
+		return err
+	}
+
+	locale := GetLocale(c)
+	c.Set(fiber.HeaderContentType, "application/jsonl")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="moss-preference-pairs.jsonl"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		err := exportPreferencePairs(encoder, &query, records)
+		if err != nil {
+			Logger.Error("export preference pairs error", zap.Error(err))
+			writeExportError(encoder, locale)
+		}
+		_ = w.Flush()
+	})
+	return nil
+}
+
+const preferencePairsBatchSize = 500
+
+// preferencePairsBatch loads the next batch of records having pairs, with ids greater than afterID
+func preferencePairsBatch(query *PreferencePairsQuery, afterID int) (records Records, err error) {
+	// replaced records are soft deleted and kept, records trashed by users are left out
+	err = query.filter(consentedRecords(DB.Unscoped()), "record").
+		Where("record.regenerated_from_id > 0 OR record.corrected_response <> ''").
+		Where("record.deleted_at IS NULL OR EXISTS (SELECT 1 FROM record AS successor WHERE successor.regenerated_from_id = record.id)").
+		Where("record.id > ?", afterID).
+		Order("record.id").
+		Limit(preferencePairsBatchSize).
+		Find(&records).Error
+	return
+}
+
+// exportPreferencePairs writes the pairs of the first batch of records and of the batches after it
+func exportPreferencePairs(encoder *json.Encoder, query *PreferencePairsQuery, records Records) (err error) {
+	for len(records) > 0 {
+		err = encodePreferencePairs(encoder, query, records)
+		if err != nil {
+			return err
+		}
+		if len(records) < preferencePairsBatchSize {
+			return nil
+		}
+		records, err = preferencePairsBatch(query, records[len(records)-1].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodePreferencePairs writes the pairs of a batch of records, loading the replaced records at once
+func encodePreferencePairs(encoder *json.Encoder, query *PreferencePairsQuery, records Records) error {
+	oldIDs := make([]int, 0, len(records))
+	for _, record := range records {
+		if record.RegeneratedFromID > 0 {
+			oldIDs = append(oldIDs, record.RegeneratedFromID)
+		}
+	}
+	oldRecords := make(map[int]*Record, len(oldIDs))
+	if len(oldIDs) > 0 {
+		var olds Records
+		err := DB.Unscoped().Where("id IN ?", oldIDs).Find(&olds).Error
+		if err != nil {
+			return err
+		}
+		for i := range olds {
+			oldRecords[olds[i].ID] = &olds[i]
+		}
+	}
+
+	for i := range records {
+		record := &records[i]
+		pair := PreferencePair{RecordID: record.ID, Request: record.Request}
+		var history string
+		pair.MetaInstruction, _, history = splitRecordPrefix(record.Prefix)
+		pair.History = history
+
+		var pairs []PreferencePair
+		if record.CorrectedResponse != "" {
+			correction := pair
+			correction.Source = "correction"
+			correction.Explicit = true
+			correction.Chosen = record.CorrectedResponse
+			correction.Rejected = record.Response
+			pairs = append(pairs, correction)
+		}
+
+		if old, ok := oldRecords[record.RegeneratedFromID]; ok && !old.RequestSensitive && !old.ResponseSensitive {
+			regeneration := pair
+			regeneration.Source = "regeneration"
+			regeneration.RejectedID = old.ID
+			var preferNew bool
+			preferNew, regeneration.Explicit = preferRegenerated(record, old)
+			if preferNew {
+				regeneration.Chosen, regeneration.Rejected = record.Response, old.Response
+			} else {
+				regeneration.RecordID, regeneration.RejectedID = old.ID, record.ID
+				regeneration.Chosen, regeneration.Rejected = old.Response, record.Response
+			}
+			pairs = append(pairs, regeneration)
+		}
+
+		for _, p := range pairs {
+			if query.ExplicitOnly && !p.Explicit {
+				continue
+			}
+			if query.Scrub {
+				p.History = ScrubPII(p.History)
+				p.Request = ScrubPII(p.Request)
+				p.Chosen = ScrubPII(p.Chosen)
+				p.Rejected = ScrubPII(p.Rejected)
+			}
+			err := encoder.Encode(p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitRecordPrefix splits the prefix saved in a record into the meta instruction and the turns before the record
+func splitRecordPrefix(prefix string) (system, summary, history string) {
+	system, summary, turns := splitMossPrefix(prefix)
+	if len(turns) > 0 {
+		// the prefix ends with the turn of the record itself
+		history = strings.Join(turns[:len(turns)-1], "")
+	}
+	return
+}
+
+// preferRegenerated tells whether the regenerated answer is preferred to the old one,
+// and whether the preference is explicit by ratings or likes
+func preferRegenerated(record, old *Record) (preferNew bool, explicit bool) {
+	if record.Rating > 0 && old.Rating > 0 && record.Rating != old.Rating {
+		return record.Rating > old.Rating, true
+	}
+	if record.LikeData != old.LikeData {
+		return record.LikeData > old.LikeData, true
+	}
+	// the user asked for another answer
+	return true, false
+}
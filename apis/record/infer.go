@@ -20,6 +20,7 @@ import (
 	"MOSS_backend/config"
 	. "MOSS_backend/models"
 	. "MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
 	"MOSS_backend/utils/sensitive"
 	"MOSS_backend/utils/tools"
 
@@ -29,15 +30,20 @@ import (
 )
 
 type InferResponseModel struct {
-	Status     int    `json:"status"` // 1 for output, 0 for end, -1 for error, -2 for sensitive
-	StatusCode int    `json:"status_code,omitempty"`
-	Output     string `json:"output,omitempty"`
-	Stage      string `json:"stage,omitempty"`
+	Status        int     `json:"status"` // 1 for output, 0 for end, -1 for error, -2 for sensitive, 4 for queue position, 5 for chat title
+	StatusCode    int     `json:"status_code,omitempty"`
+	Output        string  `json:"output,omitempty"`
+	Stage         string  `json:"stage,omitempty"`
+	RetryAfter    int     `json:"retry_after,omitempty"`    // seconds
+	Position      int     `json:"position,omitempty"`       // position in queue, starts from 1
+	EstimatedWait float64 `json:"estimated_wait,omitempty"` // seconds
+	MessageID     string  `json:"message_id,omitempty"`     // error message in the catalog, translated by the listener
 }
 
 type responseChannel struct {
-	ch     chan InferResponseModel
-	closed atomic.Bool
+	ch       chan InferResponseModel
+	closed   atomic.Bool
+	received atomic.Bool
 }
 
 var InferResponseChannel sync.Map
@@ -52,6 +58,7 @@ type InferWsContext struct {
 func InferOpenAI(
 	record *Record,
 	postRecord RecordModels,
+	summary string,
 	model *ModelConfig,
 	user *User,
 	ctx *InferWsContext,
@@ -65,14 +72,14 @@ func InferOpenAI(
 		}
 	}()
 
-	openaiConfig := openai.DefaultConfig("")
-	openaiConfig.BaseURL = model.Url
-	client := openai.NewClientWithConfig(openaiConfig)
-
+	systemPrompt := model.OpenAISystemPrompt
+	if summary != "" {
+		systemPrompt += "\n" + summaryMarker + summary
+	}
 	var messages = make([]openai.ChatCompletionMessage, 0, len(postRecord)+2)
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    "system",
-		Content: model.OpenAISystemPrompt,
+		Content: systemPrompt,
 	})
 	messages = append(messages, postRecord.ToOpenAIMessages()...)
 	messages = append(messages, openai.ChatCompletionMessage{
@@ -85,6 +92,85 @@ func InferOpenAI(
 		Stop:     []string{model.EndDelimiter},
 	}
 
+	// retry on another endpoint only if nothing has been streamed to the client
+	tried := make(map[string]bool)
+	attempts := maxInferAttempts(model)
+	for attempt := 0; attempt < attempts; attempt++ {
+		var endpoint *endpointAttempt
+		endpoint, err = pickEndpoint(model, tried)
+		if err != nil {
+			return err
+		}
+		tried[endpoint.url] = true
+
+		var streamed bool
+		streamed, err = inferOpenAIOnce(record, request, model, endpoint, user, ctx)
+		if isContextLengthError(err) {
+			return maxInputExceededFromInferError
+		}
+		if err == nil || streamed || !isOpenAIRetryableError(err) {
+			return err
+		}
+		Logger.Warn(
+			"openai endpoint failed, retrying",
+			zap.String("model", model.Description),
+			zap.String("url", endpoint.url),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+func isOpenAIRetryableError(err error) bool {
+	var apiError *openai.APIError
+	if errors.As(err, &apiError) {
+		return apiError.HTTPStatusCode >= 500
+	}
+	var requestError *openai.RequestError
+	if errors.As(err, &requestError) {
+		return requestError.HTTPStatusCode >= 500
+	}
+	return isRetryableError(err)
+}
+
+// isContextLengthError reports whether the openai server rejected the request as too long
+func isContextLengthError(err error) bool {
+	var apiError *openai.APIError
+	if !errors.As(err, &apiError) || apiError.HTTPStatusCode != 400 {
+		return false
+	}
+	if code, ok := apiError.Code.(string); ok && code == "context_length_exceeded" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(apiError.Message), "context length")
+}
+
+// inferOpenAIOnce requests one endpoint, streamed is true if any output has been sent to the client
+func inferOpenAIOnce(
+	record *Record,
+	request openai.ChatCompletionRequest,
+	model *ModelConfig,
+	endpoint *endpointAttempt,
+	user *User,
+	ctx *InferWsContext,
+) (
+	streamed bool,
+	err error,
+) {
+	startTime := time.Now()
+	defer func() {
+		if err != nil && isOpenAIRetryableError(err) {
+			endpoint.reportFailure(model, time.Since(startTime))
+		} else {
+			endpoint.reportSuccess(model, time.Since(startTime))
+		}
+	}()
+
+	openaiConfig := openai.DefaultConfig("")
+	openaiConfig.BaseURL = endpoint.url
+	client := openai.NewClientWithConfig(openaiConfig)
+
 	if ctx == nil {
 		// openai client may panic when status code is 400
 		response, err := client.CreateChatCompletion(
@@ -92,20 +178,21 @@ func InferOpenAI(
 			request,
 		)
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		if len(response.Choices) == 0 {
-			return unknownError
+			return false, unknownError
 		}
 
 		record.Response = response.Choices[0].Message.Content
+		record.TokenCount = response.Usage.TotalTokens
 	} else {
 		// streaming
 		if config.Config.Debug {
 			Logger.Info("openai streaming",
 				zap.String("model", model.OpenAIModelName),
-				zap.String("url", model.Url),
+				zap.String("url", endpoint.url),
 			)
 		}
 
@@ -114,30 +201,28 @@ func InferOpenAI(
 			request,
 		)
 		if err != nil {
-			return err
+			return false, err
 		}
 		defer stream.Close()
 
-		startTime := time.Now()
-
 		var resultBuilder strings.Builder
 		var nowOutput string
 		var detectedOutput string
 
 		for {
 			if ctx.connectionClosed.Load() {
-				return interruptError
+				return detectedOutput != "", interruptError
 			}
 			response, err := stream.Recv()
 			if errors.Is(err, io.EOF) {
 				break
 			}
 			if err != nil {
-				return err
+				return detectedOutput != "", err
 			}
 
 			if len(response.Choices) == 0 {
-				return unknownError
+				return detectedOutput != "", unknownError
 			}
 
 			resultBuilder.WriteString(response.Choices[0].Delta.Content)
@@ -156,7 +241,7 @@ func InferOpenAI(
 			if model.EnableSensitiveCheck {
 				err = sensitiveCheck(ctx.c, record, detectedOutput, startTime, user)
 				if err != nil {
-					return err
+					return true, err
 				}
 			}
 
@@ -170,7 +255,7 @@ func InferOpenAI(
 			if model.EnableSensitiveCheck {
 				err = sensitiveCheck(ctx.c, record, nowOutput, startTime, user)
 				if err != nil {
-					return err
+					return true, err
 				}
 			}
 
@@ -183,6 +268,11 @@ func InferOpenAI(
 
 		record.Response = nowOutput
 		record.Duration = float64(time.Since(startTime)) / 1000_000_000
+		// usage is not returned when streaming
+		record.TokenCount = EstimateTokens(nowOutput)
+		for _, message := range request.Messages {
+			record.TokenCount += EstimateTokens(message.Content)
+		}
 		_ = ctx.c.WriteJSON(InferResponseModel{
 			Status: 0,
 			Output: nowOutput,
@@ -190,7 +280,7 @@ func InferOpenAI(
 		})
 	}
 
-	return nil
+	return true, nil
 }
 
 func InferCommon(
@@ -208,24 +298,32 @@ func InferCommon(
 	defer userInferRequestOnFlight.Dec()
 
 	// load model config
-	modelID := user.ModelID
-	if modelID == 0 {
-		modelID = config.Config.DefaultModelID
-	}
-	model, err := LoadModelConfigByID(user.ModelID)
+	model, err := LoadModelConfigByID(userModelID(user))
 	if err != nil {
 		model, err = LoadModelConfigByID(config.Config.DefaultModelID)
 		if err != nil {
 			return err
 		}
-		modelID = config.Config.DefaultModelID
 	}
+	record.ModelID = model.ID
 
-	// dispatch
-	if model.APIType == APITypeOpenAI {
-		return InferOpenAI(record, postRecords, model, user, ctx)
-	} else {
-		return InferMOSS(record, prefix, user, model, param, ctx)
+	// dispatch, retry with a smaller context if the input is too long
+	for level := 0; ; level++ {
+		fittedPrefix, fittedPostRecords, summary := fitContext(model, prefix, postRecords, record.Request, level)
+		if model.APIType == APITypeOpenAI {
+			err = InferOpenAI(record, fittedPostRecords, summary, model, user, ctx)
+		} else {
+			err = InferMOSS(record, fittedPrefix, user, model, param, ctx)
+		}
+		if level >= maxTruncateRetries || !errors.Is(err, maxInputExceededFromInferError) {
+			return err
+		}
+		Logger.Warn(
+			"max input length exceeded, retry with truncated context",
+			zap.String("model", model.Description),
+			zap.Int("chat_id", record.ChatID),
+			zap.Int("level", level+1),
+		)
 	}
 }
 
@@ -293,8 +391,10 @@ func InferMOSS(
 		uuidText = uuid.NewString()
 
 		// start a new(fake) listener
+		responseCh, cleanup := registerInferListener(uuidText)
 		go func() {
-			_ = inferListener(record, uuidText, user, *ctx, "Inner Thoughts")
+			defer cleanup()
+			_ = inferListener(record, responseCh, user, *ctx, "Inner Thoughts")
 		}()
 
 		request["url"] = model.CallbackUrl + "?uuid=" + uuidText
@@ -302,11 +402,13 @@ func InferMOSS(
 
 	// construct data to send
 	data, _ := json.Marshal(request)
-	inferTriggerResults, err := inferTrigger(data, model.Url) // block here
+	inferTriggerResults, err := inferTrigger(data, model, uuidText) // block here
 	if err != nil {
 		return err
 	}
 
+	record.TokenCount += inferTriggerResults.TokenNum
+
 	/* middle process */
 	// check if first output is valid
 	firstFormattedNewGenerations := "<|Inner Thoughts|>:" + inferTriggerResults.NewGeneration
@@ -400,8 +502,10 @@ func InferMOSS(
 	if ctx != nil {
 		wg.Add(1)
 		// start a new listener
+		responseCh, cleanup := registerInferListener(uuidText)
 		go func() {
-			innerErr = inferListener(record, uuidText, user, *ctx, "MOSS")
+			defer cleanup()
+			innerErr = inferListener(record, responseCh, user, *ctx, "MOSS")
 			wg.Done()
 		}()
 
@@ -410,10 +514,11 @@ func InferMOSS(
 
 	// infer
 	data, _ = json.Marshal(request)
-	inferTriggerResults, err = inferTrigger(data, model.Url)
+	inferTriggerResults, err = inferTrigger(data, model, uuidText)
 	if err != nil {
 		return err
 	}
+	record.TokenCount += inferTriggerResults.TokenNum
 
 	if ctx != nil {
 		wg.Wait()
@@ -454,6 +559,7 @@ func InferMOSS(
 	record.Duration = inferTriggerResults.Duration
 	record.ExtraData = results.ExtraData
 	record.ProcessedExtraData = results.ProcessedExtraData
+	record.Tools = results.Types()
 	record.InnerThoughts = rawInnerThoughts
 
 	rawContentBuilder.WriteString(firstFormattedInput)
@@ -473,6 +579,77 @@ func InferMOSS(
 	return nil
 }
 
+// inferBackground runs inferPlain for background tasks of the user, e.g. titles and chat summaries.
+// It waits in the infer queue and checks the circuit breaker as requests do, without counting quotas.
+func inferBackground(user *User, model *ModelConfig, instruction, content string) (string, error) {
+	release, err := admitModel(context.Background(), user, model.ID, nil)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return inferPlain(model, instruction, content)
+}
+
+// inferPlain runs a single turn without history, tools or streaming, e.g. for summaries.
+// The caller must have been admitted, see inferBackground.
+func inferPlain(model *ModelConfig, instruction, content string) (string, error) {
+	if model.APIType == APITypeOpenAI {
+		endpoint, err := pickEndpoint(model, nil)
+		if err != nil {
+			return "", err
+		}
+		startTime := time.Now()
+		openaiConfig := openai.DefaultConfig("")
+		openaiConfig.BaseURL = endpoint.url
+		client := openai.NewClientWithConfig(openaiConfig)
+		response, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model: model.OpenAIModelName,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: "system", Content: instruction},
+				{Role: "user", Content: content},
+			},
+			Stop: []string{model.EndDelimiter},
+		})
+		if err != nil {
+			if isOpenAIRetryableError(err) {
+				endpoint.reportFailure(model, time.Since(startTime))
+			} else {
+				endpoint.reportSuccess(model, time.Since(startTime))
+			}
+			return "", err
+		}
+		endpoint.reportSuccess(model, time.Since(startTime))
+		if len(response.Choices) == 0 {
+			return "", unknownError
+		}
+		return strings.TrimSpace(response.Choices[0].Message.Content), nil
+	}
+
+	request := map[string]any{}
+	err := LoadParamToMap(request)
+	if err != nil {
+		return "", err
+	}
+	for key := range model.DefaultPluginConfig {
+		request[key] = false
+	}
+	input := mossSpecialTokenRegexp.ReplaceAllString(instruction+"\n"+content, " ")
+	request["x"] = fmt.Sprintf(
+		"<|Human|>: %s<eoh>\n<|Inner Thoughts|>: None<eot>\n<|Commands|>: None<eoc>\n<|Results|>: None<eor>\n<|MOSS|>:",
+		input,
+	)
+	data, _ := json.Marshal(request)
+	inferTriggerResults, err := inferTrigger(data, model, "")
+	if err != nil {
+		return "", err
+	}
+	output := "<|MOSS|>:" + inferTriggerResults.NewGeneration
+	if mossOutputSlice := mossRegexp.FindStringSubmatch(output); len(mossOutputSlice) == 3 {
+		return strings.TrimSpace(mossOutputSlice[1]), nil
+	}
+	return strings.TrimSpace(inferTriggerResults.NewGeneration), nil
+}
+
 func Infer(
 	record *Record,
 	prefix string,
@@ -494,6 +671,7 @@ func Infer(
 
 func InferAsync(
 	c *websocket.Conn,
+	watcher *wsWatcher,
 	prefix string,
 	record *Record,
 	postRecord RecordModels,
@@ -503,20 +681,10 @@ func InferAsync(
 	err error,
 ) {
 	var (
-		interruptChan    = make(chan any)   // frontend interrupt channel
-		connectionClosed = new(atomic.Bool) // connection closed flag
-		errChan          = make(chan error) // error transmission channel
-		successChan      = make(chan any)   // success infer flag
-	)
-	connectionClosed.Store(false)      // initialize
-	defer connectionClosed.Store(true) // if this closed, stop all goroutines
-
-	// wait for interrupt
-	go interrupt(
-		c,
-		interruptChan,
-		connectionClosed,
+		errChan     = make(chan error) // error transmission channel
+		successChan = make(chan any)   // success infer flag
 	)
+	defer watcher.connectionClosed.Store(true) // if this closed, stop all goroutines
 
 	// wait for infer
 	go func() {
@@ -528,7 +696,7 @@ func InferAsync(
 			param,
 			&InferWsContext{
 				c:                c,
-				connectionClosed: connectionClosed,
+				connectionClosed: watcher.connectionClosed,
 			},
 		)
 		if innerErr != nil {
@@ -540,7 +708,7 @@ func InferAsync(
 
 	for {
 		select {
-		case <-interruptChan:
+		case <-watcher.Done(): // frontend interrupt
 			return NoStatus("client interrupt")
 		case err = <-errChan:
 			return err
@@ -550,23 +718,40 @@ func InferAsync(
 	}
 }
 
+// registerInferListener makes a channel for the callback of uuidText before inference is triggered.
+// Call cleanup when the listener exits.
+func registerInferListener(uuidText string) (responseCh *responseChannel, cleanup func()) {
+	responseCh = &responseChannel{ch: make(chan InferResponseModel, 100)}
+	InferResponseChannel.Store(uuidText, responseCh)
+
+	// the callback may reach another replica
+	var cancel func()
+	if distributed() {
+		var err error
+		cancel, err = subscribeInferResponse(uuidText, responseCh)
+		if err != nil {
+			Logger.Error("subscribe infer response error", zap.String("uuid", uuidText), zap.Error(err))
+		}
+	}
+
+	return responseCh, func() {
+		if cancel != nil {
+			cancel()
+		}
+		InferResponseChannel.Delete(uuidText)
+	}
+}
+
 // inferListener listen from output channel
 func inferListener(
 	record *Record,
-	uuidText string,
+	responseCh *responseChannel,
 	user *User,
 	ctx InferWsContext,
 	stage string,
 ) error {
 	var err error
-
-	// make store channel into map
-	outputChan := make(chan InferResponseModel, 100)
-	responseCh := &responseChannel{ch: outputChan}
-	InferResponseChannel.Store(uuidText, responseCh)
-	defer func() {
-		InferResponseChannel.Delete(uuidText)
-	}()
+	outputChan := responseCh.ch
 
 	startTime := time.Now()
 	var inferListenerTimeLimit = 90 * time.Second
@@ -630,10 +815,13 @@ func inferListener(
 				}
 				return nil
 			case -1: // error
+				if response.MessageID != "" {
+					return InternalServerError().WithMessageID(response.MessageID)
+				}
 				return InternalServerError(response.Output)
 			}
 		case <-timer.C:
-			return InternalServerError("Internal Server Timeout")
+			return InternalServerError().WithMessageID("infer_timeout")
 		}
 	}
 }
@@ -662,7 +850,7 @@ func sensitiveCheck(
 
 		var outputMessage string
 		if banned {
-			outputMessage = OffenseMessage
+			outputMessage = i18n.T(wsLocale(c), "user_banned")
 		} else {
 			outputMessage = DefaultResponse
 		}
@@ -682,9 +870,38 @@ type InferTriggerResponse struct {
 	Output        string  `json:"output"`
 	NewGeneration string  `json:"new_generation"`
 	Duration      float64 `json:"duration"`
+	TokenNum      int     `json:"token_num"` // input and new generations
+}
+
+// inferTrigger posts data to an endpoint of the model, and retries on another endpoint
+// if the endpoint failed before any tokens were streamed back through uuidText
+func inferTrigger(data []byte, model *ModelConfig, uuidText string) (i *InferTriggerResponse, err error) {
+	tried := make(map[string]bool)
+	attempts := maxInferAttempts(model)
+	for attempt := 0; attempt < attempts; attempt++ {
+		var endpoint *endpointAttempt
+		endpoint, err = pickEndpoint(model, tried)
+		if err != nil {
+			return nil, err
+		}
+		tried[endpoint.url] = true
+
+		var retryable bool
+		i, retryable, err = inferTriggerOnce(data, model, endpoint)
+		if err == nil || !retryable || hasStreamed(uuidText) {
+			return i, err
+		}
+		Logger.Warn(
+			"inference endpoint failed, retrying",
+			zap.String("model", model.Description),
+			zap.String("url", endpoint.url),
+			zap.Int("attempt", attempt+1),
+		)
+	}
+	return nil, err
 }
 
-func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err error) {
+func inferTriggerOnce(data []byte, model *ModelConfig, endpoint *endpointAttempt) (i *InferTriggerResponse, retryable bool, err error) {
 
 	var statusCode int
 	// metrics
@@ -695,13 +912,15 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 	}()
 
 	startTime := time.Now()
-	rsp, err := inferHttpClient.Post(inferUrl, "application/json", bytes.NewBuffer(data)) // take the ownership of data
+	rsp, err := inferHttpClient.Post(endpoint.url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
+		endpoint.reportFailure(model, time.Since(startTime))
 		Logger.Error(
 			"post inference error",
+			zap.String("url", endpoint.url),
 			zap.Error(err),
 		)
-		return nil, InternalServerError("inference server error")
+		return nil, isRetryableError(err), InternalServerError().WithMessageID("infer_error")
 	}
 
 	defer func() {
@@ -710,8 +929,9 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 
 	response, err := io.ReadAll(rsp.Body)
 	if err != nil {
+		endpoint.reportFailure(model, time.Since(startTime))
 		Logger.Error("fail to read response body", zap.Error(err))
-		return nil, InternalServerError()
+		return nil, isRetryableError(err), InternalServerError()
 	}
 
 	latency := int(time.Since(startTime))
@@ -719,23 +939,28 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 
 	statusCode = rsp.StatusCode
 	if rsp.StatusCode != 200 {
-		inferLimiter.AddStats(false)
 		Logger.Error(
 			"inference error",
+			zap.String("url", endpoint.url),
 			zap.Int("latency", latency),
 			zap.Int("status code", rsp.StatusCode),
 			zap.ByteString("body", response),
 		)
 		if rsp.StatusCode == 400 {
-			return nil, maxInputExceededFromInferError
+			endpoint.reportSuccess(model, time.Since(startTime))
+			return nil, false, maxInputExceededFromInferError
 		} else if rsp.StatusCode == 560 {
-			return nil, unknownError
+			endpoint.reportSuccess(model, time.Since(startTime))
+			return nil, false, unknownError
 		} else if rsp.StatusCode >= 500 {
-			return nil, InternalServerError()
+			endpoint.reportFailure(model, time.Since(startTime))
+			return nil, true, InternalServerError()
 		} else {
-			return nil, unknownError
+			endpoint.reportSuccess(model, time.Since(startTime))
+			return nil, false, unknownError
 		}
 	} else {
+		endpoint.reportSuccess(model, time.Since(startTime))
 		var responseStruct struct {
 			Pred                   string `json:"pred"`
 			NewGenerations         string `json:"new_generations"`
@@ -744,14 +969,13 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 		}
 		err = json.Unmarshal(response, &responseStruct)
 		if err != nil {
-			inferLimiter.AddStats(false)
 			responseString := string(response)
 			if responseString == "400" {
 				statusCode = 400
-				return nil, maxInputExceededFromInferError
+				return nil, false, maxInputExceededFromInferError
 			} else if responseString == "560" {
 				statusCode = 560
-				return nil, unknownError
+				return nil, false, unknownError
 			} else {
 				statusCode = 500
 				Logger.Error(
@@ -759,12 +983,12 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 					zap.ByteString("response", response),
 					zap.Error(err),
 				)
-				return nil, InternalServerError()
+				return nil, false, InternalServerError()
 			}
 		} else {
-			inferLimiter.AddStats(true)
 			Logger.Info(
 				"inference success",
+				zap.String("url", endpoint.url),
 				zap.ByteString("request", data),
 				zap.Int("latency", latency),
 				zap.String("pred", responseStruct.Pred),
@@ -777,11 +1001,24 @@ func inferTrigger(data []byte, inferUrl string) (i *InferTriggerResponse, err er
 				Output:        responseStruct.Pred,
 				NewGeneration: responseStruct.NewGenerations,
 				Duration:      duration,
-			}, nil
+				TokenNum:      responseStruct.InputTokenNum + responseStruct.NewGenerationsTokenNum,
+			}, false, nil
 		}
 	}
 }
 
+// hasStreamed reports whether any response has been received from the callback of uuidText
+func hasStreamed(uuidText string) bool {
+	if uuidText == "" {
+		return false
+	}
+	value, ok := InferResponseChannel.Load(uuidText)
+	if !ok {
+		return false
+	}
+	return value.(*responseChannel).received.Load()
+}
+
 func ReceiveInferResponse(c *websocket.Conn) {
 	var (
 		message []byte
@@ -796,14 +1033,18 @@ func ReceiveInferResponse(c *websocket.Conn) {
 
 	uuidText := c.Query("uuid")
 	if uuidText == "" {
-		_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 400, Output: "Bad Request"})
+		_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 400, Output: i18n.T(wsLocale(c), "bad_request")})
 		return
 	}
 
 	value, ok := InferResponseChannel.Load(uuidText)
+	if !ok && distributed() {
+		relayInferResponse(c, uuidText)
+		return
+	}
 	if !ok {
 		Logger.Error("receive from infer invalid uuid", zap.String("uuid", uuidText))
-		_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 400, Output: "Bad Request"})
+		_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 400, Output: i18n.T(wsLocale(c), "bad_request")})
 		return
 	}
 	ch := value.(*responseChannel)
@@ -848,6 +1089,7 @@ func ReceiveInferResponse(c *websocket.Conn) {
 		}
 
 		// may panic
+		ch.received.Store(true)
 		ch.ch <- inferResponse
 
 		if inferResponse.Status == 0 {
@@ -0,0 +1,118 @@
+package record
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/utils"
+)
+
+// distributed reports whether replicas share locks and infer responses through redis
+func distributed() bool {
+	return config.Config.RedisUrl != ""
+}
+
+var userLockMap sync.Map
+
+type UserLockValue struct {
+	LockTime time.Time
+}
+
+func UserLockCheck() {
+	if distributed() {
+		return // redis locks expire by themselves
+	}
+	ticker := time.NewTicker(time.Hour)
+	for range ticker.C {
+		userLockMap.Range(func(key, value interface{}) bool {
+			userLockValue := value.(UserLockValue)
+			// delete lock before 1 minute
+			if userLockValue.LockTime.Before(time.Now().Add(-time.Minute)) {
+				userLockMap.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+const (
+	userLockExpire  = 3 * time.Minute
+	userLockRefresh = time.Minute
+)
+
+// unlockScript deletes the lock only if it is still held by the same token
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshLockScript extends the lock only if it is still held by the same token
+var refreshLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+func userLockKey(userID int) string {
+	return "moss_user_lock:" + strconv.Itoa(userID)
+}
+
+// lockUser allows only one inference request of a user at the same time across all replicas,
+// ok is false if the user is requesting. Call unlock after the request finished.
+func lockUser(userID int) (unlock func(), ok bool) {
+	if !distributed() {
+		if _, loaded := userLockMap.LoadOrStore(userID, UserLockValue{LockTime: time.Now()}); loaded {
+			return nil, false
+		}
+		return func() { userLockMap.Delete(userID) }, true
+	}
+
+	ctx := context.Background()
+	key := userLockKey(userID)
+	token := uuid.NewString()
+	ok, err := config.RedisClient.SetNX(ctx, key, token, userLockExpire).Result()
+	if err != nil {
+		// do not block users when redis is down
+		Logger.Error("lock user error", zap.Int("user_id", userID), zap.Error(err))
+		return func() {}, true
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// keep the lock alive during long inference
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(userLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := refreshLockScript.Run(ctx, config.RedisClient, []string{key}, token, userLockExpire.Milliseconds()).Err()
+				if err != nil {
+					Logger.Error("refresh user lock error", zap.Int("user_id", userID), zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		err := unlockScript.Run(ctx, config.RedisClient, []string{key}, token).Err()
+		if err != nil {
+			Logger.Error("unlock user error", zap.Int("user_id", userID), zap.Error(err))
+		}
+	}, true
+}
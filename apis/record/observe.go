@@ -28,3 +28,53 @@ var inferOnFlightCounter = promauto.NewGauge(prometheus.GaugeOpts{
 var userInferRequestOnFlight = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: prometheus.BuildFQName(config.AppName, "user_infer_request", "on_flight"),
 })
+
+var endpointRequestCounter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(config.AppName, "infer_endpoint", "requests"),
+	},
+	[]string{"model", "endpoint", "result"},
+)
+
+var endpointLatencyHistogram = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    prometheus.BuildFQName(config.AppName, "infer_endpoint", "latency_seconds"),
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 40, 60, 120},
+	},
+	[]string{"model", "endpoint"},
+)
+
+// circuitBreakerStateGauge is 0 for closed, 1 for open, 2 for half-open. endpoint is empty for the breaker of the model.
+var circuitBreakerStateGauge = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName(config.AppName, "circuit_breaker", "state"),
+	},
+	[]string{"model", "endpoint"},
+)
+
+var circuitBreakerTripCounter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(config.AppName, "circuit_breaker", "trips"),
+	},
+	[]string{"model", "endpoint"},
+)
+
+var endpointHealthCheckCounter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(config.AppName, "infer_endpoint", "health_check"),
+	},
+	[]string{"model", "endpoint", "status_code"},
+)
+
+var inferQueueWaitingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: prometheus.BuildFQName(config.AppName, "infer_queue", "waiting"),
+})
+
+var inferQueueRejectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: prometheus.BuildFQName(config.AppName, "infer_queue", "rejected"),
+})
+
+var inferQueueWaitHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    prometheus.BuildFQName(config.AppName, "infer_queue", "wait_seconds"),
+	Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 40, 60, 120},
+})
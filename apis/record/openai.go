@@ -63,7 +63,7 @@ func OpenAICreateChatCompletion(c *fiber.Ctx) (err error) {
 	}
 
 	if requestMessage == "" {
-		return BadRequest("request is empty")
+		return BadRequest().WithMessageID("request_empty")
 	}
 	//if len([]rune(requestMessage)) > 2048 {
 	//	return maxInputExceededError
@@ -86,6 +86,11 @@ func OpenAICreateChatCompletion(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	// circuit breaker of the model
+	if !modelAvailable(modelConfig.ID) {
+		return inferUnavailableError
+	}
+
 	record := Record{Request: requestMessage}
 	err = Infer(&record, prefix, recordModels, &User{
 		PluginConfig:          nil,
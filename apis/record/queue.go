@@ -0,0 +1,326 @@
+package record
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// inferQueueStruct is a bounded queue in front of inference.
+// Waiting requests are dispatched by priority first, then round-robin between users,
+// so a user (or anonymous callers, who share user id 0) sending many requests cannot starve others.
+// The user lock covers websocket requests only, a user may still wait with several tickets
+// from http requests and background tasks such as titles and chat summaries.
+type inferQueueStruct struct {
+	sync.Mutex
+	running     int
+	waiting     int
+	lanes       [2]queueLane  // 0 for priority tiers, 1 for others
+	avgDuration time.Duration // moving average of inference duration
+}
+
+type queueLane struct {
+	users   []int // round-robin order of users having waiting tickets
+	tickets map[int][]*queueTicket
+}
+
+type queueTicket struct {
+	userID int
+	lane   int
+	ready  chan struct{}
+}
+
+// QueueStatus is reported to the waiting request when its position changes
+type QueueStatus struct {
+	Position      int
+	EstimatedWait time.Duration
+}
+
+var inferQueue = &inferQueueStruct{avgDuration: 20 * time.Second}
+
+var queueStatusInterval = 2 * time.Second
+
+func queuePriorityLane(user *User) int {
+	if user != nil && slices.Contains(config.Config.InferQueuePriorityTiers, user.GetTier()) {
+		return 0
+	}
+	return 1
+}
+
+// Acquire waits for an inference slot, user may be nil for anonymous requests.
+// onUpdate, if not nil, is called whenever the position in queue changes.
+// The request leaves the queue when ctx is done, e.g. the client disconnects.
+// Call release after inference.
+func (q *inferQueueStruct) Acquire(ctx context.Context, user *User, onUpdate func(status QueueStatus)) (release func(), err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if config.Config.InferConcurrency <= 0 {
+		return func() {}, nil // no limit
+	}
+
+	var userID int
+	if user != nil {
+		userID = user.ID
+	}
+
+	q.Lock()
+	if q.running < config.Config.InferConcurrency && q.waiting == 0 {
+		q.running++
+		q.Unlock()
+		return q.releaseFunc(), nil
+	}
+	if q.waiting >= config.Config.InferQueueCapacity {
+		retryAfter := q.estimateWait(q.waiting + 1)
+		q.Unlock()
+		inferQueueRejectedCounter.Inc()
+		return nil, ServiceUnavailable().WithMessageID("queue_full").WithRetryAfter(retryAfterSeconds(retryAfter))
+	}
+	ticket := q.enqueue(userID, queuePriorityLane(user))
+	q.Unlock()
+
+	inferQueueWaitingGauge.Inc()
+	defer inferQueueWaitingGauge.Dec()
+	startTime := time.Now()
+
+	var lastPosition int
+	report := func() {
+		if onUpdate == nil {
+			return
+		}
+		q.Lock()
+		status := q.status(ticket)
+		q.Unlock()
+		if status.Position != lastPosition && status.Position > 0 {
+			lastPosition = status.Position
+			onUpdate(status)
+		}
+	}
+	report()
+
+	ticker := time.NewTicker(queueStatusInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Duration(config.Config.InferQueueTimeout) * time.Second)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ticket.ready:
+			inferQueueWaitHistogram.Observe(time.Since(startTime).Seconds())
+			return q.releaseFunc(), nil
+		case <-ticker.C:
+			report()
+		case <-ctx.Done():
+			q.Lock()
+			removed := q.remove(ticket)
+			q.Unlock()
+			if !removed {
+				// dispatched just now, give the slot to the next
+				<-ticket.ready
+				q.releaseFunc()()
+			}
+			return nil, ctx.Err()
+		case <-timeout.C:
+			q.Lock()
+			removed := q.remove(ticket)
+			retryAfter := q.estimateWait(q.waiting + 1)
+			q.Unlock()
+			if !removed {
+				// dispatched just now
+				<-ticket.ready
+				return q.releaseFunc(), nil
+			}
+			inferQueueRejectedCounter.Inc()
+			return nil, ServiceUnavailable().WithMessageID("queue_timeout").WithRetryAfter(retryAfterSeconds(retryAfter))
+		}
+	}
+}
+
+// TryAcquire takes an inference slot only if one is free and no one is waiting, ok is false otherwise.
+// It is for requests already holding a slot, which must not wait behind others for a second one.
+func (q *inferQueueStruct) TryAcquire() (release func(), ok bool) {
+	if config.Config.InferConcurrency <= 0 {
+		return func() {}, true // no limit
+	}
+	q.Lock()
+	defer q.Unlock()
+	if q.running < config.Config.InferConcurrency && q.waiting == 0 {
+		q.running++
+		return q.releaseFunc(), true
+	}
+	return nil, false
+}
+
+// admitModel waits in the infer queue and checks the circuit breaker of the model, 0 for the default model.
+// The breaker is taken when the request is sent to an endpoint, see pickEndpoint.
+// user is nil for anonymous requests. Call release after inference.
+func admitModel(ctx context.Context, user *User, modelID int, onUpdate func(status QueueStatus)) (release func(), err error) {
+	// fail fast rather than waiting in the queue
+	if !modelAvailable(modelID) {
+		return nil, inferUnavailableError
+	}
+
+	release, err = inferQueue.Acquire(ctx, user, onUpdate)
+	if err != nil {
+		return nil, err
+	}
+	// the breaker may have opened while waiting
+	if !modelAvailable(modelID) {
+		release()
+		return nil, inferUnavailableError
+	}
+	return release, nil
+}
+
+// admitInfer admits a request as admitModel, and counts it in the quota of the user if not anonymous
+func admitInfer(ctx context.Context, user *User, modelID int, onUpdate func(status QueueStatus)) (release func(), err error) {
+	release, err = admitModel(ctx, user, modelID, onUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	// per-user rate limit and quota
+	if user != nil {
+		err = checkRateLimit(user)
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func (q *inferQueueStruct) releaseFunc() func() {
+	startTime := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.Lock()
+			defer q.Unlock()
+			// exponential moving average
+			q.avgDuration = (q.avgDuration*9 + time.Since(startTime)) / 10
+			q.running--
+			q.dispatch()
+		})
+	}
+}
+
+// enqueue must be called with lock held
+func (q *inferQueueStruct) enqueue(userID int, laneIndex int) *queueTicket {
+	ticket := &queueTicket{userID: userID, lane: laneIndex, ready: make(chan struct{})}
+	lane := &q.lanes[laneIndex]
+	if lane.tickets == nil {
+		lane.tickets = make(map[int][]*queueTicket)
+	}
+	if len(lane.tickets[userID]) == 0 {
+		lane.users = append(lane.users, userID)
+	}
+	lane.tickets[userID] = append(lane.tickets[userID], ticket)
+	q.waiting++
+	return ticket
+}
+
+// remove a waiting ticket, must be called with lock held
+func (q *inferQueueStruct) remove(ticket *queueTicket) bool {
+	lane := &q.lanes[ticket.lane]
+	tickets := lane.tickets[ticket.userID]
+	index := slices.Index(tickets, ticket)
+	if index < 0 {
+		return false
+	}
+	tickets = slices.Delete(tickets, index, index+1)
+	if len(tickets) == 0 {
+		delete(lane.tickets, ticket.userID)
+		lane.users = slices.DeleteFunc(lane.users, func(userID int) bool { return userID == ticket.userID })
+	} else {
+		lane.tickets[ticket.userID] = tickets
+	}
+	q.waiting--
+	return true
+}
+
+// dispatch waiting tickets to free slots, must be called with lock held
+func (q *inferQueueStruct) dispatch() {
+	for q.running < config.Config.InferConcurrency && q.waiting > 0 {
+		for i := range q.lanes {
+			lane := &q.lanes[i]
+			if len(lane.users) == 0 {
+				continue
+			}
+			userID := lane.users[0]
+			tickets := lane.tickets[userID]
+			ticket := tickets[0]
+			lane.users = lane.users[1:]
+			if len(tickets) > 1 {
+				lane.tickets[userID] = tickets[1:]
+				lane.users = append(lane.users, userID) // round-robin
+			} else {
+				delete(lane.tickets, userID)
+			}
+			q.waiting--
+			q.running++
+			close(ticket.ready)
+			break
+		}
+	}
+}
+
+// status computes the position of a waiting ticket in dispatch order, must be called with lock held
+func (q *inferQueueStruct) status(ticket *queueTicket) QueueStatus {
+	lane := &q.lanes[ticket.lane]
+	index := slices.Index(lane.tickets[ticket.userID], ticket)
+	userIndex := slices.Index(lane.users, ticket.userID)
+	if index < 0 || userIndex < 0 {
+		return QueueStatus{}
+	}
+
+	position := index + 1
+	for i := 0; i < ticket.lane; i++ {
+		for _, tickets := range q.lanes[i].tickets {
+			position += len(tickets)
+		}
+	}
+	for i, userID := range lane.users {
+		if userID == ticket.userID {
+			continue
+		}
+		rounds := index // tickets of other users dispatched in previous rounds
+		if i < userIndex {
+			rounds++ // and in the current round
+		}
+		position += min(len(lane.tickets[userID]), rounds)
+	}
+	return QueueStatus{Position: position, EstimatedWait: q.estimateWait(position)}
+}
+
+// estimateWait must be called with lock held
+func (q *inferQueueStruct) estimateWait(position int) time.Duration {
+	concurrency := max(1, config.Config.InferConcurrency)
+	return time.Duration(math.Ceil(float64(position)/float64(concurrency))) * q.avgDuration
+}
+
+// queueReporter sends the queue position to the websocket client, with status 4
+func queueReporter(c *websocket.Conn) func(status QueueStatus) {
+	return func(status QueueStatus) {
+		err := c.WriteJSON(InferResponseModel{
+			Status:        4,
+			Position:      status.Position,
+			EstimatedWait: status.EstimatedWait.Seconds(),
+		})
+		if err != nil {
+			Logger.Warn("write queue status error", zap.Error(err))
+		}
+	}
+}
+
+func retryAfterSeconds(duration time.Duration) int {
+	return max(1, int(math.Ceil(duration.Seconds())))
+}
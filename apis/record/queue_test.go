@@ -0,0 +1,282 @@
+package record
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+func setQueueConfig(t *testing.T, concurrency, capacity int) {
+	saved := config.Config
+	savedInterval := queueStatusInterval
+	t.Cleanup(func() {
+		config.Config = saved
+		queueStatusInterval = savedInterval
+	})
+	config.Config.InferConcurrency = concurrency
+	config.Config.InferQueueCapacity = capacity
+	config.Config.InferQueueTimeout = 10
+	config.Config.InferQueuePriorityTiers = []string{UserTierAdmin}
+	queueStatusInterval = 10 * time.Millisecond
+}
+
+func queueCounts(q *inferQueueStruct) (running, waiting int) {
+	q.Lock()
+	defer q.Unlock()
+	return q.running, q.waiting
+}
+
+// waitQueued waits until n tickets are waiting in the queue
+func waitQueued(t *testing.T, q *inferQueueStruct, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, waiting := queueCounts(q); waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d tickets never waiting in queue", n)
+}
+
+func TestQueueDispatchOrder(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	q := &inferQueueStruct{avgDuration: time.Second}
+	release, err := q.Acquire(context.Background(), &User{ID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user 1 sends three requests before user 2 sends two, and an admin sends one last
+	order := make(chan string, 6)
+	var wg sync.WaitGroup
+	for i, request := range []struct {
+		name string
+		user *User
+	}{
+		{"a1", &User{ID: 1}},
+		{"a2", &User{ID: 1}},
+		{"a3", &User{ID: 1}},
+		{"b1", &User{ID: 2}},
+		{"b2", &User{ID: 2}},
+		{"admin", &User{ID: 3, IsAdmin: true}},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := q.Acquire(context.Background(), request.user, nil)
+			if err != nil {
+				order <- err.Error()
+				return
+			}
+			order <- request.name
+			release()
+		}()
+		waitQueued(t, q, i+1)
+	}
+	release()
+
+	for _, want := range []string{"admin", "a1", "b1", "a2", "b2", "a3"} {
+		if got := <-order; got != want {
+			t.Fatalf("dispatched %s, want %s", got, want)
+		}
+	}
+	wg.Wait()
+	if running, waiting := queueCounts(q); running != 0 || waiting != 0 {
+		t.Fatalf("%d running and %d waiting after all released", running, waiting)
+	}
+}
+
+func TestQueuePositionUpdates(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	q := &inferQueueStruct{avgDuration: time.Second}
+	release, err := q.Acquire(context.Background(), &User{ID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := make(chan func(), 1)
+	go func() {
+		release, _ := q.Acquire(ctx, &User{ID: 2}, nil)
+		first <- release
+	}()
+	waitQueued(t, q, 1)
+
+	statuses := make(chan QueueStatus, 10)
+	acquired := make(chan func(), 1)
+	go func() {
+		release, _ := q.Acquire(ctx, &User{ID: 3}, func(status QueueStatus) { statuses <- status })
+		acquired <- release
+	}()
+
+	status := <-statuses
+	if status.Position != 2 || status.EstimatedWait != 2*time.Second {
+		t.Fatalf("status %+v, want position 2 and 2s estimated wait", status)
+	}
+
+	// the request ahead is dispatched
+	release()
+	releaseFirst := <-first
+	if status = <-statuses; status.Position != 1 {
+		t.Fatalf("status %+v, want position 1", status)
+	}
+	releaseFirst()
+	select {
+	case release = <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("request never dispatched")
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	q := &inferQueueStruct{avgDuration: time.Second}
+	release, err := q.Acquire(context.Background(), &User{ID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	disconnected := make(chan error, 1)
+	go func() {
+		_, err := q.Acquire(ctx, &User{ID: 2}, nil)
+		disconnected <- err
+	}()
+	waitQueued(t, q, 1)
+	acquired := make(chan struct{})
+	go func() {
+		release, err := q.Acquire(context.Background(), &User{ID: 3}, nil)
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	waitQueued(t, q, 2)
+
+	cancel()
+	if err = <-disconnected; !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want context canceled", err)
+	}
+	waitQueued(t, q, 1)
+
+	// the slot goes to the request still waiting
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("request behind a disconnected one never dispatched")
+	}
+	if running, waiting := queueCounts(q); running != 0 || waiting != 0 {
+		t.Fatalf("%d running and %d waiting after all released", running, waiting)
+	}
+}
+
+func TestQueueOverload(t *testing.T) {
+	setQueueConfig(t, 1, 1)
+	q := &inferQueueStruct{avgDuration: 3 * time.Second}
+	release, err := q.Acquire(context.Background(), &User{ID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	defer func() {
+		cancel()
+		<-left
+	}()
+	go func() {
+		_, _ = q.Acquire(ctx, &User{ID: 2}, nil)
+		close(left)
+	}()
+	waitQueued(t, q, 1)
+
+	_, err = q.Acquire(context.Background(), &User{ID: 3}, nil)
+	var httpError *HttpError
+	if !errors.As(err, &httpError) || httpError.Code != 503 || httpError.MessageID != "queue_full" {
+		t.Fatalf("error %v, want 503 queue full", err)
+	}
+	if httpError.RetryAfter != 6 {
+		t.Fatalf("retry after %d seconds, want 6", httpError.RetryAfter)
+	}
+}
+
+func TestAdmitModelBreakerOpen(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	setBreakerConfig(t)
+	const modelID = 1 << 20
+	breaker := newCircuitBreaker("test", "", 0)
+	breaker.Lock()
+	breaker.trip()
+	breaker.Unlock()
+	modelBreakers.Store(modelID, breaker)
+	defer modelBreakers.Delete(modelID)
+
+	_, err := admitModel(context.Background(), &User{ID: 1}, modelID, nil)
+	if !errors.Is(err, inferUnavailableError) {
+		t.Fatalf("error %v, want infer unavailable", err)
+	}
+	if running, _ := queueCounts(inferQueue); running != 0 {
+		t.Fatal("request for an open breaker took a slot")
+	}
+}
+
+func TestAdmitInferQuota(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	setRateLimitRule(t, RateLimitRule{DailyRequests: 1})
+	user := &User{ID: 1}
+
+	release, err := admitInfer(context.Background(), user, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	_, err = admitInfer(context.Background(), user, 0, nil)
+	if rateLimitMessageID(err) != "quota_exceeded" {
+		t.Fatalf("error %v, want quota_exceeded", err)
+	}
+	// the slot is given back when the quota rejects
+	if running, _ := queueCounts(inferQueue); running != 0 {
+		t.Fatalf("%d running after the quota rejected", running)
+	}
+}
+
+func TestQueueTryAcquire(t *testing.T) {
+	setQueueConfig(t, 1, 10)
+	q := &inferQueueStruct{avgDuration: time.Second}
+	release, ok := q.TryAcquire()
+	if !ok {
+		t.Fatal("free slot not taken")
+	}
+	if _, ok = q.TryAcquire(); ok {
+		t.Fatal("slot taken beyond concurrency")
+	}
+	release()
+
+	// waiting requests go first
+	release, _ = q.Acquire(context.Background(), &User{ID: 1}, nil)
+	acquired := make(chan func(), 1)
+	go func() {
+		release, _ := q.Acquire(context.Background(), &User{ID: 2}, nil)
+		acquired <- release
+	}()
+	waitQueued(t, q, 1)
+	release()
+	if _, ok = q.TryAcquire(); ok {
+		t.Fatal("slot taken ahead of a waiting request")
+	}
+	(<-acquired)()
+	if running, waiting := queueCounts(q); running != 0 || waiting != 0 {
+		t.Fatalf("%d running and %d waiting after all released", running, waiting)
+	}
+}
@@ -0,0 +1,275 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// rateLimitScript checks the quota counters KEYS[1..n], takes one token from the bucket KEYS[n+1],
+// and counts the request in the quota counters if allowed, all at once so that concurrent requests can not overshoot.
+// ARGV: rate (tokens per second, 0 for no bucket), burst, now (unix ms),
+// then limit, expire at (unix seconds) and increment of each quota counter
+// returns: {0, 0} if allowed, {1, index of the exceeded counter} or {2, retry after (ms)}
+var rateLimitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = #KEYS - 1
+for i = 1, n do
+	local limit = tonumber(ARGV[3 * i + 1])
+	if limit > 0 and (tonumber(redis.call('GET', KEYS[i])) or 0) >= limit then
+		return {1, i}
+	end
+end
+if rate > 0 then
+	local bucket = KEYS[n + 1]
+	local data = redis.call('HMGET', bucket, 'tokens', 'ts')
+	local tokens = tonumber(data[1])
+	local ts = tonumber(data[2])
+	if tokens == nil or ts == nil then
+		tokens = burst
+		ts = now
+	end
+	tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+	local retry = 0
+	if tokens >= 1 then
+		tokens = tokens - 1
+	else
+		retry = math.ceil((1 - tokens) / rate * 1000)
+	end
+	redis.call('HSET', bucket, 'tokens', tostring(tokens), 'ts', tostring(now))
+	redis.call('PEXPIRE', bucket, math.ceil(burst / rate * 1000) + 1000)
+	if retry > 0 then
+		return {2, retry}
+	end
+end
+for i = 1, n do
+	local increment = tonumber(ARGV[3 * i + 3])
+	if increment > 0 then
+		redis.call('INCRBY', KEYS[i], increment)
+		redis.call('EXPIREAT', KEYS[i], ARGV[3 * i + 2])
+	end
+end
+return {0, 0}
+`)
+
+type quotaPeriod struct {
+	name   string
+	format string
+	end    func(now time.Time) time.Time
+}
+
+var (
+	quotaDay = quotaPeriod{
+		name:   "day",
+		format: "20060102",
+		end: func(now time.Time) time.Time {
+			year, month, day := now.Date()
+			return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+		},
+	}
+	quotaMonth = quotaPeriod{
+		name:   "month",
+		format: "200601",
+		end: func(now time.Time) time.Time {
+			year, month, _ := now.Date()
+			return time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location())
+		},
+	}
+)
+
+const (
+	quotaKindRequests = "requests"
+	quotaKindTokens   = "tokens"
+)
+
+type quotaCounter struct {
+	kind   string
+	period quotaPeriod
+	limit  int
+}
+
+func quotaCounters(rule *RateLimitRule) []quotaCounter {
+	return []quotaCounter{
+		{kind: quotaKindRequests, period: quotaDay, limit: rule.DailyRequests},
+		{kind: quotaKindRequests, period: quotaMonth, limit: rule.MonthlyRequests},
+		{kind: quotaKindTokens, period: quotaDay, limit: rule.DailyTokens},
+		{kind: quotaKindTokens, period: quotaMonth, limit: rule.MonthlyTokens},
+	}
+}
+
+func (q quotaCounter) key(userID int, rule *RateLimitRule, now time.Time) string {
+	return fmt.Sprintf("moss_quota:%d:%d:%s:%s:%s", userID, rule.ModelID, q.kind, q.period.name, now.Format(q.period.format))
+}
+
+func rateLimitBucketKey(userID int, rule *RateLimitRule) string {
+	return fmt.Sprintf("moss_rate_limit:%d:%d", userID, rule.ModelID)
+}
+
+func userModelID(user *User) int {
+	if user.ModelID == 0 {
+		return config.Config.DefaultModelID
+	}
+	return user.ModelID
+}
+
+// loadRateLimitRule returns the rule applied to the user's current model, nil if not limited
+func loadRateLimitRule(user *User, modelID int) (*RateLimitRule, error) {
+	return LoadRateLimitRule(modelID, user.GetTier())
+}
+
+// loadQuotaItems reads the usage of all quota counters of the rule
+func loadQuotaItems(ctx context.Context, userID int, rule *RateLimitRule, now time.Time) ([]*QuotaItem, error) {
+	counters := quotaCounters(rule)
+	keys := make([]string, len(counters))
+	for i, counter := range counters {
+		keys[i] = counter.key(userID, rule, now)
+	}
+	values, err := config.RedisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*QuotaItem, len(counters))
+	for i, counter := range counters {
+		var used int
+		if value, ok := values[i].(string); ok {
+			used, _ = strconv.Atoi(value)
+		}
+		items[i] = &QuotaItem{
+			Limit:     counter.limit,
+			Used:      used,
+			Remaining: max(0, counter.limit-used),
+			ResetAt:   counter.period.end(now),
+		}
+	}
+	return items, nil
+}
+
+// checkRateLimit applies the quotas and the token bucket of the user's current model,
+// the request is counted if allowed. Redis errors are logged and never block users.
+func checkRateLimit(user *User) error {
+	rule, err := loadRateLimitRule(user, userModelID(user))
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		return nil
+	}
+
+	now := time.Now()
+	counters := quotaCounters(rule)
+	keys := make([]string, 0, len(counters)+1)
+	args := []any{rule.Rate, max(1, rule.Burst), now.UnixMilli()}
+	for _, counter := range counters {
+		var increment int
+		if counter.kind == quotaKindRequests {
+			increment = 1
+		}
+		keys = append(keys, counter.key(user.ID, rule, now))
+		args = append(args, counter.limit, counter.period.end(now).Add(time.Hour).Unix(), increment)
+	}
+	keys = append(keys, rateLimitBucketKey(user.ID, rule))
+
+	result, err := rateLimitScript.Run(context.Background(), config.RedisClient, keys, args...).Int64Slice()
+	if err != nil {
+		Logger.Error("rate limit error", zap.Int("user_id", user.ID), zap.Error(err))
+		return nil
+	}
+	switch result[0] {
+	case 1:
+		retryAfter := int(math.Ceil(counters[result[1]-1].period.end(now).Sub(now).Seconds()))
+		return TooManyRequests().WithMessageID("quota_exceeded").WithMessageType(Quota).WithRetryAfter(retryAfter)
+	case 2:
+		retryAfter := int(math.Ceil(float64(result[1]) / 1000))
+		return TooManyRequests().WithMessageID("rate_limited").WithMessageType(RateLimit).WithRetryAfter(max(1, retryAfter))
+	}
+	return nil
+}
+
+// consumeTokens counts the tokens used by an inference of the user
+func consumeTokens(user *User, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	rule, err := loadRateLimitRule(user, userModelID(user))
+	if err != nil || rule == nil {
+		return
+	}
+	incrQuotaCounters(context.Background(), user.ID, rule, quotaKindTokens, tokens, time.Now())
+}
+
+func incrQuotaCounters(ctx context.Context, userID int, rule *RateLimitRule, kind string, value int, now time.Time) {
+	pipe := config.RedisClient.TxPipeline()
+	for _, counter := range quotaCounters(rule) {
+		if counter.kind != kind {
+			continue
+		}
+		key := counter.key(userID, rule, now)
+		pipe.IncrBy(ctx, key, int64(value))
+		pipe.ExpireAt(ctx, key, counter.period.end(now).Add(time.Hour))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		Logger.Error("count quota error", zap.Int("user_id", userID), zap.String("kind", kind), zap.Error(err))
+	}
+}
+
+// GetQuota
+// @Summary get rate limits and remaining quotas of current user
+// @Tags record
+// @Router /users/me/quota [get]
+// @Success 200 {array} QuotaResponse
+func GetQuota(c *fiber.Ctx) error {
+	user, err := LoadUser(c)
+	if err != nil {
+		return err
+	}
+
+	modelConfigs, err := LoadModelConfigs()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var response = make([]QuotaResponse, 0, len(modelConfigs))
+	for _, modelConfig := range modelConfigs {
+		quota := QuotaResponse{
+			ModelID:   modelConfig.ID,
+			ModelName: modelConfig.Description,
+			Tier:      user.GetTier(),
+		}
+		rule, err := loadRateLimitRule(user, modelConfig.ID)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			quota.Rate = rule.Rate
+			quota.Burst = rule.Burst
+			items, err := loadQuotaItems(c.Context(), user.ID, rule, now)
+			if err != nil {
+				return err
+			}
+			for i, item := range items {
+				if item.Limit == 0 {
+					items[i] = nil
+				}
+			}
+			quota.DailyRequests, quota.MonthlyRequests = items[0], items[1]
+			quota.DailyTokens, quota.MonthlyTokens = items[2], items[3]
+		}
+		response = append(response, quota)
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,181 @@
+package record
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+const testModelID = 1
+
+func TestMain(m *testing.M) {
+	config.Config.Mode = "test"
+	config.Config.DefaultModelID = testModelID
+
+	server, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	config.RedisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	InitDB()
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// setRateLimitRule replaces the rate limit rules with the rule of the test model for all tiers
+func setRateLimitRule(t *testing.T, rule RateLimitRule) {
+	rule.ModelID = testModelID
+	DB.Where("1 = 1").Delete(&RateLimitRule{})
+	if err := DB.Create(&rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RedisClient.FlushAll(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rateLimitMessageID(err error) string {
+	var httpError *HttpError
+	if errors.As(err, &httpError) {
+		return httpError.MessageID
+	}
+	return ""
+}
+
+func quotaUsed(t *testing.T, user *User, rule *RateLimitRule) []int {
+	items, err := loadQuotaItems(context.Background(), user.ID, rule, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := make([]int, len(items))
+	for i, item := range items {
+		used[i] = item.Used
+	}
+	return used
+}
+
+func TestCheckRateLimitQuota(t *testing.T) {
+	setRateLimitRule(t, RateLimitRule{DailyRequests: 3, MonthlyRequests: 10})
+	user := &User{ID: 1}
+	rule, _ := LoadRateLimitRule(testModelID, user.GetTier())
+
+	for i := 0; i < 3; i++ {
+		if err := checkRateLimit(user); err != nil {
+			t.Fatalf("request %d rejected: %v", i+1, err)
+		}
+	}
+	err := checkRateLimit(user)
+	if rateLimitMessageID(err) != "quota_exceeded" {
+		t.Fatalf("error %v, want quota_exceeded", err)
+	}
+	var httpError *HttpError
+	if errors.As(err, &httpError) && (httpError.RetryAfter <= 0 || httpError.RetryAfter > 24*3600) {
+		t.Fatalf("retry after %d seconds, want until the end of day", httpError.RetryAfter)
+	}
+
+	// the rejected request is not counted
+	used := quotaUsed(t, user, rule)
+	if used[0] != 3 || used[1] != 3 {
+		t.Fatalf("requests used %v, want 3 today and this month", used[:2])
+	}
+
+	// other users have their own quotas
+	if err = checkRateLimit(&User{ID: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRateLimitConcurrent(t *testing.T) {
+	const limit = 10
+	setRateLimitRule(t, RateLimitRule{DailyRequests: limit})
+	user := &User{ID: 1}
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if checkRateLimit(user) == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Load() != limit {
+		t.Fatalf("%d concurrent requests allowed, want %d", allowed.Load(), limit)
+	}
+}
+
+func TestCheckRateLimitTokenBucket(t *testing.T) {
+	setRateLimitRule(t, RateLimitRule{Rate: 0.5, Burst: 2, DailyRequests: 100})
+	user := &User{ID: 1}
+	rule, _ := LoadRateLimitRule(testModelID, user.GetTier())
+
+	for i := 0; i < 2; i++ {
+		if err := checkRateLimit(user); err != nil {
+			t.Fatalf("request %d within burst rejected: %v", i+1, err)
+		}
+	}
+	err := checkRateLimit(user)
+	if rateLimitMessageID(err) != "rate_limited" {
+		t.Fatalf("error %v, want rate_limited", err)
+	}
+	var httpError *HttpError
+	if errors.As(err, &httpError) && httpError.RetryAfter != 2 {
+		t.Fatalf("retry after %d seconds, want 2", httpError.RetryAfter)
+	}
+
+	// requests rejected by the bucket are not counted in quotas
+	if used := quotaUsed(t, user, rule); used[0] != 2 {
+		t.Fatalf("requests used %d, want 2", used[0])
+	}
+}
+
+func TestConsumeTokens(t *testing.T) {
+	setRateLimitRule(t, RateLimitRule{DailyTokens: 100})
+	user := &User{ID: 1}
+	rule, _ := LoadRateLimitRule(testModelID, user.GetTier())
+
+	if err := checkRateLimit(user); err != nil {
+		t.Fatal(err)
+	}
+	consumeTokens(user, 60)
+	if err := checkRateLimit(user); err != nil {
+		t.Fatal(err)
+	}
+	consumeTokens(user, 60)
+	if used := quotaUsed(t, user, rule); used[2] != 120 || used[3] != 120 {
+		t.Fatalf("tokens used %v, want 120 today and this month", used[2:])
+	}
+	if err := checkRateLimit(user); rateLimitMessageID(err) != "quota_exceeded" {
+		t.Fatalf("error %v, want quota_exceeded", err)
+	}
+}
+
+func TestCheckRateLimitUnlimited(t *testing.T) {
+	setRateLimitRule(t, RateLimitRule{Tier: UserTierPaid, DailyRequests: 1})
+	user := &User{ID: 1}
+	for i := 0; i < 3; i++ {
+		if err := checkRateLimit(user); err != nil {
+			t.Fatalf("request of a user without rule rejected: %v", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package record
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofiber/websocket/v2"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
+)
+
+// Infer responses are relayed through redis pub/sub, so that the callback from the inference server
+// may reach any replica, and is forwarded to the replica holding the client websocket.
+
+func inferResponseTopic(uuidText string) string {
+	return "moss_infer_response:" + uuidText
+}
+
+// subscribeInferResponse forwards infer responses received by other replicas to responseCh.
+// It returns after the subscription is established, call cancel when the listener exits.
+func subscribeInferResponse(uuidText string, responseCh *responseChannel) (cancel func(), err error) {
+	ctx := context.Background()
+	pubsub := config.RedisClient.Subscribe(ctx, inferResponseTopic(uuidText))
+	if _, err = pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for message := range pubsub.Channel() {
+			var response InferResponseModel
+			err := json.Unmarshal([]byte(message.Payload), &response)
+			if err != nil {
+				Logger.Error("relay infer response unmarshal error", zap.String("payload", message.Payload))
+				continue
+			}
+			responseCh.received.Store(true)
+			select {
+			case responseCh.ch <- response:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		_ = pubsub.Close()
+	}, nil
+}
+
+// publishInferResponse sends response to the replica listening on uuidText, ok is false if no one is listening
+func publishInferResponse(uuidText string, response InferResponseModel) (ok bool, err error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return false, err
+	}
+	receivers, err := config.RedisClient.Publish(context.Background(), inferResponseTopic(uuidText), data).Result()
+	return receivers > 0, err
+}
+
+// relayInferResponse receives responses from the inference server for a listener on another replica
+func relayInferResponse(c *websocket.Conn, uuidText string) {
+	var first = true
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			Logger.Error("receive from infer error", zap.Error(err))
+			_, _ = publishInferResponse(uuidText, InferResponseModel{Status: -1, MessageID: "infer_connection_closed"})
+			return
+		}
+
+		if config.Config.Debug {
+			Logger.Info("relay message from inference", zap.String("uuid", uuidText), zap.ByteString("message", message))
+		}
+
+		var inferResponse InferResponseModel
+		err = json.Unmarshal(message, &inferResponse)
+		if err != nil {
+			Logger.Error("receive from infer error message type", zap.ByteString("message", message), zap.Error(err))
+			continue
+		}
+
+		// continue if sending a heartbeat package
+		if inferResponse.Status == 2 {
+			continue
+		}
+
+		// post process
+		inferResponse.Output = InferPostprocess(inferResponse.Output)
+
+		ok, err := publishInferResponse(uuidText, inferResponse)
+		if err != nil {
+			Logger.Error("relay infer response error", zap.String("uuid", uuidText), zap.Error(err))
+			_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 500, Output: i18n.T(wsLocale(c), "internal_server_error")})
+			return
+		}
+		if !ok {
+			if first {
+				Logger.Error("receive from infer invalid uuid", zap.String("uuid", uuidText))
+				_ = c.WriteJSON(InferResponseModel{Status: -1, StatusCode: 400, Output: i18n.T(wsLocale(c), "bad_request")})
+			} else {
+				// listener has exited
+				_ = c.WriteJSON(InferResponseModel{Status: 0})
+			}
+			return
+		}
+		first = false
+
+		if inferResponse.Status == 0 {
+			_ = c.WriteJSON(InferResponseModel{Status: 0})
+			return
+		}
+	}
+}
@@ -13,6 +13,20 @@ func RegisterRoutes(routes fiber.Router) {
 	routes.Get("/ws/chats/:id/regenerate", websocket.New(RegenerateAsync))
 	routes.Put("/records/:id", ModifyRecord)
 
+	// trash
+	routes.Get("/chats/:id/trash/records", ListTrashRecords)
+	routes.Post("/records/:id/restore", RestoreRecord)
+
+	// dataset
+	routes.Get("/records/dataset", ExportDataset)
+	routes.Get("/records/preference-pairs", ExportPreferencePairs)
+
+	// feedback analytics
+	routes.Get("/analytics/feedback", FeedbackAnalytics)
+
+	// quota
+	routes.Get("/users/me/quota", GetQuota)
+
 	// infer response
 	routes.Get("/ws/response", websocket.New(ReceiveInferResponse))
 
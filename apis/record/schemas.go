@@ -2,6 +2,9 @@ package record
 
 import (
 	"strings"
+	"time"
+
+	"gorm.io/gorm"
 
 	. "MOSS_backend/models"
 	"MOSS_backend/utils"
@@ -21,8 +24,11 @@ type InterruptModel struct {
 }
 
 type ModifyModel struct {
-	Feedback *string `json:"feedback"`
-	Like     *int    `json:"like" validate:"omitempty,oneof=1 0 -1"` // 1 like, -1 dislike, 0 reset
+	Feedback          *string   `json:"feedback"`
+	Like              *int      `json:"like" validate:"omitempty,oneof=1 0 -1"` // 1 like, -1 dislike, 0 reset
+	Categories        *[]string `json:"categories" validate:"omitempty,dive,oneof=factual_error harmful unhelpful formatting other"`
+	Rating            *int      `json:"rating" validate:"omitempty,min=0,max=5"` // 0 reset
+	CorrectedResponse *string   `json:"corrected_response"`
 }
 
 type InferenceRequest struct {
@@ -40,6 +46,25 @@ type InferenceResponse struct {
 	ExtraData any    `json:"extra_data,omitempty"`
 }
 
+type QuotaItem struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+type QuotaResponse struct {
+	ModelID         int        `json:"model_id"`
+	ModelName       string     `json:"model_name"`
+	Tier            string     `json:"tier"`
+	Rate            float64    `json:"rate"` // requests per second, 0 for unlimited
+	Burst           int        `json:"burst"`
+	DailyRequests   *QuotaItem `json:"daily_requests"` // null for unlimited
+	MonthlyRequests *QuotaItem `json:"monthly_requests"`
+	DailyTokens     *QuotaItem `json:"daily_tokens"`
+	MonthlyTokens   *QuotaItem `json:"monthly_tokens"`
+}
+
 // OpenAI
 
 type OpenAIModel struct {
@@ -181,3 +206,71 @@ type OpenAIChatCompletionResponse struct {
 	Choices           []*OpenAIChatCompletionChoice `json:"choices"`
 	Usage             OpenAIChatCompletionUsage     `json:"usage"`
 }
+
+type RecordFilterQuery struct {
+	StartDate string `json:"start_date" query:"start_date" validate:"omitempty,datetime=2006-01-02"` // inclusive
+	EndDate   string `json:"end_date" query:"end_date" validate:"omitempty,datetime=2006-01-02"`     // inclusive
+	ModelID   int    `json:"model_id" query:"model_id" validate:"omitempty,min=1"`
+}
+
+// filter applies the date and model filters on the table
+func (query *RecordFilterQuery) filter(tx *gorm.DB, table string) *gorm.DB {
+	if query.StartDate != "" {
+		start, _ := time.ParseInLocation(time.DateOnly, query.StartDate, time.Local)
+		tx = tx.Where(table+".created_at >= ?", start)
+	}
+	if query.EndDate != "" {
+		end, _ := time.ParseInLocation(time.DateOnly, query.EndDate, time.Local)
+		tx = tx.Where(table+".created_at < ?", end.AddDate(0, 0, 1))
+	}
+	if query.ModelID != 0 {
+		tx = tx.Where(table+".model_id = ?", query.ModelID)
+	}
+	return tx
+}
+
+type DatasetQuery struct {
+	RecordFilterQuery
+	Scrub         bool `json:"scrub" query:"scrub"`                   // replace personal information with placeholders
+	IncludeDirect bool `json:"include_direct" query:"include_direct"` // include records of api consumers in DATASET_CONSUMERS
+}
+
+type PreferencePairsQuery struct {
+	RecordFilterQuery
+	Scrub        bool `json:"scrub" query:"scrub"`
+	ExplicitOnly bool `json:"explicit_only" query:"explicit_only"` // only pairs preferred by likes, ratings or corrections
+}
+
+type FeedbackAnalyticsQuery struct {
+	RecordFilterQuery
+	GroupBy string `json:"group_by" query:"group_by" validate:"omitempty,oneof=model tools date"` // default model
+}
+
+type FeedbackAnalyticsItem struct {
+	Key           string  `json:"key" gorm:"column:group_key"` // model id, tools used or date
+	Total         int     `json:"total"`
+	Likes         int     `json:"likes"`
+	Dislikes      int     `json:"dislikes"`
+	LikeRate      float64 `json:"like_rate" gorm:"-"`
+	DislikeRate   float64 `json:"dislike_rate" gorm:"-"`
+	Rated         int     `json:"rated"`
+	AverageRating float64 `json:"average_rating"`
+	Corrected     int     `json:"corrected"`
+	FactualError  int     `json:"factual_error"`
+	Harmful       int     `json:"harmful"`
+	Unhelpful     int     `json:"unhelpful"`
+	Formatting    int     `json:"formatting"`
+	Other         int     `json:"other"`
+}
+
+type PreferencePair struct {
+	RecordID        int    `json:"record_id"`
+	RejectedID      int    `json:"rejected_id,omitempty"` // the record rejected, 0 for corrections
+	Source          string `json:"source"`                // regeneration or correction
+	Explicit        bool   `json:"explicit"`              // preferred by likes, ratings or corrections
+	MetaInstruction string `json:"meta_instruction"`
+	History         string `json:"history"` // turns before in MOSS format
+	Request         string `json:"request"`
+	Chosen          string `json:"chosen"`
+	Rejected        string `json:"rejected"`
+}
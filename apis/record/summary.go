@@ -0,0 +1,125 @@
+package record
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+const defaultSummaryKeepTurns = 4
+
+var summarizingChats sync.Map // key: chat id
+
+// chatContext makes the prefix and post records of a chat from its non-sensitive records.
+// Records covered by the summary of the chat are replaced with the summary.
+func chatContext(chat *Chat, records Records) (string, RecordModels) {
+	if chat.Summary == "" {
+		return records.GetPrefix(), records.ToRecordModel()
+	}
+
+	system, _, _ := splitMossPrefix(records.GetPrefix())
+	var builder strings.Builder
+	builder.WriteString(system)
+	builder.WriteString(summaryMarker)
+	builder.WriteString(chat.Summary)
+	builder.WriteString("\n")
+
+	var postRecords Records
+	for _, record := range records {
+		if record.ID > chat.SummaryRecordID {
+			postRecords = append(postRecords, record)
+			builder.WriteString(mossRawContent(&record))
+		}
+	}
+	return builder.String(), postRecords.ToRecordModel()
+}
+
+// mossRawContent is the text of a record in MOSS prompts
+func mossRawContent(record *Record) string {
+	if record.RawContent != "" {
+		return record.RawContent
+	}
+	return fmt.Sprintf(
+		"<|Human|>: %s<eoh>\n<|Inner Thoughts|>: None<eot>\n<|Commands|>: None<eoc>\n<|Results|>: None<eor>\n<|MOSS|>: %s<eom>\n",
+		record.Request,
+		record.Response,
+	)
+}
+
+// summarizeChat updates the rolling summary of the chat if its unsummarized records exceed the threshold of the model.
+// The latest turns are kept verbatim.
+func summarizeChat(chatID int, user *User) {
+	if _, loaded := summarizingChats.LoadOrStore(chatID, struct{}{}); loaded {
+		return
+	}
+	defer summarizingChats.Delete(chatID)
+
+	model, err := LoadModelConfigByID(userModelID(user))
+	if err != nil || model.SummarizeThreshold <= 0 {
+		return
+	}
+	keepTurns := model.SummaryKeepTurns
+	if keepTurns <= 0 {
+		keepTurns = defaultSummaryKeepTurns
+	}
+
+	var chat Chat
+	err = DB.Take(&chat, chatID).Error
+	if err != nil {
+		return
+	}
+
+	var records Records
+	err = DB.Order("id").Find(
+		&records,
+		"chat_id = ? AND request_sensitive = false AND response_sensitive = false AND id > ?",
+		chatID, chat.SummaryRecordID,
+	).Error
+	if err != nil || len(records) <= keepTurns {
+		return
+	}
+
+	var tokens int
+	for _, record := range records {
+		tokens += EstimateTokens(record.Request) + EstimateTokens(record.Response)
+	}
+	if tokens < model.SummarizeThreshold {
+		return
+	}
+
+	summarized := records[:len(records)-keepTurns]
+	var builder strings.Builder
+	if chat.Summary != "" {
+		builder.WriteString(summaryMarker)
+		builder.WriteString(chat.Summary)
+		builder.WriteString("\n")
+	}
+	for _, record := range summarized {
+		builder.WriteString(renderTurn(RecordModel{Request: record.Request, Response: record.Response}))
+	}
+
+	summary, err := inferBackground(user, model, summaryInstruction, builder.String())
+	if err != nil {
+		Logger.Error("summarize chat error", zap.Int("chat_id", chatID), zap.Error(err))
+		return
+	}
+	if summary == "" {
+		return
+	}
+
+	// skip if summarized by others in the meantime
+	err = DB.Model(&Chat{}).
+		Where("id = ? AND summary_record_id = ?", chatID, chat.SummaryRecordID).
+		UpdateColumns(map[string]any{
+			"summary":           summary,
+			"summary_record_id": summarized[len(summarized)-1].ID,
+		}).Error
+	if err != nil {
+		Logger.Error("save chat summary error", zap.Int("chat_id", chatID), zap.Error(err))
+	}
+}
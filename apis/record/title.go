@@ -0,0 +1,77 @@
+package record
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+const (
+	titleInstruction = "请为以下对话起一个简短的标题，不超过 15 个字，只输出标题。Give the following conversation a short title, output the title only."
+	titleWaitTimeout = 10 * time.Second
+)
+
+// generateTitle asks the model of the user for a title of the chat after its first record,
+// and saves it unless the user has renamed the chat.
+// The channel receives the title, or is closed without value if not generated.
+func generateTitle(chatID int, user *User, record *Record) <-chan string {
+	titleCh := make(chan string, 1)
+	if record.RequestSensitive || record.ResponseSensitive {
+		close(titleCh)
+		return titleCh
+	}
+	content := renderTurn(RecordModel{Request: record.Request, Response: record.Response})
+
+	go func() {
+		defer close(titleCh)
+
+		model, err := LoadModelConfigByID(userModelID(user))
+		if err != nil || !model.GenerateTitle {
+			return
+		}
+
+		title, err := inferBackground(user, model, titleInstruction, content)
+		if err != nil {
+			Logger.Error("generate chat title error", zap.Int("chat_id", chatID), zap.Error(err))
+			return
+		}
+		title = strings.Trim(strings.SplitN(strings.TrimSpace(title), "\n", 2)[0], " \"'“”《》#*")
+		title = StripContent(title, config.Config.ChatNameLength)
+		if title == "" {
+			return
+		}
+
+		result := DB.Model(&Chat{}).
+			Where("id = ? AND name_modified = ?", chatID, false).
+			UpdateColumn("name", title)
+		if result.Error != nil {
+			Logger.Error("save chat title error", zap.Int("chat_id", chatID), zap.Error(result.Error))
+			return
+		}
+		if result.RowsAffected > 0 {
+			titleCh <- title
+		}
+	}()
+	return titleCh
+}
+
+// sendTitle waits for the generated title and sends it to the websocket client, with status 5
+func sendTitle(c *websocket.Conn, titleCh <-chan string) {
+	select {
+	case title, ok := <-titleCh:
+		if !ok {
+			return
+		}
+		err := c.WriteJSON(InferResponseModel{Status: 5, Output: title})
+		if err != nil {
+			Logger.Warn("write chat title error", zap.Error(err))
+		}
+	case <-time.After(titleWaitTimeout):
+	}
+}
@@ -0,0 +1,122 @@
+package record
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	. "MOSS_backend/models"
+	. "MOSS_backend/utils"
+)
+
+// ListTrashRecords
+// @Summary list deleted records of a chat, e.g. replaced by regeneration
+// @Tags record
+// @Router /chats/{chat_id}/trash/records [get]
+// @Param chat_id path int true "chat id"
+// @Success 200 {array} models.Record
+func ListTrashRecords(c *fiber.Ctx) error {
+	chatID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var chat Chat
+	err = DB.Unscoped().Take(&chat, chatID).Error
+	if err != nil {
+		return err
+	}
+
+	if userID != chat.UserID {
+		return Forbidden()
+	}
+
+	var records = Records{}
+	err = DB.Unscoped().Find(&records, "chat_id = ? AND deleted_at IS NOT NULL", chatID).Error
+	if err != nil {
+		return err
+	}
+
+	return Serialize(c, records)
+}
+
+// RestoreRecord
+// @Summary restore a deleted record into its chat
+// @Description if the record is replaced by regeneration, the live record regenerated from it is deleted instead
+// @Tags record
+// @Router /records/{record_id}/restore [post]
+// @Param record_id path int true "record id"
+// @Success 200 {object} models.Record
+func RestoreRecord(c *fiber.Ctx) error {
+	recordID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	userID, err := GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var record Record
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Unscoped().Take(&record, recordID).Error
+		if err != nil {
+			return err
+		}
+		if !record.DeletedAt.Valid {
+			return BadRequest().WithMessageID("record_not_deleted")
+		}
+
+		var chat Chat
+		err = tx.Clauses(LockingClause).Take(&chat, record.ChatID).Error
+		if err != nil {
+			return err
+		}
+		if chat.UserID != userID {
+			return Forbidden()
+		}
+
+		// swap with the live record regenerated from it, following regenerations of regenerated records
+		successorID := record.ID
+		for {
+			var successor Record
+			err = tx.Unscoped().Where("regenerated_from_id = ?", successorID).Order("id DESC").Take(&successor).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if !successor.DeletedAt.Valid {
+				err = tx.Delete(&successor).Error
+				if err != nil {
+					return err
+				}
+				chat.Count -= 1
+				break
+			}
+			successorID = successor.ID
+		}
+
+		record.DeletedAt = gorm.DeletedAt{}
+		err = tx.Unscoped().Model(&record).UpdateColumn("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		chat.Count += 1
+		return tx.Save(&chat).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return Serialize(c, &record)
+}
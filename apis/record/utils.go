@@ -2,8 +2,11 @@ package record
 
 import (
 	. "MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
 	"errors"
 	"regexp"
+
+	"github.com/gofiber/websocket/v2"
 )
 
 // regexps
@@ -22,10 +25,19 @@ var (
 
 // error messages
 var (
-	userRequestingError            = BadRequest("上一次请求还未结束，请稍后再试。User requesting, please wait and try again")
-	maxInputExceededError          = BadRequest("单次输入限长为 2048 字符。Input no more than 2048 characters").WithMessageType(MaxLength)
-	maxInputExceededFromInferError = BadRequest("单次输入超长，请减少字数并重试。Input max length exceeded, please reduce length and try again").WithMessageType(MaxLength)
-	unknownError                   = InternalServerError("未知错误，请刷新或等待一分钟后再试。Unknown error, please refresh or wait a minute and try again")
+	userRequestingError            = BadRequest().WithMessageID("user_requesting")
+	maxInputExceededError          = BadRequest().WithMessageID("max_input_exceeded").WithMessageType(MaxLength)
+	maxInputExceededFromInferError = BadRequest().WithMessageID("max_input_exceeded_infer").WithMessageType(MaxLength)
+	inferUnavailableError          = ServiceUnavailable().WithMessageID("infer_unavailable").WithRetryAfter(30)
+	unknownError                   = InternalServerError().WithMessageID("unknown_error")
 	ErrSensitive                   = errors.New("sensitive")
 	interruptError                 = NoStatus("client interrupt")
 )
+
+// wsLocale returns the locale of the websocket, set by the locale middleware before upgrade
+func wsLocale(c *websocket.Conn) string {
+	if locale, ok := c.Locals("locale").(string); ok && locale != "" {
+		return locale
+	}
+	return i18n.Fallback
+}
@@ -28,7 +28,7 @@ func InferYocsefAsyncAPI(c *websocket.Conn) {
 				"client websocket return with error",
 				zap.Error(err),
 			)
-			response := InferResponseModel{Status: -1, Output: err.Error()}
+			response := InferResponseModel{Status: -1, Output: LocalizeError(err, wsLocale(c))}
 			var httpError *HttpError
 			if errors.As(err, &httpError) {
 				response.StatusCode = httpError.Code
@@ -46,7 +46,7 @@ func InferYocsefAsyncAPI(c *websocket.Conn) {
 		}
 
 		if body.Request == "" {
-			return BadRequest("内容不能为空")
+			return BadRequest().WithMessageID("request_empty")
 		}
 
 		//ctx, cancel := context.WithCancelCause(context.Background())
@@ -5,6 +5,7 @@ import (
 	"github.com/gofiber/swagger"
 
 	"MOSS_backend/apis/account"
+	"MOSS_backend/apis/announcement"
 	"MOSS_backend/apis/chat"
 	"MOSS_backend/apis/config"
 	"MOSS_backend/apis/record"
@@ -28,5 +29,6 @@ func RegisterRoutes(app *fiber.App) {
 	chat.RegisterRoutes(routes)
 	record.RegisterRoutes(routes)
 	config.RegisterRoutes(routes)
+	announcement.RegisterRoutes(routes)
 
 }
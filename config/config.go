@@ -8,25 +8,69 @@ import (
 
 const AppName = "moss_backend"
 
+const (
+	SenderTencent = "tencent" // tencent cloud ses
+	SenderSmtp    = "smtp"
+	SenderUni     = "uni" // unisms
+	SenderLog     = "log" // writes messages to logs, for development
+)
+
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+	PasswordHasherPbkdf2   = "pbkdf2_sha256"
+)
+
+const (
+	AuthModeKong   = "kong"   // kong verifies tokens and sets X-Consumer-Username
+	AuthModeNative = "native" // tokens are signed and verified by the backend
+)
+
 var Config struct {
 	Mode     string `env:"MODE" envDefault:"dev"`
 	Debug    bool   `env:"DEBUG" envDefault:"false"`
 	Hostname string `env:"HOSTNAME,required"`
 	DbUrl    string `env:"DB_URL,required"`
-	KongUrl  string `env:"KONG_URL,required"`
+	KongUrl  string `env:"KONG_URL"` // required in kong auth mode
 	RedisUrl string `env:"REDIS_URL"`
 	// sending email config
-	EmailUrl          string `env:"EMAIL_URL,required"`
-	TencentSecretID   string `env:"SECRET_ID,required"`
-	TencentSecretKey  string `env:"SECRET_KEY,required"`
-	TencentTemplateID uint64 `env:"TEMPLATE_ID,required"`
+	EmailSender       string `env:"EMAIL_SENDER" envDefault:"tencent"` // tencent, smtp or log
+	EmailUrl          string `env:"EMAIL_URL"`                         // from address, required by tencent and smtp
+	TencentSecretID   string `env:"SECRET_ID"`                         // required by tencent
+	TencentSecretKey  string `env:"SECRET_KEY"`                        // required by tencent
+	TencentTemplateID uint64 `env:"TEMPLATE_ID"`                       // required by tencent
+	SmtpHost          string `env:"SMTP_HOST"`                         // required by smtp
+	SmtpPort          int    `env:"SMTP_PORT" envDefault:"465"`        // implicit tls on 465, starttls otherwise
+	SmtpUsername      string `env:"SMTP_USERNAME"`
+	SmtpPassword      string `env:"SMTP_PASSWORD"`
 	// sending message config
-	UniAccessID   string `env:"UNI_ACCESS_ID,required"`
+	SmsSender     string `env:"SMS_SENDER" envDefault:"uni"` // uni or log
+	UniAccessID   string `env:"UNI_ACCESS_ID"`               // required by uni
 	UniSignature  string `env:"UNI_SIGNATURE" envDefault:"fastnlp"`
-	UniTemplateID string `env:"UNI_TEMPLATE_ID,required"`
+	UniTemplateID string `env:"UNI_TEMPLATE_ID"` // required by uni
+	// log sender writes messages to the logger, and appends them to the file if set
+	SenderLogFile string `env:"SENDER_LOG_FILE"`
 
 	// InferenceUrl string `env:"INFERENCE_URL,required"` // now save it in db
 
+	// inference endpoint pool
+	InferMaxRetries             int `env:"INFER_MAX_RETRIES" envDefault:"2"`
+	EndpointMaxFailures         int `env:"ENDPOINT_MAX_FAILURES" envDefault:"3"`           // consecutive failures before the breaker opens
+	EndpointHealthCheckInterval int `env:"ENDPOINT_HEALTH_CHECK_INTERVAL" envDefault:"10"` // seconds
+
+	// circuit breakers of models and endpoints
+	BreakerWindow           int     `env:"BREAKER_WINDOW" envDefault:"30"` // seconds
+	BreakerMinRequests      int     `env:"BREAKER_MIN_REQUESTS" envDefault:"10"`
+	BreakerFailureRatio     float64 `env:"BREAKER_FAILURE_RATIO" envDefault:"0.5"`
+	BreakerOpenDuration     int     `env:"BREAKER_OPEN_DURATION" envDefault:"30"` // seconds
+	BreakerHalfOpenRequests int     `env:"BREAKER_HALF_OPEN_REQUESTS" envDefault:"3"`
+
+	// inference queue
+	InferConcurrency        int      `env:"INFER_CONCURRENCY" envDefault:"40"`
+	InferQueueCapacity      int      `env:"INFER_QUEUE_CAPACITY" envDefault:"200"`
+	InferQueueTimeout       int      `env:"INFER_QUEUE_TIMEOUT" envDefault:"120"` // seconds
+	InferQueuePriorityTiers []string `env:"INFER_QUEUE_PRIORITY_TIERS" envSeparator:"," envDefault:"admin,paid"`
+
 	// 敏感信息检测
 	EnableSensitiveCheck   bool   `env:"ENABLE_SENSITIVE_CHECK" envDefault:"true"`
 	SensitiveCheckPlatform string `env:"SENSITIVE_CHECK_PLATFORM" envDefault:"ShuMei"` // one of ShuMei or DiTing
@@ -42,9 +86,40 @@ var Config struct {
 
 	VerificationCodeExpires int `env:"VERIFICATION_CODE_EXPIRES" envDefault:"10"`
 	ChatNameLength          int `env:"CHAT_NAME_LENGTH" envDefault:"30"`
-
-	AccessExpireTime  int `env:"ACCESS_EXPIRE_TIME" envDefault:"30"`  // 30 minutes
-	RefreshExpireTime int `env:"REFRESH_EXPIRE_TIME" envDefault:"30"` // 30 days
+	TrashRetentionDays      int `env:"TRASH_RETENTION_DAYS" envDefault:"30"` // deleted chats and records are purged after
+	ErasureGraceDays        int `env:"ERASURE_GRACE_DAYS" envDefault:"7"`    // personal data of deleted users is erased after
+
+	// auth
+	AuthMode           string `env:"AUTH_MODE" envDefault:"kong"`           // kong or native
+	AccessExpireTime   int    `env:"ACCESS_EXPIRE_TIME" envDefault:"30"`    // 30 minutes
+	RefreshExpireTime  int    `env:"REFRESH_EXPIRE_TIME" envDefault:"30"`   // 30 days
+	JwtKeyRotationDays int    `env:"JWT_KEY_ROTATION_DAYS" envDefault:"30"` // native mode signs tokens with a new key after
+
+	// brute-force protection
+	LoginFreeAttempts       int `env:"LOGIN_FREE_ATTEMPTS" envDefault:"3"`        // failures before delays and captcha
+	LoginMaxAttempts        int `env:"LOGIN_MAX_ATTEMPTS" envDefault:"10"`        // failures of an account before lockout
+	LoginMaxAttemptsPerIP   int `env:"LOGIN_MAX_ATTEMPTS_PER_IP" envDefault:"50"` // failures from an ip before lockout
+	LoginLockoutMinutes     int `env:"LOGIN_LOCKOUT_MINUTES" envDefault:"15"`     // failures are forgotten after
+	VerificationCooldown    int `env:"VERIFICATION_COOLDOWN" envDefault:"60"`     // seconds between codes to the same target
+	VerificationDailyLimit  int `env:"VERIFICATION_DAILY_LIMIT" envDefault:"10"`  // codes to an email or phone per day
+	VerificationDailyPerIP  int `env:"VERIFICATION_DAILY_PER_IP" envDefault:"30"` // codes requested from an ip per day
+	VerificationMaxAttempts int `env:"VERIFICATION_MAX_ATTEMPTS" envDefault:"5"`  // wrong codes before the code is invalidated
+
+	// passwords are hashed with PASSWORD_HASHER, and rehashed on login if hashed otherwise or with other costs
+	PasswordHasher     string `env:"PASSWORD_HASHER" envDefault:"argon2id"` // argon2id, bcrypt or pbkdf2_sha256
+	Argon2Time         uint32 `env:"ARGON2_TIME" envDefault:"2"`
+	Argon2Memory       uint32 `env:"ARGON2_MEMORY" envDefault:"19456"` // KiB
+	Argon2Threads      uint8  `env:"ARGON2_THREADS" envDefault:"1"`
+	BcryptCost         int    `env:"BCRYPT_COST" envDefault:"12"`
+	Pbkdf2Iterations   int    `env:"PBKDF2_ITERATIONS" envDefault:"216000"`
+	PasswordMinLength  int    `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	PasswordMinClasses int    `env:"PASSWORD_MIN_CLASSES" envDefault:"2"` // of lowercase, uppercase, digits and symbols
+
+	TotpIssuer string `env:"TOTP_ISSUER" envDefault:"MOSS"` // shown in authenticator apps
+
+	// captcha, disabled if the verify url is empty. reCAPTCHA, hCaptcha and Turnstile are compatible
+	CaptchaVerifyUrl string `env:"CAPTCHA_VERIFY_URL"`
+	CaptchaSecret    string `env:"CAPTCHA_SECRET"`
 
 	CallbackUrl string `env:"CALLBACK_URL,required"` // async callback url
 
@@ -52,6 +127,9 @@ var Config struct {
 
 	PassSensitiveCheckUsername []string `env:"PASS_SENSITIVE_CHECK_USERNAME"`
 
+	// api consumers agreeing to share their inference records in datasets
+	DatasetConsumers []string `env:"DATASET_CONSUMERS" envSeparator:","`
+
 	// tools
 	EnableTools       bool   `env:"ENABLE_TOOLS" envDefault:"true"`
 	ToolsSearchUrl    string `env:"TOOLS_SEARCH_URL,required"`
@@ -66,6 +144,10 @@ var Config struct {
 	DefaultModelID              int      `env:"DEFAULT_MODEL_ID" envDefault:"1"`
 	NoNeedInviteCodeEmailSuffix []string `env:"NO_NEED_INVITE_CODE_EMAIL_SUFFIX" envSeparator:"," envDefault:"fudan.edu.cn"`
 
+	// single sign-on
+	OidcProviders        OidcProviders `env:"OIDC_PROVIDERS"`          // json array of providers
+	OidcLoginRedirectUrl string        `env:"OIDC_LOGIN_REDIRECT_URL"` // frontend page after login, tokens are set in cookies
+
 	// yocsef
 	YocsefInferenceUrl string `env:"YOCSEF_INFERENCE_URL"`
 }
@@ -75,7 +157,48 @@ func InitConfig() {
 	if err = env.Parse(&Config); err != nil {
 		panic(err)
 	}
+	switch Config.AuthMode {
+	case AuthModeKong:
+		if Config.KongUrl == "" {
+			panic("KONG_URL is required in kong auth mode")
+		}
+	case AuthModeNative:
+	default:
+		panic("unsupported auth mode")
+	}
+	switch Config.PasswordHasher {
+	case PasswordHasherArgon2id, PasswordHasherBcrypt, PasswordHasherPbkdf2:
+	default:
+		panic("unsupported password hasher")
+	}
+	checkSenders()
 	fmt.Printf("%+v\n", &Config)
 
 	initCache()
 }
+
+func checkSenders() {
+	switch Config.EmailSender {
+	case SenderTencent:
+		if Config.EmailUrl == "" || Config.TencentSecretID == "" || Config.TencentSecretKey == "" || Config.TencentTemplateID == 0 {
+			panic("EMAIL_URL, SECRET_ID, SECRET_KEY and TEMPLATE_ID are required by tencent email sender")
+		}
+	case SenderSmtp:
+		if Config.EmailUrl == "" || Config.SmtpHost == "" {
+			panic("EMAIL_URL and SMTP_HOST are required by smtp email sender")
+		}
+	case SenderLog:
+	default:
+		panic("unsupported email sender")
+	}
+
+	switch Config.SmsSender {
+	case SenderUni:
+		if Config.UniAccessID == "" || Config.UniTemplateID == "" {
+			panic("UNI_ACCESS_ID and UNI_TEMPLATE_ID are required by uni sms sender")
+		}
+	case SenderLog:
+	default:
+		panic("unsupported sms sender")
+	}
+}
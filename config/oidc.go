@@ -0,0 +1,21 @@
+package config
+
+import "encoding/json"
+
+// OidcProvider is an OpenID Connect provider for single sign-on
+type OidcProvider struct {
+	Name           string   `json:"name"` // in login urls, /oidc/{name}/login
+	Issuer         string   `json:"issuer"`
+	ClientID       string   `json:"client_id"`
+	ClientSecret   string   `json:"client_secret"`
+	RedirectUrl    string   `json:"redirect_url"` // callback registered in the provider, /api/oidc/{name}/callback
+	Scopes         []string `json:"scopes"`       // openid, email and profile if empty
+	SkipInviteCode bool     `json:"skip_invite_code"`
+}
+
+// OidcProviders is parsed from a json array in env
+type OidcProviders []OidcProvider
+
+func (providers *OidcProviders) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]OidcProvider)(providers))
+}
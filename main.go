@@ -16,6 +16,7 @@ package main
 
 import (
 	"MOSS_backend/apis"
+	"MOSS_backend/apis/account"
 	"MOSS_backend/apis/record"
 	"MOSS_backend/config"
 	_ "MOSS_backend/docs"
@@ -24,6 +25,7 @@ import (
 	"MOSS_backend/utils"
 	"MOSS_backend/utils/auth"
 	"MOSS_backend/utils/kong"
+	"MOSS_backend/utils/token"
 	"encoding/json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/robfig/cron/v3"
@@ -39,9 +41,12 @@ func main() {
 	auth.InitCache()
 
 	// connect to kong
-	err := kong.Ping()
-	if err != nil {
-		panic(err)
+	var err error
+	if config.Config.AuthMode == config.AuthModeKong {
+		err = kong.Ping()
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	app := fiber.New(fiber.Config{
@@ -84,6 +89,20 @@ func startTasks() {
 	if err != nil {
 		panic(err)
 	}
+	_, err = c.AddFunc("CRON_TZ=Asia/Shanghai 0 4 * * *", models.PurgeTrashTask) // run every day 04:00 +8:00
+	if err != nil {
+		panic(err)
+	}
+	_, err = c.AddFunc("CRON_TZ=Asia/Shanghai 30 4 * * *", account.EraseUsersTask) // run every day 04:30 +8:00
+	if err != nil {
+		panic(err)
+	}
+	_, err = c.AddFunc("CRON_TZ=Asia/Shanghai 0 5 * * *", token.CleanSessionsTask) // run every day 05:00 +8:00
+	if err != nil {
+		panic(err)
+	}
 	go c.Start()
 	go record.UserLockCheck()
+	go record.EndpointHealthCheck()
+	go models.ConfigReloadListener()
 }
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"MOSS_backend/utils/token"
+)
+
+// Authenticate verifies the access token in native auth mode and sets user_id and session_id in locals.
+// Requests without a valid token continue anonymously, and fail in handlers requiring login.
+func Authenticate(c *fiber.Ctx) error {
+	if tokenString := token.FromRequest(c); tokenString != "" {
+		claims, err := token.Verify(tokenString, token.TypeAccess)
+		if err == nil {
+			c.Locals("user_id", claims.UID)
+			c.Locals("session_id", claims.SessionID)
+		}
+	}
+	return c.Next()
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"net/url"
 	"time"
 
 	"github.com/ansrivas/fiberprometheus/v2"
@@ -20,6 +21,10 @@ func RegisterMiddlewares(app *fiber.App) {
 	}
 	app.Use(cors.New(cors.Config{AllowOrigins: "*"}))
 	//app.Use(GetUserID)
+	if config.Config.AuthMode == config.AuthModeNative {
+		app.Use(Authenticate)
+	}
+	app.Use(Locale)
 
 	// prometheus
 	prom := fiberprometheus.NewWith(config.AppName, config.AppName, "http")
@@ -38,6 +43,21 @@ func GetUserID(c *fiber.Ctx) error {
 	return c.Next()
 }
 
+// loggedURL is the original url without the token in jwt query
+func loggedURL(c *fiber.Ctx) string {
+	if c.Query("jwt") == "" {
+		return c.OriginalURL()
+	}
+	originalURL, err := url.Parse(c.OriginalURL())
+	if err != nil {
+		return c.Path()
+	}
+	query := originalURL.Query()
+	query.Del("jwt")
+	originalURL.RawQuery = query.Encode()
+	return originalURL.String()
+}
+
 func MyLogger(c *fiber.Ctx) error {
 	startTime := time.Now()
 	chainErr := c.Next()
@@ -58,7 +78,7 @@ func MyLogger(c *fiber.Ctx) error {
 	output := []zap.Field{
 		zap.Int("status_code", c.Response().StatusCode()),
 		zap.String("method", c.Method()),
-		zap.String("origin_url", c.OriginalURL()),
+		zap.String("origin_url", loggedURL(c)),
 		zap.String("remote_ip", utils.GetRealIP(c)),
 		zap.Int64("latency", latency),
 	}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"MOSS_backend/models"
+	"MOSS_backend/utils"
+	"MOSS_backend/utils/i18n"
+)
+
+// Locale sets the locale of messages in locals, from Accept-Language, then the preference of the user,
+// then the ip. Websockets read it from locals after upgrade.
+func Locale(c *fiber.Ctx) error {
+	locale, ok := i18n.Match(c.Get(fiber.HeaderAcceptLanguage))
+	if !ok {
+		locale = userLocale(c)
+	}
+	if locale == "" {
+		locale = utils.GetLocaleByIP(utils.GetRealIP(c))
+	}
+	c.Locals("locale", locale)
+	return c.Next()
+}
+
+// userLocale returns the preferred locale of the user, empty if not login or not set
+func userLocale(c *fiber.Ctx) string {
+	userID, err := models.GetUserID(c)
+	if err != nil {
+		return ""
+	}
+	var user models.User
+	err = models.LoadUserByIDFromCache(userID, &user)
+	if err != nil || !i18n.Supported(user.Locale) {
+		return ""
+	}
+	return user.Locale
+}
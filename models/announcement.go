@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"MOSS_backend/utils/i18n"
+)
+
+type AnnouncementSeverity = string
+
+const (
+	SeverityInfo     AnnouncementSeverity = "info"
+	SeverityWarning  AnnouncementSeverity = "warning"
+	SeverityCritical AnnouncementSeverity = "critical"
+)
+
+const (
+	RegionCN     = "cn"
+	RegionGlobal = "global"
+)
+
+// Announcement is shown to the targeted users between StartTime and EndTime.
+// Empty targeting fields match all users.
+type Announcement struct {
+	ID                 int                  `json:"id"`
+	CreatedAt          time.Time            `json:"created_at"`
+	UpdatedAt          time.Time            `json:"updated_at"`
+	StartTime          time.Time            `json:"start_time" gorm:"index"`
+	EndTime            time.Time            `json:"end_time" gorm:"index"`
+	Severity           AnnouncementSeverity `json:"severity" gorm:"size:16"`
+	Title              string               `json:"title"`
+	Content            string               `json:"content"`
+	TitleCN            string               `json:"title_cn"`   // for users in China, Title is used if empty
+	ContentCN          string               `json:"content_cn"` // for users in China, Content is used if empty
+	Dismissible        bool                 `json:"dismissible"`
+	ModelIDs           []int                `json:"model_ids" gorm:"serializer:json"`
+	Region             string               `json:"region" gorm:"size:16"` // cn or global
+	NewUsersWithinDays int                  `json:"new_users_within_days"` // users joined within the days
+}
+
+// AnnouncementDismissal is an announcement closed by a user
+type AnnouncementDismissal struct {
+	UserID         int       `json:"user_id" gorm:"primaryKey"`
+	AnnouncementID int       `json:"announcement_id" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Localize returns the title and content in the locale, chinese versions are used for zh
+func (announcement *Announcement) Localize(locale string) (title, content string) {
+	title, content = announcement.Title, announcement.Content
+	if locale == i18n.Zh {
+		if announcement.TitleCN != "" {
+			title = announcement.TitleCN
+		}
+		if announcement.ContentCN != "" {
+			content = announcement.ContentCN
+		}
+	}
+	return
+}
+
+// Targets tells whether the announcement is shown to the user in the region, user is nil if not login
+func (announcement *Announcement) Targets(user *User, region string) bool {
+	if announcement.Region != "" && announcement.Region != region {
+		return false
+	}
+	if len(announcement.ModelIDs) > 0 && (user == nil || !slices.Contains(announcement.ModelIDs, user.ModelID)) {
+		return false
+	}
+	if announcement.NewUsersWithinDays > 0 &&
+		(user == nil || user.JoinedTime.Before(time.Now().AddDate(0, 0, -announcement.NewUsersWithinDays))) {
+		return false
+	}
+	return true
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
 )
 
@@ -15,13 +16,83 @@ type Chat struct {
 	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index:idx_chat_user_deleted,priority:2"`
 	UserID            int            `json:"user_id" gorm:"index:idx_chat_user_deleted,priority:1"`
 	Name              string         `json:"name"`
-	Count             int            `json:"count"` // Record 条数
+	NameModified      bool           `json:"name_modified"` // renamed by user, never generate title
+	Count             int            `json:"count"`         // Record 条数
 	Records           Records        `json:"records,omitempty"`
 	MaxLengthExceeded bool           `json:"max_length_exceeded"`
+	Summary           string         `json:"summary" gorm:"type:text"` // rolling summary of older records
+	SummaryRecordID   int            `json:"summary_record_id"`        // records with id not greater than this are summarized
+	FolderID          *int           `json:"folder_id" gorm:"index"`
+	Pinned            bool           `json:"pinned"`
+	Archived          bool           `json:"archived"`
+	Tags              []string       `json:"tags" gorm:"-:all"`
 }
 
 type Chats []Chat
 
+// ChatFolder groups chats of a user
+type ChatFolder struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    int       `json:"user_id" gorm:"index"`
+	Name      string    `json:"name" gorm:"size:64"`
+}
+
+// ChatTag is a user-defined tag of a chat
+type ChatTag struct {
+	ChatID int    `gorm:"primaryKey"`
+	Name   string `gorm:"primaryKey;size:64;index"`
+}
+
+// LoadTags fills the tags of chats
+func (chats Chats) LoadTags() error {
+	if len(chats) == 0 {
+		return nil
+	}
+	chatIDs := make([]int, len(chats))
+	for i := range chats {
+		chatIDs[i] = chats[i].ID
+		chats[i].Tags = []string{}
+	}
+	var tags []ChatTag
+	err := DB.Order("name").Find(&tags, "chat_id IN ?", chatIDs).Error
+	if err != nil {
+		return err
+	}
+	tagMap := make(map[int][]string, len(chats))
+	for _, tag := range tags {
+		tagMap[tag.ChatID] = append(tagMap[tag.ChatID], tag.Name)
+	}
+	for i := range chats {
+		if chatTags, ok := tagMap[chats[i].ID]; ok {
+			chats[i].Tags = chatTags
+		}
+	}
+	return nil
+}
+
+// SetTags replaces the tags of the chat
+func (chat *Chat) SetTags(tx *gorm.DB, tags []string) error {
+	err := tx.Where("chat_id = ?", chat.ID).Delete(&ChatTag{}).Error
+	if err != nil {
+		return err
+	}
+	chat.Tags = []string{}
+	if len(tags) == 0 {
+		return nil
+	}
+	chatTags := make([]ChatTag, 0, len(tags))
+	for _, tag := range tags {
+		if slices.Contains(chat.Tags, tag) {
+			continue
+		}
+		chat.Tags = append(chat.Tags, tag)
+		chatTags = append(chatTags, ChatTag{ChatID: chat.ID, Name: tag})
+	}
+	return tx.Create(&chatTags).Error
+}
+
 type Record struct {
 	ID                 int            `json:"id"`
 	CreatedAt          time.Time      `json:"created_at"`
@@ -36,13 +107,31 @@ type Record struct {
 	ProcessedExtraData any            `json:"processed_extra_data" gorm:"serializer:json"`
 	LikeData           int            `json:"like_data"` // 1 like, -1 dislike
 	Feedback           string         `json:"feedback"`
+	FeedbackCategories []string       `json:"feedback_categories" gorm:"serializer:json"`
+	Rating             int            `json:"rating"` // 1 to 5, 0 not rated
+	CorrectedResponse  string         `json:"corrected_response"`
+	RegeneratedFromID  int            `json:"regenerated_from_id"`  // the record replaced by this one
+	Tools              string         `json:"tools" gorm:"size:64"` // types of tools used, comma separated
 	RequestSensitive   bool           `json:"request_sensitive"`
 	ResponseSensitive  bool           `json:"response_sensitive"`
 	InnerThoughts      string         `json:"inner_thoughts"`
+	ModelID            int            `json:"model_id"`   // model config used by the inference
+	TokenCount         int            `json:"-" gorm:"-"` // tokens used by the inference, for quota
 }
 
 type Records []Record
 
+// feedback categories of a record
+const (
+	FeedbackFactualError = "factual_error"
+	FeedbackHarmful      = "harmful"
+	FeedbackUnhelpful    = "unhelpful"
+	FeedbackFormatting   = "formatting"
+	FeedbackOther        = "other"
+)
+
+var FeedbackCategories = []string{FeedbackFactualError, FeedbackHarmful, FeedbackUnhelpful, FeedbackFormatting, FeedbackOther}
+
 func (record *Record) Preprocess(_ *fiber.Ctx) error {
 	if record.ResponseSensitive {
 		record.Response = DefaultResponse
@@ -120,15 +209,11 @@ type Param struct {
 }
 
 func LoadParamToMap(m map[string]any) error {
-	if DB == nil {
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
 		return nil
 	}
-	var params []Param
-	err := DB.Find(&params).Error
-	if err != nil {
-		return err
-	}
-	for _, param := range params {
+	for _, param := range snapshot.params {
 		m[param.Name] = param.Value
 	}
 	return nil
@@ -139,6 +224,7 @@ type DirectRecord struct {
 	CreatedAt        time.Time
 	Duration         float64
 	ConsumerUsername string
+	ModelID          int
 	Context          string
 	Request          string
 	Response         string
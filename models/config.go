@@ -1,11 +1,11 @@
 package models
 
 import (
-	"time"
-
 	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
 
-	"MOSS_backend/config"
 	"MOSS_backend/utils"
 )
 
@@ -16,88 +16,182 @@ const (
 )
 
 type ModelConfig struct {
-	ID                       int             `json:"id"`
-	InnerThoughtsPostprocess bool            `json:"inner_thoughts_postprocess" default:"false"`
-	Description              string          `json:"description"`
-	DefaultPluginConfig      map[string]bool `json:"default_plugin_config" gorm:"serializer:json"`
-	Url                      string          `json:"url"`
-	CallbackUrl              string          `json:"callback_url"`
-	APIType                  APIType         `json:"api_type"`
-	OpenAIModelName          string          `json:"openai_model_name"`
-	OpenAISystemPrompt       string          `json:"openai_system_prompt"`
-	EnableSensitiveCheck     bool            `json:"enable_sensitive_check"`
-	EndDelimiter             string          `json:"end_delimiter"`
+	ID                       int              `json:"id"`
+	InnerThoughtsPostprocess bool             `json:"inner_thoughts_postprocess" default:"false"`
+	Description              string           `json:"description"`
+	DefaultPluginConfig      map[string]bool  `json:"default_plugin_config" gorm:"serializer:json"`
+	Url                      string           `json:"url"`
+	Endpoints                ModelEndpoints   `json:"endpoints" gorm:"serializer:json"`
+	CallbackUrl              string           `json:"callback_url"`
+	APIType                  APIType          `json:"api_type"`
+	OpenAIModelName          string           `json:"openai_model_name"`
+	OpenAISystemPrompt       string           `json:"openai_system_prompt"`
+	EnableSensitiveCheck     bool             `json:"enable_sensitive_check"`
+	EndDelimiter             string           `json:"end_delimiter"`
+	MaxContextLength         int              `json:"max_context_length"` // estimated tokens of history and request, 0 for unlimited
+	TruncateStrategy         TruncateStrategy `json:"truncate_strategy" gorm:"size:32"`
+	KeepLastTurns            int              `json:"keep_last_turns"`     // for keep_last_n
+	SummarizeThreshold       int              `json:"summarize_threshold"` // estimated tokens of unsummarized records to start summarizing a chat, 0 to disable
+	SummaryKeepTurns         int              `json:"summary_keep_turns"`  // latest turns never summarized, default 4
+	GenerateTitle            bool             `json:"generate_title"`      // generate chat title after the first record
 }
 
+// TruncateStrategy decides which turns are kept when the history exceeds MaxContextLength
+type TruncateStrategy = string
+
+const (
+	TruncateDropOldest TruncateStrategy = "drop_oldest" // default
+	TruncateKeepLastN  TruncateStrategy = "keep_last_n" // keep the system prompt and the last KeepLastTurns turns
+	TruncateSummarize  TruncateStrategy = "summarize"   // replace dropped turns with their summary
+)
+
 type ModelConfigs = []*ModelConfig
 
 func (cfg *ModelConfig) TableName() string {
 	return "language_model_config"
 }
 
-type Config struct {
-	ID             int           `json:"id"`
-	InviteRequired bool          `json:"invite_required"`
-	OffenseCheck   bool          `json:"offense_check"`
-	Notice         string        `json:"notice"`
-	ModelConfig    []ModelConfig `json:"model_config" gorm:"-:all"`
+// ModelEndpoint is one inference node of a model
+type ModelEndpoint struct {
+	Url       string `json:"url" validate:"required,url"`
+	Weight    int    `json:"weight" validate:"min=0"` // 0 is treated as 1
+	HealthUrl string `json:"health_url" validate:"omitempty,url"`
+}
+
+type ModelEndpoints []ModelEndpoint
+
+// EndpointPool returns all inference endpoints of the model.
+// If no pool is configured, Url is used as the only endpoint.
+func (cfg *ModelConfig) EndpointPool() ModelEndpoints {
+	if len(cfg.Endpoints) > 0 {
+		return cfg.Endpoints
+	}
+	if cfg.Url == "" {
+		return nil
+	}
+	return ModelEndpoints{{Url: cfg.Url, Weight: 1}}
 }
 
-const configCacheName = "moss_backend_config"
-const configCacheExpire = 24 * time.Hour
+type Config struct {
+	ID                int             `json:"id"`
+	Version           int             `json:"version"` // increased on every update
+	InviteRequired    bool            `json:"invite_required"`
+	OffenseCheck      bool            `json:"offense_check"`
+	Notice            string          `json:"notice"`
+	AdminTotpRequired bool            `json:"admin_totp_required"` // admin apis are forbidden for admins without two-factor authentication
+	ModelConfig       []ModelConfig   `json:"model_config" gorm:"-:all"`
+	RateLimitRules    []RateLimitRule `json:"rate_limit_rules" gorm:"-:all"`
+}
 
+// LoadConfig copies the config in memory, modifying it does not affect the snapshot
 func LoadConfig(configObjectPtr *Config) error {
-	if config.GetCache(configCacheName, configObjectPtr) != nil {
-		if err := DB.First(configObjectPtr).Error; err != nil {
-			return err
-		}
-		if err := DB.Find(&(configObjectPtr.ModelConfig)).Error; err != nil {
-			return err
-		}
-		_ = config.SetCache(configCacheName, *configObjectPtr, configCacheExpire)
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return errConfigNotLoaded
+	}
+	*configObjectPtr = snapshot.config
+	configObjectPtr.ModelConfig = make([]ModelConfig, len(snapshot.config.ModelConfig))
+	for i, modelConfig := range snapshot.config.ModelConfig {
+		modelConfig.DefaultPluginConfig = maps.Clone(modelConfig.DefaultPluginConfig)
+		configObjectPtr.ModelConfig[i] = modelConfig
 	}
+	configObjectPtr.RateLimitRules = slices.Clone(snapshot.config.RateLimitRules)
 	return nil
 }
 
+// LoadModelConfigs returns the model configs in memory, which should not be modified
 func LoadModelConfigs() (ModelConfigs, error) {
-	var modelConfigs ModelConfigs
-	if err := DB.Find(&modelConfigs).Error; err != nil {
-		return nil, err
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return nil, errConfigNotLoaded
+	}
+	modelConfigs := make(ModelConfigs, len(snapshot.config.ModelConfig))
+	for i := range snapshot.config.ModelConfig {
+		modelConfigs[i] = &snapshot.config.ModelConfig[i]
 	}
 	return modelConfigs, nil
 }
 
+// LoadModelConfigByName returns the model config in memory, which should not be modified
 func LoadModelConfigByName(name string) (*ModelConfig, error) {
-	var modelConfig ModelConfig
-	if err := DB.Where("description = ?", name).First(&modelConfig).Error; err != nil {
-		return nil, err
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return nil, errConfigNotLoaded
 	}
-	return &modelConfig, nil
+	for i := range snapshot.config.ModelConfig {
+		if snapshot.config.ModelConfig[i].Description == name {
+			return &snapshot.config.ModelConfig[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
 }
 
+// LoadModelConfigByID returns the model config in memory, which should not be modified
 func LoadModelConfigByID(id int) (*ModelConfig, error) {
-	var modelConfig ModelConfig
-	if err := DB.Where("id = ?", id).First(&modelConfig).Error; err != nil {
-		return nil, err
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return nil, errConfigNotLoaded
 	}
-	return &modelConfig, nil
+	for i := range snapshot.config.ModelConfig {
+		if snapshot.config.ModelConfig[i].ID == id {
+			return &snapshot.config.ModelConfig[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
 }
 
-func UpdateConfig(configObjectPtr *Config) error {
-	err := DB.Model(&Config{ID: 1}).Updates(configObjectPtr).Error
-	if err != nil {
-		utils.Logger.Error("failed to update config", zap.Error(err))
-		return err
-	}
-	for i := range configObjectPtr.ModelConfig {
-		err = DB.Model(&configObjectPtr.ModelConfig).Updates(&configObjectPtr.ModelConfig[i]).Error
+// UpdateConfig saves the config and records the change in config history
+func UpdateConfig(configObjectPtr *Config, author int, comment string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(LockingClause).Take(&Config{}, "id = ?", configObjectPtr.ID).Error
+		if err != nil {
+			return err
+		}
+		var old Config
+		err = loadConfigFromDB(tx, &old)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Config{ID: configObjectPtr.ID}).Select("InviteRequired", "OffenseCheck", "Notice", "AdminTotpRequired").Updates(configObjectPtr).Error
+		if err != nil {
+			utils.Logger.Error("failed to update config", zap.Error(err))
+			return err
+		}
+		err = tx.Model(&Config{ID: configObjectPtr.ID}).UpdateColumn("version", gorm.Expr("version + 1")).Error
+		if err != nil {
+			return err
+		}
+		for i := range configObjectPtr.ModelConfig {
+			err = tx.Model(&configObjectPtr.ModelConfig[i]).Select("*").Updates(&configObjectPtr.ModelConfig[i]).Error
+			if err != nil {
+				utils.Logger.Error("failed to update model config", zap.Error(err))
+				return err
+			}
+		}
+		err = updateRateLimitRules(tx, configObjectPtr.RateLimitRules)
 		if err != nil {
-			utils.Logger.Error("failed to update model config", zap.Error(err))
+			utils.Logger.Error("failed to update rate limit rules", zap.Error(err))
 			return err
 		}
+
+		var current Config
+		err = loadConfigFromDB(tx, &current)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&ConfigHistory{
+			Version:  current.Version,
+			AuthorID: author,
+			Comment:  comment,
+			Snapshot: &current,
+			Diff:     DiffConfig(&old, &current),
+		}).Error
+	})
+	if err != nil {
+		return err
 	}
-	_ = config.SetCache(configCacheName, *configObjectPtr, configCacheExpire)
-	return nil
+	return InvalidateConfig()
 }
 
 func GetPluginConfig(modelID int) (map[string]bool, error) {
@@ -113,3 +207,19 @@ func GetPluginConfig(modelID int) (map[string]bool, error) {
 	// if not found, return default config of first model
 	return configObject.ModelConfig[0].DefaultPluginConfig, nil
 }
+
+// updateRateLimitRules replaces all rate limit rules with rules
+func updateRateLimitRules(tx *gorm.DB, rules []RateLimitRule) error {
+	ids := make([]int, 0, len(rules))
+	for i := range rules {
+		if err := tx.Save(&rules[i]).Error; err != nil {
+			return err
+		}
+		ids = append(ids, rules[i].ID)
+	}
+	querySet := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+	if len(ids) > 0 {
+		querySet = querySet.Where("id NOT IN ?", ids)
+	}
+	return querySet.Delete(&RateLimitRule{}).Error
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConfigHistory is the config after a change
+type ConfigHistory struct {
+	ID        int            `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Version   int            `json:"version" gorm:"uniqueIndex"`
+	AuthorID  int            `json:"author_id"` // 0 for the system
+	Comment   string         `json:"comment"`
+	Snapshot  *Config        `json:"snapshot,omitempty" gorm:"serializer:json"`
+	Diff      []ConfigChange `json:"diff" gorm:"serializer:json"`
+}
+
+// ConfigChange is a changed field, path is like model_config[id=1].url
+type ConfigChange struct {
+	Path string `json:"path"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+// initConfigHistory records the current config as the first version, so that it can be rolled back to
+func initConfigHistory() error {
+	var history ConfigHistory
+	err := DB.Omit("snapshot").Take(&history).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var current Config
+	err = loadConfigFromDB(DB, &current)
+	if err != nil {
+		return err
+	}
+	return DB.Create(&ConfigHistory{
+		Version:  current.Version,
+		Comment:  "initial",
+		Snapshot: &current,
+	}).Error
+}
+
+// DiffConfig lists changed fields from old to new, sorted by path
+func DiffConfig(old, new *Config) []ConfigChange {
+	oldValues, newValues := flattenConfig(old), flattenConfig(new)
+	changes := make([]ConfigChange, 0)
+	for path, newValue := range newValues {
+		if oldValue, ok := oldValues[path]; !ok || oldValue != newValue {
+			changes = append(changes, ConfigChange{Path: path, Old: oldValue, New: newValue})
+		}
+	}
+	for path, oldValue := range oldValues {
+		if _, ok := newValues[path]; !ok {
+			changes = append(changes, ConfigChange{Path: path, Old: oldValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func flattenConfig(configObjectPtr *Config) map[string]any {
+	var value any
+	data, _ := json.Marshal(configObjectPtr)
+	_ = json.Unmarshal(data, &value)
+
+	values := make(map[string]any)
+	flattenJSON("", value, values)
+	delete(values, "id")
+	delete(values, "version")
+	return values
+}
+
+func flattenJSON(path string, value any, values map[string]any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			flattenJSON(strings.TrimPrefix(path+"."+key, "."), item, values)
+		}
+	case []any:
+		for i, item := range value {
+			// items with id are identified by id, in case of insertion or deletion
+			if object, ok := item.(map[string]any); ok && object["id"] != nil {
+				flattenJSON(fmt.Sprintf("%s[id=%v]", path, object["id"]), item, values)
+			} else {
+				flattenJSON(fmt.Sprintf("%s[%d]", path, i), item, values)
+			}
+		}
+	default:
+		values[path] = value
+	}
+}
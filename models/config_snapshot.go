@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+	"MOSS_backend/utils"
+)
+
+// Config, model configs, rate limit rules and params are held in memory by each replica.
+// After an update, replicas are notified to reload them through redis pub/sub.
+
+const configReloadTopic = "moss_backend_config_reload"
+
+var errConfigNotLoaded = errors.New("config not loaded")
+
+type configSnapshot struct {
+	config Config
+	params []Param
+}
+
+var currentConfigSnapshot atomic.Pointer[configSnapshot]
+
+func loadConfigSnapshot() *configSnapshot {
+	return currentConfigSnapshot.Load()
+}
+
+// ConfigVersion returns the version of config in memory
+func ConfigVersion() int {
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return 0
+	}
+	return snapshot.config.Version
+}
+
+// ReloadConfig loads config from database into memory of this replica
+func ReloadConfig() error {
+	var snapshot configSnapshot
+	if err := loadConfigFromDB(DB, &snapshot.config); err != nil {
+		return err
+	}
+	if err := DB.Find(&snapshot.params).Error; err != nil {
+		return err
+	}
+	currentConfigSnapshot.Store(&snapshot)
+	return nil
+}
+
+func loadConfigFromDB(tx *gorm.DB, configObjectPtr *Config) error {
+	if err := tx.First(configObjectPtr).Error; err != nil {
+		return err
+	}
+	if err := tx.Order("id").Find(&configObjectPtr.ModelConfig).Error; err != nil {
+		return err
+	}
+	return tx.Order("id").Find(&configObjectPtr.RateLimitRules).Error
+}
+
+// InvalidateConfig reloads config on this replica and notifies other replicas to reload
+func InvalidateConfig() error {
+	err := ReloadConfig()
+	if err != nil {
+		return err
+	}
+	if config.Config.RedisUrl == "" {
+		return nil
+	}
+	err = config.RedisClient.Publish(context.Background(), configReloadTopic, strconv.Itoa(ConfigVersion())).Err()
+	if err != nil {
+		// other replicas stay on the old config until the next reload
+		utils.Logger.Error("publish config reload error", zap.Error(err))
+	}
+	return nil
+}
+
+// ConfigReloadListener reloads config when notified, it never returns if redis is used
+func ConfigReloadListener() {
+	if config.Config.RedisUrl == "" {
+		return
+	}
+	// go-redis resubscribes after reconnecting, the channel is never closed
+	pubsub := config.RedisClient.Subscribe(context.Background(), configReloadTopic)
+	for message := range pubsub.Channel() {
+		err := ReloadConfig()
+		if err != nil {
+			utils.Logger.Error("reload config error", zap.String("version", message.Payload), zap.Error(err))
+			continue
+		}
+		utils.Logger.Info("config reloaded", zap.Int("version", ConfigVersion()))
+	}
+}
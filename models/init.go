@@ -81,15 +81,24 @@ func InitDB() {
 	err = DB.AutoMigrate(
 		User{},
 		Chat{},
+		ChatFolder{},
+		ChatTag{},
 		Record{},
 		ActiveStatus{},
 		Config{},
+		ConfigHistory{},
 		ModelConfig{},
 		InviteCode{},
 		Param{},
 		EmailBlacklist{},
 		DirectRecord{},
 		UserOffense{},
+		RateLimitRule{},
+		Announcement{},
+		AnnouncementDismissal{},
+		JwtKey{},
+		Session{},
+		UserIdentity{},
 	)
 	if err != nil {
 		panic(err)
@@ -105,4 +114,14 @@ func InitDB() {
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		DB.Create(&configModelObject)
 	}
+
+	err = initConfigHistory()
+	if err != nil {
+		panic(err)
+	}
+
+	err = ReloadConfig()
+	if err != nil {
+		panic(err)
+	}
 }
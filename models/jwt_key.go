@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// JwtKey signs tokens in native auth mode.
+// The newest key signs new tokens, and keys verify tokens until ExpiresAt.
+type JwtKey struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	Secret    string    `json:"-" gorm:"size:64"`
+}
@@ -0,0 +1,72 @@
+package models
+
+type UserTier = string
+
+const (
+	UserTierFree  UserTier = "free"
+	UserTierPaid  UserTier = "paid"
+	UserTierAdmin UserTier = "admin"
+)
+
+// RateLimitRule limits the inference requests of users.
+// ModelID 0 matches all models and an empty Tier matches all tiers, a zero limit means unlimited.
+// Quota counters are shared by all models matched by the same rule.
+type RateLimitRule struct {
+	ID              int      `json:"id"`
+	ModelID         int      `json:"model_id"`
+	Tier            UserTier `json:"tier" gorm:"size:32"`
+	Rate            float64  `json:"rate"` // requests per second of the token bucket
+	Burst           int      `json:"burst"`
+	DailyRequests   int      `json:"daily_requests"`
+	MonthlyRequests int      `json:"monthly_requests"`
+	DailyTokens     int      `json:"daily_tokens"`
+	MonthlyTokens   int      `json:"monthly_tokens"`
+}
+
+// MatchRateLimitRule finds the most specific rule for the model and tier, nil if no rule matches
+func (cfg *Config) MatchRateLimitRule(modelID int, tier UserTier) *RateLimitRule {
+	var (
+		matched   *RateLimitRule
+		bestScore = -1
+	)
+	for i := range cfg.RateLimitRules {
+		rule := &cfg.RateLimitRules[i]
+		score := 0
+		if rule.ModelID != 0 {
+			if rule.ModelID != modelID {
+				continue
+			}
+			score += 2
+		}
+		if rule.Tier != "" {
+			if rule.Tier != tier {
+				continue
+			}
+			score += 1
+		}
+		if score > bestScore {
+			matched = rule
+			bestScore = score
+		}
+	}
+	return matched
+}
+
+// LoadRateLimitRule matches the rule in the config in memory, which should not be modified
+func LoadRateLimitRule(modelID int, tier UserTier) (*RateLimitRule, error) {
+	snapshot := loadConfigSnapshot()
+	if snapshot == nil {
+		return nil, errConfigNotLoaded
+	}
+	return snapshot.config.MatchRateLimitRule(modelID, tier), nil
+}
+
+func (user *User) GetTier() UserTier {
+	if user.IsAdmin {
+		return UserTierAdmin
+	}
+	if user.Tier == "" {
+		return UserTierFree
+	}
+	return user.Tier
+}
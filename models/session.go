@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is a login on a device, kept alive by rotating its refresh token
+type Session struct {
+	ID           int        `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       int        `json:"user_id" gorm:"index"`
+	Device       string     `json:"device" gorm:"size:256"` // user agent
+	IP           string     `json:"ip" gorm:"size:32"`
+	LastSeen     time.Time  `json:"last_seen"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"index"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	RefreshID    string     `json:"-" gorm:"size:32"`             // jti of the only valid refresh token
+	CredentialID string     `json:"-" gorm:"size:64"`             // kong jwt credential of the session in kong auth mode
+	LegacyToken  *string    `json:"-" gorm:"size:64;uniqueIndex"` // sha256 of the refresh token issued before sessions and upgraded to this one
+}
+
+func (session *Session) Active() bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(time.Now())
+}
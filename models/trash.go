@@ -0,0 +1,79 @@
+package models
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+)
+
+// emptyChatExpire is the time after which unused empty chats are purged
+const emptyChatExpire = 24 * time.Hour
+
+// TrashPurgeTime returns the time after which an item deleted at deletedAt is purged
+func TrashPurgeTime(deletedAt time.Time) time.Time {
+	return deletedAt.AddDate(0, 0, config.Config.TrashRetentionDays)
+}
+
+// purgeBatchSize limits rows removed in a transaction, so that tables are not locked for long
+const purgeBatchSize = 500
+
+// PurgeTrashTask permanently removes chats and records deleted before the retention days,
+// and empty chats never used
+func PurgeTrashTask() {
+	deadline := time.Now().AddDate(0, 0, -config.Config.TrashRetentionDays)
+	emptyDeadline := time.Now().Add(-emptyChatExpire)
+	for {
+		var chatIDs []int
+		err := DB.Unscoped().Model(&Chat{}).
+			Where("deleted_at < ? OR (count = 0 AND created_at < ?)", deadline, emptyDeadline).
+			Limit(purgeBatchSize).
+			Pluck("id", &chatIDs).Error
+		if err != nil {
+			log.Println("purge trash err", err)
+			return
+		}
+		if len(chatIDs) == 0 {
+			break
+		}
+
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			err = tx.Unscoped().Where("chat_id IN ?", chatIDs).Delete(&Record{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("chat_id IN ?", chatIDs).Delete(&ChatTag{}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", chatIDs).Delete(&Chat{}).Error
+		})
+		if err != nil {
+			log.Println("purge trash err", err)
+			return
+		}
+	}
+
+	for {
+		var recordIDs []int
+		err := DB.Unscoped().Model(&Record{}).
+			Where("deleted_at < ?", deadline).
+			Limit(purgeBatchSize).
+			Pluck("id", &recordIDs).Error
+		if err != nil {
+			log.Println("purge trash err", err)
+			return
+		}
+		if len(recordIDs) == 0 {
+			break
+		}
+
+		err = DB.Unscoped().Where("id IN ?", recordIDs).Delete(&Record{}).Error
+		if err != nil {
+			log.Println("purge trash err", err)
+			return
+		}
+	}
+}
@@ -1,18 +1,13 @@
 package models
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"strconv"
-	"strings"
 	"time"
 
 	"MOSS_backend/config"
 	"MOSS_backend/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/websocket/v2"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
@@ -34,10 +29,18 @@ type User struct {
 	ShareConsent          bool            `json:"share_consent" gorm:"default:true"`
 	InviteCode            string          `json:"-" gorm:"size:32"`
 	IsAdmin               bool            `json:"is_admin"`
+	Tier                  UserTier        `json:"tier" gorm:"size:32;default:'free'"`
 	DisableSensitiveCheck bool            `json:"disable_sensitive_check"`
 	Banned                bool            `json:"banned"`
 	ModelID               int             `json:"model_id" default:"1" gorm:"default:1"`
 	PluginConfig          map[string]bool `json:"plugin_config" gorm:"serializer:json"`
+	ErasureRequestedAt    *time.Time      `json:"-"`                     // personal data is erased after the grace period
+	TokensRevokedAt       *time.Time      `json:"-"`                     // native auth mode rejects tokens issued before
+	Locale                string          `json:"locale" gorm:"size:16"` // language of messages, empty to follow the browser
+	TotpEnabled           bool            `json:"totp_enabled"`
+	TotpSecret            string          `json:"-" gorm:"size:64"`         // base32, pending until TotpEnabled
+	TotpLastStep          int64           `json:"-"`                        // the last used time step, codes can not be replayed
+	RecoveryCodes         []string        `json:"-" gorm:"serializer:json"` // sha256 of unused recovery codes
 }
 
 func GetUserCacheKey(userID int) string {
@@ -47,13 +50,22 @@ func GetUserCacheKey(userID int) string {
 const UserCacheExpire = 48 * time.Hour
 
 func GetUserID(c *fiber.Ctx) (int, error) {
+	// set by the auth middleware in native auth mode
+	if userID, ok := c.Locals("user_id").(int); ok {
+		return userID, nil
+	}
+
 	if config.Config.Mode == "dev" || config.Config.Mode == "test" {
 		return 1, nil
 	}
 
+	if config.Config.AuthMode == config.AuthModeNative {
+		return 0, utils.Unauthorized()
+	}
+
 	id, err := strconv.Atoi(c.Get("X-Consumer-Username"))
 	if err != nil {
-		return 0, utils.Unauthorized("Unauthorized")
+		return 0, utils.Unauthorized()
 	}
 
 	return id, nil
@@ -94,8 +106,7 @@ func LoadUserByID(userID int) (*User, error) {
 		user.ModelID = config.Config.DefaultModelID
 		updated = true
 	} else {
-		var modelConfig ModelConfig
-		err = DB.Take(&modelConfig, user.ModelID).Error
+		_, err = LoadModelConfigByID(user.ModelID)
 		if err != nil {
 			user.ModelID = config.Config.DefaultModelID
 			updated = true
@@ -143,85 +154,26 @@ func LoadUser(c *fiber.Ctx) (*User, error) {
 	return LoadUserByID(userID)
 }
 
-func GetUserIDFromWs(c *websocket.Conn) (int, error) {
-	// get cookie named access or query jwt
-	token := c.Query("jwt")
-	if token == "" {
-		token = c.Cookies("access")
-		if token == "" {
-			return 0, utils.Unauthorized()
-		}
-	}
-	// get data
-	data, err := parseJWT(token, false)
-	if err != nil {
-		return 0, err
-	}
-	id, ok := data["id"] // get id
-	if !ok {
-		return 0, utils.Unauthorized()
-	}
-	return int(id.(float64)), nil
-}
-
-func LoadUserFromWs(c *websocket.Conn) (*User, error) {
-	userID, err := GetUserIDFromWs(c)
+// LoadAdmin loads the user and checks if the user is an admin
+func LoadAdmin(c *fiber.Ctx) (*User, error) {
+	user, err := LoadUser(c)
 	if err != nil {
 		return nil, err
 	}
-	return LoadUserByID(userID)
-}
-
-// parseJWT extracts and parse token
-func parseJWT(token string, bearer bool) (Map, error) {
-	if len(token) < 7 {
-		return nil, errors.New("bearer token required")
+	if !user.IsAdmin {
+		return nil, utils.Forbidden()
 	}
-
-	if bearer {
-		token = token[7:]
-	}
-
-	payloads := strings.SplitN(token[7:], ".", 3) // extract "Bearer "
-	if len(payloads) < 3 {
-		return nil, errors.New("jwt token required")
-	}
-
-	// jwt encoding ignores padding, so RawStdEncoding should be used instead of StdEncoding
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(payloads[1]) // the middle one is payload
-	if err != nil {
-		return nil, err
-	}
-
-	var value Map
-	err = json.Unmarshal(payloadBytes, &value)
-	return value, err
-}
-
-func GetUserByRefreshToken(c *fiber.Ctx) (*User, error) {
-	// get id
-	userID, err := GetUserID(c)
-	if err != nil {
-		return nil, err
-	}
-
-	tokenString := c.Get("Authorization")
-	if tokenString == "" { // token can be in either header or cookie
-		tokenString = c.Cookies("refresh")
-	}
-
-	payload, err := parseJWT(tokenString, true)
-	if err != nil {
-		return nil, err
-	}
-
-	if tokenType, ok := payload["type"]; !ok || tokenType != "refresh" {
-		return nil, utils.Unauthorized("refresh token invalid")
+	if !user.TotpEnabled {
+		var configObject Config
+		err = LoadConfig(&configObject)
+		if err != nil {
+			return nil, err
+		}
+		if configObject.AdminTotpRequired {
+			return nil, utils.Forbidden().WithMessageID("admin_totp_required")
+		}
 	}
-
-	var user User
-	err = LoadUserByIDFromCache(userID, &user)
-	return &user, err
+	return user, nil
 }
 
 func (user *User) UpdateIP(ip string) {
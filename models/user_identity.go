@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// UserIdentity links an account of an oidc provider to a user
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int       `json:"user_id" gorm:"index"`
+	Issuer    string    `json:"issuer" gorm:"size:255;uniqueIndex:idx_identity"`
+	Subject   string    `json:"subject" gorm:"size:255;uniqueIndex:idx_identity"`
+	Email     string    `json:"email" gorm:"size:128"` // verified email when linked
+}
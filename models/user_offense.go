@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"MOSS_backend/utils"
+)
 
 type UserOffense struct {
 	ID        int
@@ -9,7 +13,8 @@ type UserOffense struct {
 	Type      UserOffenseType
 }
 
-const OffenseMessage = `您因为多次违规，账号被锁定，如有意见请发送邮件至 txsun19@fudan.edu.cn`
+// ErrUserBanned is returned to users banned for repeated offenses
+var ErrUserBanned = utils.Forbidden().WithMessageID("user_banned")
 
 type UserOffenseType = int
 
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"MOSS_backend/config"
+)
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// CaptchaEnabled tells whether captcha challenges are required for risky requests
+func CaptchaEnabled() bool {
+	return config.Config.CaptchaVerifyUrl != ""
+}
+
+// VerifyCaptcha checks the captcha response from the client with the siteverify api,
+// which is the same for reCAPTCHA, hCaptcha and Cloudflare Turnstile
+func VerifyCaptcha(response, ip string) (bool, error) {
+	if !CaptchaEnabled() {
+		return true, nil
+	}
+	if response == "" {
+		return false, nil
+	}
+
+	rsp, err := captchaClient.PostForm(config.Config.CaptchaVerifyUrl, url.Values{
+		"secret":   {config.Config.CaptchaSecret},
+		"response": {response},
+		"remoteip": {ip},
+	})
+	if err != nil {
+		return false, err
+	}
+	defer rsp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
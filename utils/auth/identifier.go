@@ -4,15 +4,32 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"golang.org/x/crypto/pbkdf2"
 	"hash"
 	"math/big"
 	"strconv"
 	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+
+	"MOSS_backend/config"
 )
 
+// Password hashes are stored in one of the formats:
+//
+//	pbkdf2_sha256$<iterations>$<salt>$<hash>
+//	$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+//	$2a$<cost>$<salt and hash>
+//
+// New hashes are made with PASSWORD_HASHER, older ones are upgraded on login, see NeedsRehash.
+
+const argon2KeyLen = 32
+
 func passwordHash(bytePassword, salt []byte, iterations, KeyLen int, hash func() hash.Hash) string {
 	return base64.StdEncoding.EncodeToString(pbkdf2.Key(bytePassword, salt, iterations, KeyLen, hash))
 }
@@ -35,18 +52,101 @@ func saltGenerator(stringLen int) ([]byte, error) {
 }
 
 func MakePassword(rawPassword string) (string, error) {
+	switch config.Config.PasswordHasher {
+	case config.PasswordHasherBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(rawPassword), config.Config.BcryptCost)
+		return string(hashed), err
+	case config.PasswordHasherPbkdf2:
+		return makePbkdf2Password(rawPassword, config.Config.Pbkdf2Iterations)
+	default:
+		return makeArgon2Password(rawPassword)
+	}
+}
+
+func makePbkdf2Password(rawPassword string, iterations int) (string, error) {
 	salt, err := saltGenerator(12)
 	if err != nil {
 		return "", err
 	}
 	algorithm := "sha256"
-	iterations := 216000
 	hashBase64 := passwordHash([]byte(rawPassword), salt, iterations, 32, sha256.New)
 
 	return fmt.Sprintf("pbkdf2_%v$%v$%v$%v", algorithm, iterations, string(salt), hashBase64), nil
 }
 
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func makeArgon2Password(rawPassword string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	params := argon2Params{
+		memory:  config.Config.Argon2Memory,
+		time:    config.Config.Argon2Time,
+		threads: config.Config.Argon2Threads,
+	}
+	key := argon2.IDKey([]byte(rawPassword), salt, params.time, params.memory, params.threads, argon2KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func parseArgon2Password(encryptPassword string) (params argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encryptPassword, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("parse encryptPassword error: %v", encryptPassword)
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version: %v", version)
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	return params, salt, key, err
+}
+
 func CheckPassword(rawPassword, encryptPassword string) (bool, error) {
+	if encryptPassword == "" { // users registered by single sign-on have no password until reset
+		return false, nil
+	}
+
+	switch {
+	case strings.HasPrefix(encryptPassword, "$argon2id$"):
+		params, salt, key, err := parseArgon2Password(encryptPassword)
+		if err != nil {
+			return false, err
+		}
+		computed := argon2.IDKey([]byte(rawPassword), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1, nil
+	case strings.HasPrefix(encryptPassword, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(encryptPassword), []byte(rawPassword))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
 	splitEncryptedPassword := strings.Split(encryptPassword, "$")
 	if len(splitEncryptedPassword) != 4 {
 		return false, fmt.Errorf("parse encryptPassword error: %v", encryptPassword)
@@ -75,5 +175,28 @@ func CheckPassword(rawPassword, encryptPassword string) (bool, error) {
 
 	hashBase64 := passwordHash([]byte(rawPassword), []byte(salt), iterations, hashOutputSize, hashFactory)
 
-	return hashBase64 == splitEncryptedPassword[3], nil
+	return subtle.ConstantTimeCompare([]byte(hashBase64), []byte(splitEncryptedPassword[3])) == 1, nil
+}
+
+// NeedsRehash tells whether the password is hashed by another hasher or with other costs than configured.
+// Call it after CheckPassword succeeds, and save MakePassword of the raw password.
+func NeedsRehash(encryptPassword string) bool {
+	if encryptPassword == "" {
+		return false
+	}
+	switch config.Config.PasswordHasher {
+	case config.PasswordHasherBcrypt:
+		cost, err := bcrypt.Cost([]byte(encryptPassword))
+		return err != nil || cost != config.Config.BcryptCost
+	case config.PasswordHasherPbkdf2:
+		parts := strings.Split(encryptPassword, "$")
+		return len(parts) != 4 || parts[0] != config.PasswordHasherPbkdf2 || parts[1] != strconv.Itoa(config.Config.Pbkdf2Iterations)
+	default:
+		params, _, key, err := parseArgon2Password(encryptPassword)
+		return err != nil || len(key) != argon2KeyLen || params != argon2Params{
+			memory:  config.Config.Argon2Memory,
+			time:    config.Config.Argon2Time,
+			threads: config.Config.Argon2Threads,
+		}
+	}
 }
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"MOSS_backend/config"
+)
+
+const (
+	passwordMaxLength    = 128
+	bcryptPasswordMaxLen = 72 // bytes, bcrypt ignores the rest
+)
+
+// CheckPasswordStrength tells whether the password has at least PASSWORD_MIN_LENGTH characters,
+// and at least PASSWORD_MIN_CLASSES classes of lowercase, uppercase, digits and symbols
+func CheckPasswordStrength(password string) bool {
+	length := utf8.RuneCountInString(password)
+	if length < config.Config.PasswordMinLength || length > passwordMaxLength {
+		return false
+	}
+	if config.Config.PasswordHasher == config.PasswordHasherBcrypt && len(password) > bcryptPasswordMaxLen {
+		return false
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsLower(char):
+			lower = true
+		case unicode.IsUpper(char):
+			upper = true
+		case unicode.IsDigit(char):
+			digit = true
+		case unicode.IsControl(char):
+			return false
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	return classes >= config.Config.PasswordMinClasses
+}
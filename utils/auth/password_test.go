@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"MOSS_backend/config"
+)
+
+func setPasswordConfig(hasher string) {
+	config.Config.PasswordHasher = hasher
+	config.Config.Argon2Time = 1
+	config.Config.Argon2Memory = 1024
+	config.Config.Argon2Threads = 1
+	config.Config.BcryptCost = 4
+	config.Config.Pbkdf2Iterations = 1000
+}
+
+func TestPasswordHashers(t *testing.T) {
+	for _, test := range []struct {
+		hasher string
+		prefix string
+	}{
+		{config.PasswordHasherArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{config.PasswordHasherBcrypt, "$2a$04$"},
+		{config.PasswordHasherPbkdf2, "pbkdf2_sha256$1000$"},
+	} {
+		t.Run(test.hasher, func(t *testing.T) {
+			setPasswordConfig(test.hasher)
+			encrypted, err := MakePassword("Correct-Horse-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encrypted, test.prefix) {
+				t.Fatalf("hash %s, want prefix %s", encrypted, test.prefix)
+			}
+
+			ok, err := CheckPassword("Correct-Horse-1", encrypted)
+			if err != nil || !ok {
+				t.Fatalf("correct password rejected: %v", err)
+			}
+			ok, err = CheckPassword("correct-horse-1", encrypted)
+			if err != nil || ok {
+				t.Fatalf("wrong password accepted: %v", err)
+			}
+			if NeedsRehash(encrypted) {
+				t.Fatal("fresh hash needs rehash")
+			}
+		})
+	}
+}
+
+func TestCheckLegacyPassword(t *testing.T) {
+	// hashed by the original pbkdf2 implementation with 216000 iterations
+	setPasswordConfig(config.PasswordHasherArgon2id)
+	encrypted, err := makePbkdf2Password("password", 216000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := CheckPassword("password", encrypted)
+	if err != nil || !ok {
+		t.Fatalf("legacy password rejected: %v", err)
+	}
+	if !NeedsRehash(encrypted) {
+		t.Fatal("legacy hash not upgraded")
+	}
+
+	// users registered by single sign-on have no password
+	ok, err = CheckPassword("", "")
+	if err != nil || ok {
+		t.Fatal("empty password accepted")
+	}
+	if NeedsRehash("") {
+		t.Fatal("empty password rehashed")
+	}
+
+	_, err = CheckPassword("password", "$argon2id$broken")
+	if err == nil {
+		t.Fatal("broken hash accepted")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	setPasswordConfig(config.PasswordHasherArgon2id)
+	argon2Password, _ := MakePassword("password")
+	setPasswordConfig(config.PasswordHasherBcrypt)
+	bcryptPassword, _ := MakePassword("password")
+
+	// raising costs upgrades hashes
+	setPasswordConfig(config.PasswordHasherArgon2id)
+	config.Config.Argon2Time = 2
+	if !NeedsRehash(argon2Password) {
+		t.Fatal("argon2 hash with old time not upgraded")
+	}
+	if !NeedsRehash(bcryptPassword) {
+		t.Fatal("bcrypt hash not upgraded to argon2")
+	}
+
+	setPasswordConfig(config.PasswordHasherBcrypt)
+	config.Config.BcryptCost = 5
+	if !NeedsRehash(bcryptPassword) {
+		t.Fatal("bcrypt hash with old cost not upgraded")
+	}
+	if !NeedsRehash(argon2Password) {
+		t.Fatal("argon2 hash not upgraded to bcrypt")
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	setPasswordConfig(config.PasswordHasherArgon2id)
+	config.Config.PasswordMinLength = 8
+	config.Config.PasswordMinClasses = 2
+
+	for _, test := range []struct {
+		password string
+		ok       bool
+	}{
+		{"abc12345", true},
+		{"密码密码密码密码1", true},
+		{"correct horse battery staple", true}, // spaces are symbols
+		{"abcdefgh", false},
+		{"12345678", false},
+		{"abc1234", false},
+		{"abc\x001234", false},
+		{strings.Repeat("a1", 65), false},
+	} {
+		if CheckPasswordStrength(test.password) != test.ok {
+			t.Errorf("strength of %q, want %v", test.password, test.ok)
+		}
+	}
+
+	config.Config.PasswordMinClasses = 3
+	if CheckPasswordStrength("abc12345") {
+		t.Error("password of 2 classes accepted")
+	}
+	if !CheckPasswordStrength("Abc12345") {
+		t.Error("password of 3 classes rejected")
+	}
+
+	// bcrypt ignores bytes after 72
+	config.Config.PasswordHasher = config.PasswordHasherBcrypt
+	if CheckPasswordStrength("Abc1" + strings.Repeat("密", 30)) {
+		t.Error("password longer than 72 bytes accepted by bcrypt")
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	"MOSS_backend/utils"
+)
+
+// Login failures are counted per account and per ip in redis. After LoginFreeAttempts failures
+// of an account, each attempt waits twice as long as the previous one, and captcha is required.
+// Accounts and ips are locked out after their max attempts until LoginLockoutMinutes pass.
+// Each attempt is reserved as a failure before the password is checked, so that parallel attempts
+// can not bypass the delay and lockout, and released by LoginSucceeded.
+// Redis errors are logged and never block users.
+
+const maxLoginDelay = 5 * time.Minute
+
+func loginFailureKey(kind, id string) string {
+	return "moss_login_failure:" + kind + ":" + id
+}
+
+type LoginStatus struct {
+	RetryAfter      int  // seconds to wait before the next attempt, 0 if allowed
+	Locked          bool // too many failures, locked out until RetryAfter
+	CaptchaRequired bool
+}
+
+// reserveLoginScript checks the failures of the account and the ip, and counts the attempt if allowed.
+// KEYS: account key, ip key
+// ARGV: now, lockout, max attempts, max attempts per ip, free attempts, max delay, all durations in milliseconds
+// returns {locked, milliseconds to wait, captcha required}
+var reserveLoginScript = redis.NewScript(`
+local now, lockout = tonumber(ARGV[1]), tonumber(ARGV[2])
+local maxAttempts = {tonumber(ARGV[3]), tonumber(ARGV[4])}
+local freeAttempts, maxDelay = tonumber(ARGV[5]), tonumber(ARGV[6])
+
+local counts, lasts = {}, {}
+for i, key in ipairs(KEYS) do
+	local values = redis.call('HMGET', key, 'count', 'last')
+	counts[i] = tonumber(values[1]) or 0
+	lasts[i] = tonumber(values[2]) or 0
+	if maxAttempts[i] > 0 and counts[i] >= maxAttempts[i] and lasts[i] + lockout > now then
+		return {1, lasts[i] + lockout - now, 0}
+	end
+end
+
+local captcha, wait = 0, 0
+local extra = counts[1] - freeAttempts
+if extra >= 0 then
+	captcha = 1
+	if extra > 0 then
+		wait = lasts[1] + math.min(1000 * 2 ^ math.min(extra - 1, 16), maxDelay) - now
+	end
+end
+if counts[2] >= freeAttempts then
+	captcha = 1
+end
+if wait > 0 then
+	return {0, wait, captcha}
+end
+
+for _, key in ipairs(KEYS) do
+	redis.call('HINCRBY', key, 'count', 1)
+	redis.call('HSET', key, 'last', now)
+	redis.call('PEXPIRE', key, lockout)
+end
+return {0, 0, captcha}
+`)
+
+// releaseLoginScript forgets the failures of the account, and releases the reserved attempt of the ip
+var releaseLoginScript = redis.NewScript(`
+redis.call('DEL', KEYS[1])
+if redis.call('HEXISTS', KEYS[2], 'count') == 1 and tonumber(redis.call('HGET', KEYS[2], 'count')) > 0 then
+	redis.call('HINCRBY', KEYS[2], 'count', -1)
+end
+return 0
+`)
+
+func retryAfter(until time.Time) int {
+	return int(math.Ceil(time.Until(until).Seconds()))
+}
+
+// ReserveLogin tells whether the account can try to login from the ip. If allowed, the attempt
+// is counted as a failure at once, call LoginSucceeded after the credentials are verified.
+func ReserveLogin(account, ip string) LoginStatus {
+	lockout := time.Duration(config.Config.LoginLockoutMinutes) * time.Minute
+	result, err := reserveLoginScript.Run(
+		context.Background(),
+		config.RedisClient,
+		[]string{loginFailureKey("account", account), loginFailureKey("ip", ip)},
+		time.Now().UnixMilli(),
+		lockout.Milliseconds(),
+		config.Config.LoginMaxAttempts,
+		config.Config.LoginMaxAttemptsPerIP,
+		config.Config.LoginFreeAttempts,
+		maxLoginDelay.Milliseconds(),
+	).Int64Slice()
+	if err != nil || len(result) != 3 {
+		utils.Logger.Error("reserve login attempt error", zap.Error(err))
+		return LoginStatus{}
+	}
+
+	status := LoginStatus{Locked: result[0] == 1}
+	if result[1] > 0 {
+		status.RetryAfter = int(math.Ceil(float64(result[1]) / 1000))
+	}
+	if !status.Locked {
+		status.CaptchaRequired = result[2] == 1 && CaptchaEnabled()
+	}
+	return status
+}
+
+// LoginSucceeded forgets the failures of the account and releases the attempt reserved for the ip,
+// earlier failures of the ip are kept
+func LoginSucceeded(account, ip string) {
+	err := releaseLoginScript.Run(
+		context.Background(),
+		config.RedisClient,
+		[]string{loginFailureKey("account", account), loginFailureKey("ip", ip)},
+	).Err()
+	if err != nil {
+		utils.Logger.Error("reset login failures error", zap.Error(err))
+	}
+}
+
+// Verification codes to an email or phone are sent at most once per VerificationCooldown,
+// and at most VerificationDailyLimit times per day. Requests from an ip are limited per day too.
+
+type VerificationStatus struct {
+	RetryAfter int  // seconds to wait before sending again, 0 if allowed
+	Daily      bool // the daily limit is reached
+}
+
+// ClaimVerification reserves sending a verification code to the target requested from the ip
+func ClaimVerification(target, ip string) VerificationStatus {
+	ctx := context.Background()
+	now := time.Now()
+
+	if cooldown := time.Duration(config.Config.VerificationCooldown) * time.Second; cooldown > 0 {
+		key := "moss_verification_cooldown:" + target
+		ok, err := config.RedisClient.SetNX(ctx, key, 1, cooldown).Result()
+		if err != nil {
+			utils.Logger.Error("verification cooldown error", zap.Error(err))
+			return VerificationStatus{}
+		}
+		if !ok {
+			ttl, _ := config.RedisClient.TTL(ctx, key).Result()
+			return VerificationStatus{RetryAfter: max(1, int(math.Ceil(ttl.Seconds())))}
+		}
+	}
+
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	date := now.Format("20060102")
+	for _, limit := range []struct {
+		key   string
+		limit int
+	}{
+		{"moss_verification_daily:" + date + ":" + target, config.Config.VerificationDailyLimit},
+		{"moss_verification_daily_ip:" + date + ":" + ip, config.Config.VerificationDailyPerIP},
+	} {
+		if limit.limit <= 0 {
+			continue
+		}
+		count, err := incrWithExpire(ctx, limit.key, 24*time.Hour)
+		if err != nil {
+			utils.Logger.Error("verification daily limit error", zap.Error(err))
+			return VerificationStatus{}
+		}
+		if count > int64(limit.limit) {
+			return VerificationStatus{RetryAfter: retryAfter(tomorrow), Daily: true}
+		}
+	}
+	return VerificationStatus{}
+}
+
+func incrWithExpire(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	count, err := config.RedisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		err = config.RedisClient.Expire(ctx, key, expiration).Err()
+	}
+	return count, err
+}
+
+// verificationFailed counts a wrong code, and tells whether the code should be invalidated
+func verificationFailed(info, scope string) bool {
+	if config.Config.VerificationMaxAttempts <= 0 {
+		return false
+	}
+	key := "moss_verification_failure:" + scope + "-" + info
+	count, err := incrWithExpire(context.Background(), key, time.Duration(config.Config.VerificationCodeExpires)*time.Minute)
+	if err != nil {
+		utils.Logger.Error("count verification failure error", zap.Error(err))
+		return false
+	}
+	return count >= int64(config.Config.VerificationMaxAttempts)
+}
+
+func resetVerificationFailures(info, scope string) {
+	_ = config.RedisClient.Del(context.Background(), "moss_verification_failure:"+scope+"-"+info).Err()
+}
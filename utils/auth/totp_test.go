@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// test vectors of RFC 6238 for SHA1, truncated to 6 digits
+func TestTotpCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		if code := totpCode(secret, test.unix/totpPeriod, totpDigits); code != test.code {
+			t.Errorf("code at %d is %s, want %s", test.unix, code, test.code)
+		}
+	}
+
+	// 8 digits of RFC 6238
+	if code := totpCode(secret, 59/totpPeriod, 8); code != "94287082" {
+		t.Errorf("8 digits code is %s, want 94287082", code)
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	if _, ok := ValidateTotp(secret, "050471", now, 0); !ok {
+		t.Error("valid code rejected")
+	}
+	// clock drift of one step
+	if _, ok := ValidateTotp(secret, "050471", now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Error("code of the previous step rejected")
+	}
+	if _, ok := ValidateTotp(secret, "050471", now.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Error("expired code accepted")
+	}
+	// replay
+	if _, ok := ValidateTotp(secret, "050471", now, step); ok {
+		t.Error("used code accepted")
+	}
+	if _, ok := ValidateTotp(secret, "000000", now, 0); ok {
+		t.Error("wrong code accepted")
+	}
+	if _, ok := ValidateTotp("not base32!", "050471", now, 0); ok {
+		t.Error("code of invalid secret accepted")
+	}
+
+	// round trip with a new secret
+	secret, err := NewTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	if _, ok := ValidateTotp(secret, totpCode(key, time.Now().Unix()/totpPeriod, totpDigits), time.Now(), 0); !ok {
+		t.Error("code of new secret rejected")
+	}
+}
+
+func TestTotpURI(t *testing.T) {
+	uri, err := url.Parse(TotpURI("JBSWY3DPEHPK3PXP", "user@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("invalid uri %s", uri)
+	}
+	if uri.Query().Get("secret") != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("secret missing in %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("%d codes and %d hashes, want 10", len(codes), len(hashes))
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicated code %s", code)
+		}
+		seen[code] = true
+		if HashRecoveryCode(code) != hashes[i] {
+			t.Errorf("hash of %s mismatched", code)
+		}
+	}
+	// case, spaces and dashes are ignored
+	if HashRecoveryCode("ABCD EFGH") != HashRecoveryCode("abcd-efgh") {
+		t.Error("recovery code normalization failed")
+	}
+}
@@ -47,6 +47,7 @@ func SetVerificationCode(info, scope string) (string, error) {
 		return "", err
 	}
 	code := fmt.Sprintf("%06d", codeInt.Uint64())
+	resetVerificationFailures(info, scope)
 
 	return code, verificationCodeCache.Set(
 		context.Background(),
@@ -55,13 +56,22 @@ func SetVerificationCode(info, scope string) (string, error) {
 	)
 }
 
-// CheckVerificationCode 检查验证码
+// CheckVerificationCode 检查验证码，错误次数过多则验证码失效
 func CheckVerificationCode(info, scope, code string) bool {
 	storedCode, err := verificationCodeCache.Get(
 		context.Background(),
 		fmt.Sprintf("%v-%v", scope, info),
 	)
-	return err == nil && storedCode == code
+	if err != nil {
+		return false
+	}
+	if storedCode != code {
+		if verificationFailed(info, scope) {
+			_ = DeleteVerificationCode(info, scope)
+		}
+		return false
+	}
+	return true
 }
 
 func DeleteVerificationCode(info, scope string) error {
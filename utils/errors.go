@@ -2,8 +2,12 @@ package utils
 
 import (
 	"errors"
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+
+	"MOSS_backend/utils/i18n"
 )
 
 type MessageResponse struct {
@@ -16,6 +20,9 @@ type HttpError struct {
 	Message     string       `json:"message,omitempty"`
 	MessageType MessageType  `json:"message_type,omitempty"`
 	Detail      *ErrorDetail `json:"detail,omitempty"`
+	RetryAfter  int          `json:"retry_after,omitempty"` // seconds, also set in header Retry-After
+	MessageID   string       `json:"-"`                     // translated into the locale of the request if set
+	MessageArgs []any        `json:"-"`
 }
 
 func (e *HttpError) Error() string {
@@ -27,11 +34,57 @@ func (e *HttpError) WithMessageType(messageType MessageType) *HttpError {
 	return e
 }
 
+func (e *HttpError) WithRetryAfter(seconds int) *HttpError {
+	e.RetryAfter = seconds
+	return e
+}
+
+// WithMessageID sets the message to be translated, Message is the fallback translation
+func (e *HttpError) WithMessageID(id string, args ...any) *HttpError {
+	e.MessageID = id
+	e.MessageArgs = args
+	e.Message = i18n.T(i18n.Fallback, id, args...)
+	return e
+}
+
+// Localize returns a copy of e with the message in the locale
+func (e *HttpError) Localize(locale string) *HttpError {
+	copied := *e
+	if copied.MessageID != "" {
+		copied.Message = i18n.T(locale, copied.MessageID, copied.MessageArgs...)
+	}
+	return &copied
+}
+
+// LocalizeError returns the message of err in the locale, for errors not handled by MyErrorHandler
+func LocalizeError(err error, locale string) string {
+	var httpError *HttpError
+	if errors.As(err, &httpError) {
+		return httpError.Localize(locale).Message
+	}
+	return err.Error()
+}
+
+// RetryAfter returns a copy of err with retry after, errors in collections are shared
+func RetryAfter(err error, seconds int) error {
+	var httpError *HttpError
+	if !errors.As(err, &httpError) {
+		return err
+	}
+	copied := *httpError
+	return copied.WithRetryAfter(seconds)
+}
+
 type MessageType = string
 
 const (
-	MaxLength MessageType = "max_length"
-	Sensitive             = "sensitive"
+	MaxLength      MessageType = "max_length"
+	Sensitive                  = "sensitive"
+	RateLimit                  = "rate_limit"
+	Quota                      = "quota"
+	Captcha                    = "captcha"
+	Totp                       = "totp"
+	PasswordPolicy             = "password_policy"
 )
 
 func NoStatus(message string) *HttpError {
@@ -41,59 +94,40 @@ func NoStatus(message string) *HttpError {
 	}
 }
 
-func BadRequest(messages ...string) *HttpError {
-	message := "Bad Request"
+// newHttpError uses the message if given, otherwise the default message of id
+func newHttpError(code int, id string, messages []string) *HttpError {
 	if len(messages) > 0 {
-		message = messages[0]
-	}
-	return &HttpError{
-		Code:    400,
-		Message: message,
+		return &HttpError{Code: code, Message: messages[0]}
 	}
+	return (&HttpError{Code: code}).WithMessageID(id)
+}
+
+func BadRequest(messages ...string) *HttpError {
+	return newHttpError(400, "bad_request", messages)
 }
 
 func Unauthorized(messages ...string) *HttpError {
-	message := "Invalid JWT Token"
-	if len(messages) > 0 {
-		message = messages[0]
-	}
-	return &HttpError{
-		Code:    401,
-		Message: message,
-	}
+	return newHttpError(401, "unauthorized", messages)
 }
 
 func Forbidden(messages ...string) *HttpError {
-	message := "您没有权限进行此操作"
-	if len(messages) > 0 {
-		message = messages[0]
-	}
-	return &HttpError{
-		Code:    403,
-		Message: message,
-	}
+	return newHttpError(403, "forbidden", messages)
 }
 
 func NotFound(messages ...string) *HttpError {
-	message := "Not Found"
-	if len(messages) > 0 {
-		message = messages[0]
-	}
-	return &HttpError{
-		Code:    404,
-		Message: message,
-	}
+	return newHttpError(404, "not_found", messages)
+}
+
+func TooManyRequests(messages ...string) *HttpError {
+	return newHttpError(429, "too_many_requests", messages)
+}
+
+func ServiceUnavailable(messages ...string) *HttpError {
+	return newHttpError(503, "service_unavailable", messages)
 }
 
 func InternalServerError(messages ...string) *HttpError {
-	message := "Unknown Error"
-	if len(messages) > 0 {
-		message = messages[0]
-	}
-	return &HttpError{
-		Code:    500,
-		Message: message,
-	}
+	return newHttpError(500, "internal_server_error", messages)
 }
 
 func MyErrorHandler(ctx *fiber.Ctx, err error) error {
@@ -126,6 +160,14 @@ func MyErrorHandler(ctx *fiber.Ctx, err error) error {
 		}
 	}
 
+	if httpError.MessageID != "" {
+		httpError = *httpError.Localize(GetLocale(ctx))
+	}
+
+	if httpError.RetryAfter > 0 {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(httpError.RetryAfter))
+	}
+
 	return ctx.Status(httpError.Code).JSON(&httpError)
 }
 
@@ -144,73 +186,79 @@ type ErrCollection struct {
 	ErrPhoneCannotReset        error
 	ErrPasswordIncorrect       error
 	ErrEmailInBlacklist        error
+	ErrLoginTooFrequent        error
+	ErrAccountLocked           error
+	ErrVerificationTooFrequent error
+	ErrVerificationDailyLimit  error
+	ErrCaptchaRequired         error
+	ErrTotpRequired            error
+	ErrTotpInvalid             error
+	ErrPasswordWeak            error
 }
 
-var ErrCollectionCN = ErrCollection{
-	ErrVerificationCodeInvalid: BadRequest("验证码错误"),
-	ErrNeedInviteCode:          BadRequest("需要邀请码"),
-	ErrInviteCodeInvalid:       BadRequest("邀请码错误"),
-	ErrRegistered:              BadRequest("您已注册，如果忘记密码，请使用忘记密码功能找回"),
-	ErrEmailRegistered:         BadRequest("该邮箱已被注册"),
-	ErrEmailNotRegistered:      BadRequest("该邮箱未注册"),
-	ErrEmailCannotModify:       BadRequest("未登录状态，禁止修改邮箱"),
-	ErrEmailCannotReset:        BadRequest("登录状态无法重置密码，请退出登录然后重试"),
-	ErrPhoneRegistered:         BadRequest("该手机号已被注册"),
-	ErrPhoneNotRegistered:      BadRequest("该手机号未注册"),
-	ErrPhoneCannotModify:       BadRequest("未登录状态，禁止修改手机号"),
-	ErrPhoneCannotReset:        BadRequest("登录状态无法重置密码，请退出登录然后重试"),
-	ErrPasswordIncorrect:       Unauthorized("密码错误"),
-	ErrEmailInBlacklist:        BadRequest("该邮箱已被禁用"),
-}
-
-var ErrCollectionGlobal = ErrCollection{
-	ErrVerificationCodeInvalid: BadRequest("invalid verification code"),
-	ErrNeedInviteCode:          BadRequest("invitation code needed"),
-	ErrInviteCodeInvalid:       BadRequest("invalid invitation code"),
-	ErrRegistered:              BadRequest("You have registered, if you forget your password, please use reset password function to retrieve"),
-	ErrEmailRegistered:         BadRequest("email address registered"),
-	ErrEmailNotRegistered:      BadRequest("email address not registered"),
-	ErrEmailCannotModify:       BadRequest("cannot modify email address when not login"),
-	ErrEmailCannotReset:        BadRequest("cannot reset password when login, please logout and retry"),
-	ErrPhoneRegistered:         BadRequest("phone number registered"),
-	ErrPhoneNotRegistered:      BadRequest("phone number not registered"),
-	ErrPhoneCannotModify:       BadRequest("cannot modify phone number when not login"),
-	ErrPhoneCannotReset:        BadRequest("cannot reset password when login, please logout and retry"),
-	ErrPasswordIncorrect:       Unauthorized("password incorrect"),
-	ErrEmailInBlacklist:        BadRequest("banned email domain"),
+// errCollection is shared by all locales, messages are translated by MyErrorHandler
+var errCollection = ErrCollection{
+	ErrVerificationCodeInvalid: BadRequest().WithMessageID("verification_code_invalid"),
+	ErrNeedInviteCode:          BadRequest().WithMessageID("need_invite_code"),
+	ErrInviteCodeInvalid:       BadRequest().WithMessageID("invite_code_invalid"),
+	ErrRegistered:              BadRequest().WithMessageID("registered"),
+	ErrEmailRegistered:         BadRequest().WithMessageID("email_registered"),
+	ErrEmailNotRegistered:      BadRequest().WithMessageID("email_not_registered"),
+	ErrEmailCannotModify:       BadRequest().WithMessageID("email_cannot_modify"),
+	ErrEmailCannotReset:        BadRequest().WithMessageID("email_cannot_reset"),
+	ErrPhoneRegistered:         BadRequest().WithMessageID("phone_registered"),
+	ErrPhoneNotRegistered:      BadRequest().WithMessageID("phone_not_registered"),
+	ErrPhoneCannotModify:       BadRequest().WithMessageID("phone_cannot_modify"),
+	ErrPhoneCannotReset:        BadRequest().WithMessageID("phone_cannot_reset"),
+	ErrPasswordIncorrect:       Unauthorized().WithMessageID("password_incorrect"),
+	ErrEmailInBlacklist:        BadRequest().WithMessageID("email_in_blacklist"),
+	ErrLoginTooFrequent:        TooManyRequests().WithMessageID("login_too_frequent"),
+	ErrAccountLocked:           TooManyRequests().WithMessageID("account_locked"),
+	ErrVerificationTooFrequent: TooManyRequests().WithMessageID("verification_too_frequent"),
+	ErrVerificationDailyLimit:  TooManyRequests().WithMessageID("verification_daily_limit"),
+	ErrCaptchaRequired:         BadRequest().WithMessageID("captcha_required").WithMessageType(Captcha),
+	ErrTotpRequired:            Unauthorized().WithMessageID("totp_required").WithMessageType(Totp),
+	ErrTotpInvalid:             Unauthorized().WithMessageID("totp_invalid").WithMessageType(Totp),
+	ErrPasswordWeak:            BadRequest().WithMessageID("password_weak").WithMessageType(PasswordPolicy),
 }
 
 type MessageCollection struct {
 	MessageLoginSuccess          string
 	MessageRegisterSuccess       string
 	MessageLogoutSuccess         string
+	MessageRefreshSuccess        string
 	MessageResetPasswordSuccess  string
+	MessageChangePasswordSuccess string
 	MessageVerificationEmailSend string
 	MessageVerificationPhoneSend string
 }
 
-var MessageCollectionCN = MessageCollection{
-	MessageLoginSuccess:          "登录成功",
-	MessageRegisterSuccess:       "注册成功",
-	MessageLogoutSuccess:         "登出成功",
-	MessageResetPasswordSuccess:  "重置密码成功",
-	MessageVerificationEmailSend: "验证邮件已发送，请查收\n如未收到，请检查邮件地址是否正确，检查垃圾箱，或重试",
-	MessageVerificationPhoneSend: "验证短信已发送，请查收\n如未收到，请检查手机号是否正确，检查垃圾箱，或重试",
+func newMessageCollection(locale string) *MessageCollection {
+	return &MessageCollection{
+		MessageLoginSuccess:          i18n.T(locale, "login_success"),
+		MessageRegisterSuccess:       i18n.T(locale, "register_success"),
+		MessageLogoutSuccess:         i18n.T(locale, "logout_success"),
+		MessageRefreshSuccess:        i18n.T(locale, "refresh_success"),
+		MessageResetPasswordSuccess:  i18n.T(locale, "reset_password_success"),
+		MessageChangePasswordSuccess: i18n.T(locale, "change_password_success"),
+		MessageVerificationEmailSend: i18n.T(locale, "verification_email_sent"),
+		MessageVerificationPhoneSend: i18n.T(locale, "verification_phone_sent"),
+	}
 }
 
-var MessageCollectionGlobal = MessageCollection{
-	MessageLoginSuccess:          "Login successful",
-	MessageRegisterSuccess:       "register successful",
-	MessageLogoutSuccess:         "logout successful",
-	MessageResetPasswordSuccess:  "reset password successful",
-	MessageVerificationEmailSend: "The verification email has been sent, please check\nIf not, please check if the email address is correct, check the spam box, or try again",
-	MessageVerificationPhoneSend: "The verification message has been sent, please check\nIf not, please check if the phone number is correct, check the spam box, or try again",
+var messageCollections = make(map[string]*MessageCollection)
+
+func init() {
+	for _, locale := range i18n.Locales {
+		messageCollections[locale] = newMessageCollection(locale)
+	}
 }
 
-func GetInfoByIP(ip string) (*ErrCollection, *MessageCollection) {
-	if ok, _ := IsInChina(ip); ok {
-		return &ErrCollectionCN, &MessageCollectionCN
-	} else {
-		return &ErrCollectionGlobal, &MessageCollectionGlobal
+// GetInfo returns the errors and the messages in the locale of the request
+func GetInfo(c *fiber.Ctx) (*ErrCollection, *MessageCollection) {
+	messageCollection, ok := messageCollections[GetLocale(c)]
+	if !ok {
+		messageCollection = messageCollections[i18n.Fallback]
 	}
+	return &errCollection, messageCollection
 }
@@ -0,0 +1,102 @@
+package i18n
+
+// catalog of messages, message id => locale => message. Messages may have fmt verbs for args.
+var catalog = map[string]map[string]string{
+	// general errors
+	"bad_request":           {En: "Bad Request", Zh: "请求错误"},
+	"unauthorized":          {En: "Invalid JWT Token", Zh: "登录状态无效，请重新登录"},
+	"forbidden":             {En: "You don't have permission to do this", Zh: "您没有权限进行此操作"},
+	"not_found":             {En: "Not Found", Zh: "资源不存在"},
+	"too_many_requests":     {En: "Too Many Requests", Zh: "请求过于频繁"},
+	"service_unavailable":   {En: "Service Unavailable", Zh: "服务暂时不可用"},
+	"internal_server_error": {En: "Unknown Error", Zh: "未知错误"},
+
+	// tokens and sessions
+	"invalid_token":        {En: "invalid token", Zh: "登录凭证无效，请重新登录"},
+	"token_revoked":        {En: "token revoked", Zh: "登录凭证已失效，请重新登录"},
+	"session_revoked":      {En: "session revoked", Zh: "登录已失效，请重新登录"},
+	"session_not_found":    {En: "session not found", Zh: "登录会话不存在"},
+	"refresh_token_reused": {En: "refresh token reused", Zh: "登录凭证已被使用，为了您的安全，请重新登录"},
+
+	// account
+	"verification_code_invalid": {En: "invalid verification code", Zh: "验证码错误"},
+	"need_invite_code":          {En: "invitation code needed", Zh: "需要邀请码"},
+	"invite_code_invalid":       {En: "invalid invitation code", Zh: "邀请码错误"},
+	"registered":                {En: "You have registered, if you forget your password, please use reset password function to retrieve", Zh: "您已注册，如果忘记密码，请使用忘记密码功能找回"},
+	"email_registered":          {En: "email address registered", Zh: "该邮箱已被注册"},
+	"email_not_registered":      {En: "email address not registered", Zh: "该邮箱未注册"},
+	"email_cannot_modify":       {En: "cannot modify email address when not login", Zh: "未登录状态，禁止修改邮箱"},
+	"email_cannot_reset":        {En: "cannot reset password when login, please logout and retry", Zh: "登录状态无法重置密码，请退出登录然后重试"},
+	"phone_registered":          {En: "phone number registered", Zh: "该手机号已被注册"},
+	"phone_not_registered":      {En: "phone number not registered", Zh: "该手机号未注册"},
+	"phone_cannot_modify":       {En: "cannot modify phone number when not login", Zh: "未登录状态，禁止修改手机号"},
+	"phone_cannot_reset":        {En: "cannot reset password when login, please logout and retry", Zh: "登录状态无法重置密码，请退出登录然后重试"},
+	"phone_invalid":             {En: "invalid phone number", Zh: "手机号格式错误"},
+	"password_incorrect":        {En: "password incorrect", Zh: "密码错误"},
+	"password_weak":             {En: "password too weak, please use a longer password mixing upper and lower case letters, digits and symbols", Zh: "密码强度不足，请使用更长的密码，并混合大小写字母、数字和符号"},
+	"email_in_blacklist":        {En: "banned email domain", Zh: "该邮箱已被禁用"},
+	"user_not_found":            {En: "User Not Found", Zh: "用户不存在"},
+	"login_too_frequent":        {En: "too many failed login attempts, please retry later", Zh: "登录失败次数过多，请稍后再试"},
+	"account_locked":            {En: "too many failed login attempts, the account is locked temporarily, please retry later", Zh: "登录失败次数过多，账号已被暂时锁定，请稍后再试"},
+	"verification_too_frequent": {En: "verification codes are requested too frequently, please retry later", Zh: "验证码发送过于频繁，请稍后再试"},
+	"verification_daily_limit":  {En: "verification codes reach the daily limit, please retry tomorrow", Zh: "今日验证码发送次数已达上限，请明天再试"},
+	"captcha_required":          {En: "captcha required", Zh: "请完成人机验证"},
+	"plugin_config_failed":      {En: "Failed to change plugin config, please try again later", Zh: "修改插件配置失败，请稍后再试"},
+
+	// two-factor authentication
+	"totp_required":        {En: "two-factor authentication code required", Zh: "请输入两步验证码"},
+	"totp_invalid":         {En: "invalid two-factor authentication code", Zh: "两步验证码错误"},
+	"totp_already_enabled": {En: "two-factor authentication is already enabled", Zh: "两步验证已开启"},
+	"totp_not_enrolled":    {En: "please enroll two-factor authentication first", Zh: "请先设置两步验证"},
+	"totp_not_enabled":     {En: "two-factor authentication is not enabled", Zh: "两步验证未开启"},
+	"admin_totp_required":  {En: "two-factor authentication is required for admins, please enable it", Zh: "管理员必须开启两步验证"},
+
+	// single sign-on
+	"sso_provider_not_found": {En: "sso provider not found", Zh: "单点登录服务不存在"},
+	"sso_login_failed":       {En: "sso login failed", Zh: "单点登录失败"},
+	"sso_login_denied":       {En: "sso login failed: %s", Zh: "单点登录失败：%s"},
+	"sso_code_required":      {En: "code and state required", Zh: "缺少授权码"},
+	"sso_state_invalid":      {En: "invalid or expired state, please login again", Zh: "登录已过期，请重新登录"},
+	"sso_email_unverified":   {En: "verified email required for sso login", Zh: "单点登录需要已验证的邮箱"},
+	"sso_account_deleted":    {En: "the account of the email is deleted, please register again", Zh: "该邮箱的账号已注销，请重新注册"},
+
+	// success messages
+	"login_success":           {En: "Login successful", Zh: "登录成功"},
+	"register_success":        {En: "register successful", Zh: "注册成功"},
+	"logout_success":          {En: "logout successful", Zh: "登出成功"},
+	"refresh_success":         {En: "refresh successful", Zh: "刷新成功"},
+	"reset_password_success":  {En: "reset password successful", Zh: "重置密码成功"},
+	"change_password_success": {En: "change password successful", Zh: "修改密码成功"},
+	"verification_email_sent": {En: "The verification email has been sent, please check\nIf not, please check if the email address is correct, check the spam box, or try again", Zh: "验证邮件已发送，请查收\n如未收到，请检查邮件地址是否正确，检查垃圾箱，或重试"},
+	"verification_phone_sent": {En: "The verification message has been sent, please check\nIf not, please check if the phone number is correct, check the spam box, or try again", Zh: "验证短信已发送，请查收\n如未收到，请检查手机号是否正确，检查垃圾箱，或重试"},
+
+	// chats and records
+	"invalid_chat_id":            {En: "invalid chat_id", Zh: "对话不存在"},
+	"request_empty":              {En: "request is empty", Zh: "内容不能为空"},
+	"user_requesting":            {En: "User requesting, please wait and try again", Zh: "上一次请求还未结束，请稍后再试"},
+	"max_input_exceeded":         {En: "Input no more than 2048 characters", Zh: "单次输入限长为 2048 字符"},
+	"max_input_exceeded_infer":   {En: "Input max length exceeded, please reduce length and try again", Zh: "单次输入超长，请减少字数并重试"},
+	"infer_unavailable":          {En: "Inference service temporarily unavailable, please try again later", Zh: "推理服务暂时不可用，请稍后再试"},
+	"infer_timeout":              {En: "Inference timed out, please try again later", Zh: "推理超时，请稍后再试"},
+	"infer_error":                {En: "Inference server error, please try again later", Zh: "推理服务出错，请稍后再试"},
+	"infer_connection_closed":    {En: "Inference connection closed, please try again later", Zh: "推理服务连接已断开，请稍后再试"},
+	"unknown_error":              {En: "Unknown error, please refresh or wait a minute and try again", Zh: "未知错误，请刷新或等待一分钟后再试"},
+	"rate_limited":               {En: "Too many requests, please try again later", Zh: "请求过于频繁，请稍后再试"},
+	"quota_exceeded":             {En: "Quota exceeded, please try again after it resets", Zh: "已达到使用额度上限，请在额度重置后再试"},
+	"queue_full":                 {En: "Too many requests in queue, please try again later", Zh: "当前排队人数过多，请稍后再试"},
+	"queue_timeout":              {En: "Queue wait timed out, please try again later", Zh: "排队等待超时，请稍后再试"},
+	"user_banned":                {En: "Your account is locked for repeated violations. If you disagree, please email txsun19@fudan.edu.cn", Zh: "您因为多次违规，账号被锁定，如有意见请发送邮件至 txsun19@fudan.edu.cn"},
+	"sensitive_request":          {En: "Sorry, I have nothing to say. Try another topic. I will block your account if we continue this topic :)", Zh: "抱歉，我无法回答这个问题，请换个话题。如果继续这个话题，您的账号可能会被封禁"},
+	"chat_not_deleted":           {En: "chat is not deleted", Zh: "对话不在回收站中"},
+	"record_not_deleted":         {En: "record is not deleted", Zh: "记录不在回收站中"},
+	"folder_not_found":           {En: "folder not found", Zh: "文件夹不存在"},
+	"nothing_to_modify":          {En: "nothing to modify", Zh: "没有需要修改的内容"},
+	"announcement_undismissible": {En: "this announcement cannot be dismissed", Zh: "该公告不能关闭"},
+	"export_interrupted":         {En: "Export interrupted, the file is incomplete, please try again", Zh: "导出中断，文件不完整，请重试"},
+
+	// config
+	"config_load_failed":        {En: "Failed to load config", Zh: "加载配置失败"},
+	"config_update_failed":      {En: "Failed to update config", Zh: "更新配置失败"},
+	"config_version_not_found":  {En: "config version not found", Zh: "配置版本不存在"},
+	"config_snapshot_not_found": {En: "no snapshot of this version", Zh: "该版本没有快照"},
+}
@@ -0,0 +1,84 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Messages shown to users are looked up in the catalog by message ids.
+// The locale of a request is chosen from Accept-Language, then the preference of the user,
+// then the ip, see utils.GetLocale.
+
+const (
+	Zh = "zh"
+	En = "en"
+)
+
+// Fallback is used for messages missing in a locale, and for unsupported locales
+const Fallback = En
+
+var Locales = []string{Zh, En}
+
+// Supported tells whether messages are translated into the locale
+func Supported(locale string) bool {
+	for _, supported := range Locales {
+		if locale == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// T returns the message of id in the locale, formatted with args if any
+func T(locale, id string, args ...any) string {
+	translations, ok := catalog[id]
+	if !ok {
+		return id
+	}
+	message, ok := translations[locale]
+	if !ok {
+		message = translations[Fallback]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Match returns the supported locale preferred in the Accept-Language header, zh-CN matches zh
+func Match(acceptLanguage string) (string, bool) {
+	type weighted struct {
+		language string
+		q        float64
+	}
+	var languages []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if language == "" || language == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			languages = append(languages, weighted{language, q})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+	for _, language := range languages {
+		if Supported(language.language) {
+			return language.language, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,59 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	for id, translations := range catalog {
+		for _, locale := range Locales {
+			message, ok := translations[locale]
+			if !ok || message == "" {
+				t.Errorf("%s: missing %s", id, locale)
+				continue
+			}
+			if strings.Count(message, "%") != strings.Count(translations[Fallback], "%") {
+				t.Errorf("%s: args of %s differ from %s", id, locale, Fallback)
+			}
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if message := T(Zh, "forbidden"); message != "您没有权限进行此操作" {
+		t.Errorf("zh forbidden: %s", message)
+	}
+	if message := T("fr", "forbidden"); message != catalog["forbidden"][En] {
+		t.Errorf("unsupported locale falls back to en: %s", message)
+	}
+	if message := T(En, "sso_login_denied", "access_denied"); message != "sso login failed: access_denied" {
+		t.Errorf("formatted message: %s", message)
+	}
+	if message := T(En, "no_such_message"); message != "no_such_message" {
+		t.Errorf("unknown id: %s", message)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		acceptLanguage string
+		locale         string
+		ok             bool
+	}{
+		{"zh-CN,zh;q=0.9,en;q=0.8", Zh, true},
+		{"en-US,en;q=0.9", En, true},
+		{"fr-FR,fr;q=0.9,en;q=0.5,zh;q=0.7", Zh, true},
+		{"de, EN-gb;q=0.3", En, true},
+		{"zh;q=0,en;q=0.1", En, true},
+		{"fr, de", "", false},
+		{"*", "", false},
+		{"", "", false},
+		{"en;q=abc", "", false},
+	} {
+		locale, ok := Match(test.acceptLanguage)
+		if locale != test.locale || ok != test.ok {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", test.acceptLanguage, locale, ok, test.locale, test.ok)
+		}
+	}
+}
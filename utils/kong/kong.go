@@ -148,30 +148,31 @@ func GetJwtCredential(userID int) (*JwtCredential, error) {
 	}
 }
 
-func DeleteJwtCredential(userID int) error {
-	deleteAJwtCredential := func(jwtID string) error {
-		statusCode, _, err := kongRequestDo(
-			http.MethodDelete,
-			fmt.Sprintf("/consumers/%d/jwt/%v", userID, jwtID),
-			nil,
-			"",
-		)
-		if err != nil {
-			return err
-		}
-		if statusCode != 204 {
-			return fmt.Errorf("delete user %v jwt credential %v error", userID, jwtID)
-		}
-		return nil
+// DeleteJwtCredentialByID deletes a jwt credential of the user, tokens signed by it are rejected
+func DeleteJwtCredentialByID(userID int, jwtID string) error {
+	statusCode, _, err := kongRequestDo(
+		http.MethodDelete,
+		fmt.Sprintf("/consumers/%d/jwt/%v", userID, jwtID),
+		nil,
+		"",
+	)
+	if err != nil {
+		return err
+	}
+	if !(statusCode == 204 || statusCode == 404) {
+		return fmt.Errorf("delete user %v jwt credential %v error", userID, jwtID)
 	}
+	return nil
+}
 
+func DeleteJwtCredential(userID int) error {
 	var err error
 	jwtCredentials, err := ListJwtCredentials(userID)
 	if err != nil {
 		return err
 	}
 	for i := range jwtCredentials {
-		innerErr := deleteAJwtCredential(jwtCredentials[i].ID)
+		innerErr := DeleteJwtCredentialByID(userID, jwtCredentials[i].ID)
 		if innerErr != nil {
 			if err == nil {
 				err = innerErr
@@ -182,3 +183,20 @@ func DeleteJwtCredential(userID int) error {
 	}
 	return err
 }
+
+// DeleteUser deletes the consumer of the user with all its credentials
+func DeleteUser(userID int) error {
+	statusCode, body, err := kongRequestDo(
+		http.MethodDelete,
+		fmt.Sprintf("/consumers/%d", userID),
+		nil,
+		"",
+	)
+	if err != nil {
+		return err
+	}
+	if !(statusCode == 204 || statusCode == 404) {
+		return fmt.Errorf("delete user %v in kong error: %v", userID, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"MOSS_backend/utils/i18n"
+)
+
+// GetLocale returns the locale of the request set by the locale middleware,
+// or matches Accept-Language and then the ip if not set
+func GetLocale(c *fiber.Ctx) string {
+	if locale, ok := c.Locals("locale").(string); ok && locale != "" {
+		return locale
+	}
+	if locale, ok := i18n.Match(c.Get(fiber.HeaderAcceptLanguage)); ok {
+		return locale
+	}
+	return GetLocaleByIP(GetRealIP(c))
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLocalizeErrors(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: MyErrorHandler})
+	app.Get("/forbidden", func(c *fiber.Ctx) error {
+		return Forbidden()
+	})
+	app.Get("/locked", func(c *fiber.Ctx) error {
+		errCollection, _ := GetInfo(c)
+		return RetryAfter(errCollection.ErrAccountLocked, 60)
+	})
+	app.Get("/plain", func(c *fiber.Ctx) error {
+		return BadRequest("plain message")
+	})
+	app.Get("/locals", func(c *fiber.Ctx) error {
+		c.Locals("locale", "zh")
+		return NotFound()
+	})
+
+	for _, test := range []struct {
+		path           string
+		acceptLanguage string
+		message        string
+	}{
+		{"/forbidden", "zh-CN,zh;q=0.9", "您没有权限进行此操作"},
+		{"/forbidden", "en-US", "You don't have permission to do this"},
+		{"/locked", "zh", "登录失败次数过多，账号已被暂时锁定，请稍后再试"},
+		{"/locked", "en", "too many failed login attempts, the account is locked temporarily, please retry later"},
+		{"/plain", "zh", "plain message"},
+		{"/locals", "en", "资源不存在"},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set(fiber.HeaderAcceptLanguage, test.acceptLanguage)
+		rsp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body HttpError
+		err = json.NewDecoder(rsp.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.Message != test.message {
+			t.Errorf("%s in %s: %q, want %q", test.path, test.acceptLanguage, body.Message, test.message)
+		}
+	}
+
+	// shared errors are not changed by localizing
+	if errCollection.ErrAccountLocked.Error() != "too many failed login attempts, the account is locked temporarily, please retry later" {
+		t.Error("shared error changed")
+	}
+}
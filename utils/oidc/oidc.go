@@ -0,0 +1,283 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"MOSS_backend/config"
+)
+
+// OpenID Connect authorization code flow with PKCE.
+// Providers are discovered from their issuers on first use.
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// keysReloadInterval limits reloading keys of providers for unknown key ids
+const keysReloadInterval = time.Minute
+
+var ErrProviderNotFound = errors.New("oidc provider not found")
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	config.OidcProvider
+	discovery discovery
+
+	sync.RWMutex
+	keys         map[string]any // kid => public key
+	keysLoadedAt time.Time
+}
+
+// Claims of the id token used to identify users
+type Claims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified Bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// VerifiedEmail returns the lower-cased email if the provider has verified it
+func (claims *Claims) VerifiedEmail() (string, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return "", false
+	}
+	return strings.ToLower(claims.Email), true
+}
+
+// Bool accepts json booleans and the strings "true" and "false", which some providers send instead
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var value any
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case bool:
+		*b = Bool(value)
+	case string:
+		switch strings.ToLower(value) {
+		case "true":
+			*b = true
+		case "false", "":
+			*b = false
+		default:
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+	case nil:
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %v", value)
+	}
+	return nil
+}
+
+var providers = struct {
+	sync.Mutex
+	byName map[string]*Provider
+}{byName: make(map[string]*Provider)}
+
+// GetProvider returns the configured provider, discovering it on first use
+func GetProvider(name string) (*Provider, error) {
+	providers.Lock()
+	defer providers.Unlock()
+	if provider, ok := providers.byName[name]; ok {
+		return provider, nil
+	}
+
+	for _, providerConfig := range config.Config.OidcProviders {
+		if providerConfig.Name != name {
+			continue
+		}
+		provider, err := NewProvider(providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		providers.byName[name] = provider
+		return provider, nil
+	}
+	return nil, ErrProviderNotFound
+}
+
+// NewProvider discovers the endpoints of the provider from its issuer
+func NewProvider(providerConfig config.OidcProvider) (*Provider, error) {
+	provider := &Provider{OidcProvider: providerConfig}
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	wellKnown := strings.TrimSuffix(provider.Issuer, "/") + "/.well-known/openid-configuration"
+	err := getJSON(wellKnown, &provider.discovery)
+	if err != nil {
+		return nil, fmt.Errorf("discover oidc provider %s error: %w", provider.Name, err)
+	}
+	if provider.discovery.Issuer != provider.Issuer {
+		return nil, fmt.Errorf("oidc provider %s issuer mismatch: %s", provider.Name, provider.discovery.Issuer)
+	}
+	return provider, nil
+}
+
+func getJSON(url string, value any) error {
+	rsp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: status %d", url, rsp.StatusCode)
+	}
+	return json.NewDecoder(rsp.Body).Decode(value)
+}
+
+// RandomString generates a url safe random string for state, nonce and code verifier
+func RandomString() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// AuthCodeURL is the authorization url to redirect users to, the code challenge is derived from codeVerifier
+func (provider *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {provider.RedirectUrl},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(provider.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.discovery.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange redeems the authorization code for the id token, and verifies it
+func (provider *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.RedirectUrl},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+
+	rsp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange error: status %d: %s", rsp.StatusCode, body)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.Unmarshal(body, &tokenResponse)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("oidc token response without id token")
+	}
+	return provider.VerifyIDToken(tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of the id token
+func (provider *Provider) VerifyIDToken(idToken, nonce string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return provider.key(kid)
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Issuer != provider.Issuer {
+		return nil, errors.New("invalid id token: issuer mismatch")
+	}
+	if !claims.VerifyAudience(provider.ClientID, true) {
+		return nil, errors.New("invalid id token: audience mismatch")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("invalid id token: exp required")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id token: sub required")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+	return &claims, nil
+}
+
+// key returns the public key of kid, keys are reloaded for unknown kid in case of rotation
+func (provider *Provider) key(kid string) (any, error) {
+	provider.RLock()
+	key, ok := provider.keys[kid]
+	provider.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	provider.Lock()
+	defer provider.Unlock()
+	if key, ok = provider.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(provider.keysLoadedAt) < keysReloadInterval {
+		return nil, errors.New("oidc key not found")
+	}
+
+	var set jwks
+	err := getJSON(provider.discovery.JwksUri, &set)
+	if err != nil {
+		return nil, err
+	}
+	provider.keys = set.publicKeys()
+	provider.keysLoadedAt = time.Now()
+
+	if key, ok = provider.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("oidc key not found")
+}
@@ -0,0 +1,336 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"MOSS_backend/config"
+)
+
+const (
+	testClientID     = "moss"
+	testClientSecret = "secret"
+	testRedirectUrl  = "http://localhost/api/oidc/mock/callback"
+)
+
+// mockProvider is a minimal oidc provider issuing id tokens for authorization codes
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	sync.Mutex
+	codes map[string]mockAuthorization // code => authorization
+}
+
+type mockAuthorization struct {
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockProvider{key: key, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discovery{
+			Issuer:                mock.URL,
+			AuthorizationEndpoint: mock.URL + "/authorize",
+			TokenEndpoint:         mock.URL + "/token",
+			JwksUri:               mock.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks{Keys: []jwk{{
+			Kid: "1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	// authorize redirects back with a code at once, as if the user has logged in
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, _ := RandomString()
+		mock.Lock()
+		mock.codes[code] = mockAuthorization{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+		mock.Unlock()
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != testClientID || clientSecret != testClientSecret || r.PostFormValue("redirect_uri") != testRedirectUrl {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mock.Lock()
+		authorization, ok := mock.codes[r.PostFormValue("code")]
+		delete(mock.codes, r.PostFormValue("code"))
+		mock.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": mock.idToken(t, authorization.nonce)})
+	})
+	mock.Server = httptest.NewServer(mux)
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+func (mock *mockProvider) idToken(t *testing.T, nonce string) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            mock.URL,
+		"sub":            "alice",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "alice@fudan.edu.cn",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+	return mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims)
+}
+
+func (mock *mockProvider) sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (mock *mockProvider) provider(t *testing.T) *Provider {
+	provider, err := NewProvider(config.OidcProvider{
+		Name:         "mock",
+		Issuer:       mock.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectUrl:  testRedirectUrl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// authorize follows the authorization url, and returns the code and state in the callback
+func (mock *mockProvider) authorize(t *testing.T, authURL string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rsp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rsp.Body.Close()
+	if rsp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status %d", rsp.StatusCode)
+	}
+	callback, err := url.Parse(rsp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestLoginFlow(t *testing.T) {
+	mock := newMockProvider(t)
+	provider := mock.provider(t)
+
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	codeVerifier, _ := RandomString()
+	code, returnedState := mock.authorize(t, provider.AuthCodeURL(state, nonce, codeVerifier))
+	if returnedState != state {
+		t.Fatalf("state %s, want %s", returnedState, state)
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, codeVerifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.Email != "alice@fudan.edu.cn" || !claims.EmailVerified || claims.Name != "Alice" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// codes can be redeemed only once
+	if _, err = provider.Exchange(context.Background(), code, codeVerifier, nonce); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+
+	// wrong code verifier
+	code, _ = mock.authorize(t, provider.AuthCodeURL(state, nonce, codeVerifier))
+	if _, err = provider.Exchange(context.Background(), code, "wrong", nonce); err == nil {
+		t.Fatal("code redeemed with wrong verifier")
+	}
+
+	// wrong nonce, the id token may be replayed from another login
+	code, _ = mock.authorize(t, provider.AuthCodeURL(state, nonce, codeVerifier))
+	if _, err = provider.Exchange(context.Background(), code, codeVerifier, "other"); err == nil {
+		t.Fatal("id token of another nonce accepted")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock := newMockProvider(t)
+	provider := mock.provider(t)
+	const nonce = "nonce"
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		now := time.Now()
+		claims := jwt.MapClaims{
+			"iss":   mock.URL,
+			"sub":   "alice",
+			"aud":   testClientID,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Minute).Unix(),
+			"nonce": nonce,
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(claims, key)
+			} else {
+				claims[key] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(nil)), true},
+		{"audience in list", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"aud": []string{"other", testClientID}})), true},
+		{"wrong key", mock.sign(t, jwt.SigningMethodRS256, otherKey, "1", claims(nil)), false},
+		{"unknown kid", mock.sign(t, jwt.SigningMethodRS256, otherKey, "2", claims(nil)), false},
+		{"wrong algorithm", mock.sign(t, jwt.SigningMethodES256, ecKey, "1", claims(nil)), false},
+		{"hmac with public key", mock.sign(t, jwt.SigningMethodHS256, []byte(mock.key.N.Bytes()), "1", claims(nil)), false},
+		{"alg none", mock.sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "1", claims(nil)), false},
+		{"wrong issuer", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"iss": "http://evil"})), false},
+		{"wrong audience", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"aud": "other"})), false},
+		{"expired", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), false},
+		{"no expiry", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"exp": nil})), false},
+		{"no subject", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"sub": nil})), false},
+		{"wrong nonce", mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", claims(jwt.MapClaims{"nonce": "other"})), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(test.token, nonce)
+			if test.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("invalid id token accepted")
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	mock := newMockProvider(t)
+	_, err := NewProvider(config.OidcProvider{Name: "mock", Issuer: mock.URL + "/other"})
+	if err == nil {
+		t.Fatal("provider with mismatched issuer accepted")
+	}
+}
+
+func TestVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		emailVerified any
+		email         string
+		verified      bool
+	}{
+		{"verified", true, "alice@fudan.edu.cn", true},
+		{"verified in string", "true", "alice@fudan.edu.cn", true},
+		{"verified in upper case string", "True", "alice@fudan.edu.cn", true},
+		{"unverified", false, "", false},
+		{"unverified in string", "false", "", false},
+		{"missing", nil, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := map[string]any{"sub": "alice", "email": "Alice@Fudan.edu.cn"}
+			if test.emailVerified != nil {
+				payload["email_verified"] = test.emailVerified
+			}
+			data, _ := json.Marshal(payload)
+			var claims Claims
+			err := json.Unmarshal(data, &claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			email, verified := claims.VerifiedEmail()
+			if email != test.email || verified != test.verified {
+				t.Fatalf("VerifiedEmail() = %q, %v, want %q, %v", email, verified, test.email, test.verified)
+			}
+		})
+	}
+
+	// an id token of an unverified email is valid, but the email can not be used to link users
+	mock := newMockProvider(t)
+	provider := mock.provider(t)
+	now := time.Now()
+	token := mock.sign(t, jwt.SigningMethodRS256, mock.key, "1", jwt.MapClaims{
+		"iss":            mock.URL,
+		"sub":            "bob",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          "nonce",
+		"email":          "bob@fudan.edu.cn",
+		"email_verified": "false",
+	})
+	claims, err := provider.VerifyIDToken(token, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, verified := claims.VerifiedEmail(); verified {
+		t.Fatal("unverified email accepted")
+	}
+
+	if err = json.Unmarshal([]byte(`{"email_verified": "yes"}`), &Claims{}); err == nil {
+		t.Fatal("invalid boolean accepted")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"MOSS_backend/data"
+	"MOSS_backend/utils/i18n"
 	"github.com/lionsoul2014/ip2region/binding/golang/xdb"
 	"strings"
 )
@@ -24,3 +25,11 @@ func IsInChina(ip string) (bool, error) {
 	regionTable := strings.Split(region, "|")
 	return regionTable[0] == "中国", nil
 }
+
+// GetLocaleByIP returns zh for ips in China, en otherwise
+func GetLocaleByIP(ip string) string {
+	if ok, _ := IsInChina(ip); ok {
+		return i18n.Zh
+	}
+	return i18n.En
+}
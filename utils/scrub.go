@@ -0,0 +1,21 @@
+package utils
+
+import "regexp"
+
+var piiPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "<email>"},
+	{regexp.MustCompile(`\b\d{17}[\dXx]\b`), "<id_card>"},
+	{regexp.MustCompile(`(?:\+?86[\- ]?)?\b1[3-9]\d{9}\b`), "<phone>"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "<ip>"},
+}
+
+// ScrubPII replaces emails, chinese id card numbers, phone numbers and ip addresses with placeholders
+func ScrubPII(content string) string {
+	for _, pii := range piiPatterns {
+		content = pii.pattern.ReplaceAllString(content, pii.replacement)
+	}
+	return content
+}
@@ -0,0 +1,58 @@
+package sender
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	"MOSS_backend/utils"
+)
+
+// logSender writes messages to the logger instead of sending them, for development.
+// Messages are also appended to SENDER_LOG_FILE as json lines if set.
+type logSender struct {
+	kind string // email or sms
+}
+
+var logFileMutex sync.Mutex
+
+func (sender logSender) Send(message *Message) error {
+	utils.Logger.Info(
+		"message not sent by log sender",
+		zap.String("kind", sender.kind),
+		zap.String("receiver", message.Receiver),
+		zap.String("subject", message.Subject),
+		zap.String("content", message.Content),
+	)
+	if config.Config.SenderLogFile == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(map[string]string{
+		"time":     time.Now().Format(time.RFC3339),
+		"kind":     sender.kind,
+		"receiver": message.Receiver,
+		"subject":  message.Subject,
+		"content":  message.Content,
+		"code":     message.Code,
+	})
+	if err != nil {
+		return err
+	}
+
+	logFileMutex.Lock()
+	defer logFileMutex.Unlock()
+	file, err := os.OpenFile(config.Config.SenderLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package sender
+
+import (
+	"MOSS_backend/config"
+)
+
+// Message is a rendered notification. Providers sending with their own templates use Code only.
+type Message struct {
+	Receiver string
+	Subject  string // emails only
+	Content  string
+	Code     string
+}
+
+type Sender interface {
+	Send(message *Message) error
+}
+
+// Email returns the email sender chosen by EMAIL_SENDER
+func Email() Sender {
+	switch config.Config.EmailSender {
+	case config.SenderSmtp:
+		return smtpSender{}
+	case config.SenderLog:
+		return logSender{kind: "email"}
+	default:
+		return tencentSender{}
+	}
+}
+
+// Sms returns the sms sender chosen by SMS_SENDER
+func Sms() Sender {
+	switch config.Config.SmsSender {
+	case config.SenderLog:
+		return logSender{kind: "sms"}
+	default:
+		return uniSender{}
+	}
+}
+
+// SendCodeEmail sends the verification code of scope to the email, in the language of locale
+func SendCodeEmail(code, receiver, scope, locale string) error {
+	message, err := renderCode(emailTemplates, code, scope, locale)
+	if err != nil {
+		return err
+	}
+	message.Receiver = receiver
+	return Email().Send(message)
+}
+
+// SendCodeMessage sends the verification code of scope to the phone, in the language of locale
+func SendCodeMessage(code, phone, scope, locale string) error {
+	message, err := renderCode(smsTemplates, code, scope, locale)
+	if err != nil {
+		return err
+	}
+	message.Receiver = phone
+	return Sms().Send(message)
+}
@@ -0,0 +1,102 @@
+package sender
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"MOSS_backend/config"
+)
+
+func TestRenderCode(t *testing.T) {
+	config.Config.VerificationCodeExpires = 10
+
+	tests := []struct {
+		locale  string
+		scope   string
+		subject string
+		content string
+	}{
+		{"zh", "register", "[MOSS] 验证码", "您正在注册 MOSS 账号，验证码为 123456，10 分钟内有效"},
+		{"zh-CN", "reset", "[MOSS] 验证码", "您正在重置 MOSS 密码"},
+		{"en-US", "register", "[MOSS] Verification Code", "register a MOSS account. Your verification code is 123456, valid for 10 minutes"},
+		{"fr", "modify", "[MOSS] Verification Code", "modify your MOSS account"},
+		{"", "unknown", "[MOSS] Verification Code", "modify your MOSS account"},
+	}
+	for _, test := range tests {
+		message, err := renderCode(emailTemplates, "123456", test.scope, test.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Subject != test.subject || !strings.Contains(message.Content, test.content) || message.Code != "123456" {
+			t.Errorf("locale %q scope %q: unexpected message %+v", test.locale, test.scope, message)
+		}
+	}
+
+	message, err := renderCode(smsTemplates, "123456", "register", "zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "" || !strings.HasPrefix(message.Content, "【MOSS】") {
+		t.Errorf("unexpected sms %+v", message)
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	config.Config.EmailSender = config.SenderLog
+	config.Config.SmsSender = config.SenderLog
+	config.Config.SenderLogFile = filepath.Join(t.TempDir(), "messages.log")
+
+	err := SendCodeEmail("123456", "user@example.com", "register", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SendCodeMessage("654321", "+8613000000000", "reset", "zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(config.Config.SenderLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	for i, want := range []map[string]string{
+		{"kind": "email", "receiver": "user@example.com", "code": "123456"},
+		{"kind": "sms", "receiver": "+8613000000000", "code": "654321"},
+	} {
+		var got map[string]string
+		err = json.Unmarshal([]byte(lines[i]), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("line %d: %s = %q, want %q", i, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	email := string(buildEmail("moss@example.com", &Message{
+		Receiver: "user@example.com",
+		Subject:  "[MOSS] 验证码",
+		Content:  "line1\nline2",
+	}))
+	for _, want := range []string{
+		"From: moss@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: =?UTF-8?b?",
+		"\r\n\r\nline1\r\nline2",
+	} {
+		if !strings.Contains(email, want) {
+			t.Errorf("email missing %q:\n%s", want, email)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package sender
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"MOSS_backend/config"
+)
+
+const smtpTimeout = 10 * time.Second
+
+// smtpSender sends plain text emails, with implicit tls on port 465 and starttls otherwise
+type smtpSender struct{}
+
+func (smtpSender) Send(message *Message) error {
+	host := config.Config.SmtpHost
+	addr := net.JoinHostPort(host, strconv.Itoa(config.Config.SmtpPort))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	if config.Config.SmtpPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Config.SmtpUsername != "" {
+		err = client.Auth(smtp.PlainAuth("", config.Config.SmtpUsername, config.Config.SmtpPassword, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(config.Config.EmailUrl)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(message.Receiver)
+	if err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(buildEmail(config.Config.EmailUrl, message))
+	if err != nil {
+		_ = writer.Close()
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildEmail(from string, message *Message) []byte {
+	var builder strings.Builder
+	for _, header := range [][2]string{
+		{"From", from},
+		{"To", message.Receiver},
+		{"Subject", mime.BEncoding.Encode("UTF-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=UTF-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	} {
+		_, _ = fmt.Fprintf(&builder, "%s: %s\r\n", header[0], header[1])
+	}
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Content, "\n", "\r\n"))
+	return []byte(builder.String())
+}
@@ -0,0 +1,106 @@
+package sender
+
+import (
+	"strings"
+	"text/template"
+
+	"MOSS_backend/config"
+)
+
+// templates of verification codes by locale, english is the fallback
+
+type codeTemplate struct {
+	subject *template.Template // emails only
+	content *template.Template
+	purpose map[string]string // scope => purpose
+}
+
+type codeData struct {
+	Code    string
+	Purpose string
+	Expires int // minutes
+}
+
+const fallbackLocale = "en"
+
+var purposesZh = map[string]string{
+	"register": "注册 MOSS 账号",
+	"reset":    "重置 MOSS 密码",
+	"modify":   "修改 MOSS 账号信息",
+}
+
+var purposesEn = map[string]string{
+	"register": "register a MOSS account",
+	"reset":    "reset your MOSS password",
+	"modify":   "modify your MOSS account",
+}
+
+var emailTemplates = map[string]*codeTemplate{
+	"zh": {
+		subject: template.Must(template.New("subject").Parse("[MOSS] 验证码")),
+		content: template.Must(template.New("content").Parse(
+			"您好，\n\n您正在{{.Purpose}}，验证码为 {{.Code}}，{{.Expires}} 分钟内有效。\n\n如果这不是您本人的操作，请忽略本邮件。\n",
+		)),
+		purpose: purposesZh,
+	},
+	"en": {
+		subject: template.Must(template.New("subject").Parse("[MOSS] Verification Code")),
+		content: template.Must(template.New("content").Parse(
+			"Hello,\n\nYou are trying to {{.Purpose}}. Your verification code is {{.Code}}, valid for {{.Expires}} minutes.\n\nIf this was not you, please ignore this email.\n",
+		)),
+		purpose: purposesEn,
+	},
+}
+
+var smsTemplates = map[string]*codeTemplate{
+	"zh": {
+		content: template.Must(template.New("content").Parse(
+			"【MOSS】您正在{{.Purpose}}，验证码 {{.Code}}，{{.Expires}} 分钟内有效。如非本人操作，请忽略。",
+		)),
+		purpose: purposesZh,
+	},
+	"en": {
+		content: template.Must(template.New("content").Parse(
+			"[MOSS] You are trying to {{.Purpose}}. Your code is {{.Code}}, valid for {{.Expires}} minutes.",
+		)),
+		purpose: purposesEn,
+	},
+}
+
+// matchTemplate finds the template of locale by language, zh-CN matches zh
+func matchTemplate(templates map[string]*codeTemplate, locale string) *codeTemplate {
+	language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	if codeTemplate, ok := templates[language]; ok {
+		return codeTemplate
+	}
+	return templates[fallbackLocale]
+}
+
+func renderCode(templates map[string]*codeTemplate, code, scope, locale string) (*Message, error) {
+	codeTemplate := matchTemplate(templates, locale)
+	data := codeData{
+		Code:    code,
+		Purpose: codeTemplate.purpose[scope],
+		Expires: config.Config.VerificationCodeExpires,
+	}
+	if data.Purpose == "" {
+		data.Purpose = codeTemplate.purpose["modify"]
+	}
+
+	message := Message{Code: code}
+	var builder strings.Builder
+	if codeTemplate.subject != nil {
+		err := codeTemplate.subject.Execute(&builder, data)
+		if err != nil {
+			return nil, err
+		}
+		message.Subject = builder.String()
+		builder.Reset()
+	}
+	err := codeTemplate.content.Execute(&builder, data)
+	if err != nil {
+		return nil, err
+	}
+	message.Content = builder.String()
+	return &message, nil
+}
@@ -0,0 +1,52 @@
+package sender
+
+import (
+	"encoding/json"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/regions"
+	ses "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/ses/v20201002"
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	"MOSS_backend/utils"
+)
+
+// tencentSender sends emails with tencent cloud ses, the content is rendered by the ses template
+type tencentSender struct{}
+
+func (tencentSender) Send(message *Message) error {
+	credential := common.NewCredential(
+		config.Config.TencentSecretID,
+		config.Config.TencentSecretKey,
+	)
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "ses.tencentcloudapi.com"
+	client, err := ses.NewClient(credential, regions.HongKong, cpf)
+	if err != nil {
+		return err
+	}
+
+	templateData, err := json.Marshal(map[string]string{"code": message.Code})
+	if err != nil {
+		return err
+	}
+
+	request := ses.NewSendEmailRequest()
+	request.FromEmailAddress = common.StringPtr(config.Config.EmailUrl)
+	request.Destination = common.StringPtrs([]string{message.Receiver})
+	request.Template = &ses.Template{
+		TemplateID:   common.Uint64Ptr(config.Config.TencentTemplateID),
+		TemplateData: common.StringPtr(string(templateData)),
+	}
+	request.Subject = common.StringPtr(message.Subject)
+	request.TriggerType = common.Uint64Ptr(1)
+
+	resp, err := client.SendEmail(request)
+	if err != nil {
+		return err
+	}
+	utils.Logger.Info("SendEmailResponse", zap.String("Response", resp.ToJsonString()))
+	return nil
+}
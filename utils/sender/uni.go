@@ -0,0 +1,23 @@
+package sender
+
+import (
+	unisms "github.com/apistd/uni-go-sdk/sms"
+
+	"MOSS_backend/config"
+)
+
+// uniSender sends sms with unisms, the content is rendered by the unisms template
+type uniSender struct{}
+
+func (uniSender) Send(message *Message) error {
+	client := unisms.NewClient(config.Config.UniAccessID) // 简易验签模式
+
+	sms := unisms.BuildMessage()
+	sms.SetTo(message.Receiver)
+	sms.SetSignature(config.Config.UniSignature)
+	sms.SetTemplateId(config.Config.UniTemplateID)
+	sms.SetTemplateData(map[string]string{"code": message.Code})
+
+	_, err := client.Send(sms)
+	return err
+}
@@ -0,0 +1,118 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"MOSS_backend/config"
+	"MOSS_backend/models"
+	"MOSS_backend/utils"
+)
+
+// keys of native auth mode, loaded from database and shared by replicas
+var keys struct {
+	sync.RWMutex
+	byID     map[int]*models.JwtKey
+	signing  *models.JwtKey
+	loadedAt time.Time
+}
+
+// keyReloadInterval limits reloading keys from database for unknown key ids
+const keyReloadInterval = 10 * time.Second
+
+var errKeyNotFound = errors.New("jwt key not found")
+
+func rotationPeriod() time.Duration {
+	return time.Duration(config.Config.JwtKeyRotationDays) * 24 * time.Hour
+}
+
+// reloadKeys loads unexpired keys, the caller should hold the write lock
+func reloadKeys() error {
+	var jwtKeys []*models.JwtKey
+	err := models.DB.Order("id").Find(&jwtKeys, "expires_at > ?", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	keys.byID = make(map[int]*models.JwtKey, len(jwtKeys))
+	keys.signing = nil
+	for _, key := range jwtKeys {
+		keys.byID[key.ID] = key
+		keys.signing = key
+	}
+	keys.loadedAt = time.Now()
+	return nil
+}
+
+// signingKey returns the newest key, a new key is created if it is older than the rotation period
+func signingKey() (*models.JwtKey, error) {
+	keys.RLock()
+	key := keys.signing
+	keys.RUnlock()
+	if key != nil && time.Since(key.CreatedAt) < rotationPeriod() {
+		return key, nil
+	}
+
+	keys.Lock()
+	defer keys.Unlock()
+
+	// another replica may have rotated
+	err := reloadKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys.signing != nil && time.Since(keys.signing.CreatedAt) < rotationPeriod() {
+		return keys.signing, nil
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	key = &models.JwtKey{
+		CreatedAt: now,
+		// tokens signed at the end of the rotation period live until refresh tokens expire
+		ExpiresAt: now.Add(rotationPeriod()).Add(time.Duration(config.Config.RefreshExpireTime) * 24 * time.Hour),
+		Secret:    hex.EncodeToString(secret),
+	}
+	err = models.DB.Create(key).Error
+	if err != nil {
+		return nil, err
+	}
+	keys.byID[key.ID] = key
+	keys.signing = key
+
+	err = models.DB.Where("expires_at <= ?", now).Delete(&models.JwtKey{}).Error
+	if err != nil {
+		utils.Logger.Error("delete expired jwt keys error", zap.Error(err))
+	}
+	return key, nil
+}
+
+// verificationKey returns the unexpired key of id
+func verificationKey(id int) (*models.JwtKey, error) {
+	keys.RLock()
+	key, ok := keys.byID[id]
+	loadedAt := keys.loadedAt
+	keys.RUnlock()
+	if !ok && time.Since(loadedAt) > keyReloadInterval {
+		// created by another replica
+		keys.Lock()
+		if err := reloadKeys(); err != nil {
+			keys.Unlock()
+			return nil, err
+		}
+		key, ok = keys.byID[id]
+		keys.Unlock()
+	}
+	if !ok || time.Now().After(key.ExpiresAt) {
+		return nil, errKeyNotFound
+	}
+	return key, nil
+}
@@ -0,0 +1,299 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"MOSS_backend/config"
+	"MOSS_backend/models"
+	"MOSS_backend/utils"
+	"MOSS_backend/utils/kong"
+)
+
+// Each login creates a session. Refresh tokens are rotated on use, and a refresh token used twice
+// means it is leaked, so the session is revoked. In kong auth mode every session has its own
+// kong credential, deleting it revokes the tokens of the session at the gateway.
+
+func refreshExpireTime() time.Duration {
+	return time.Duration(config.Config.RefreshExpireTime) * 24 * time.Hour
+}
+
+func newRefreshID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func sessionRevokedKey(sessionID int) string {
+	return "moss_session_revoked:" + strconv.Itoa(sessionID)
+}
+
+// sessionRevoked tells whether access tokens of the session are rejected in native auth mode
+func sessionRevoked(sessionID int) bool {
+	var revoked bool
+	return config.GetCache(sessionRevokedKey(sessionID), &revoked) == nil && revoked
+}
+
+// NewSession creates a session for the device of the request and issues its tokens
+func NewSession(c *fiber.Ctx, user *models.User) (accessToken, refreshToken string, err error) {
+	session, err := createSession(user, c.Get(fiber.HeaderUserAgent), utils.GetRealIP(c))
+	if err != nil {
+		return "", "", err
+	}
+	return issue(user, session)
+}
+
+func createSession(user *models.User, device, ip string) (*models.Session, error) {
+	refreshID, err := newRefreshID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:    user.ID,
+		Device:    utils.StripContent(device, 256),
+		IP:        ip,
+		LastSeen:  now,
+		ExpiresAt: now.Add(refreshExpireTime()),
+		RefreshID: refreshID,
+	}
+	if !native() {
+		credential, err := kong.CreateJwtCredential(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		session.CredentialID = credential.ID
+	}
+
+	err = models.DB.Create(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession verifies the refresh token in Authorization header or refresh cookie,
+// and issues new tokens of its session. The refresh token can not be used again.
+func RotateSession(c *fiber.Ctx) (user *models.User, accessToken, refreshToken string, err error) {
+	tokenString, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !found {
+		tokenString = c.Cookies("refresh")
+	}
+	claims, err := Verify(tokenString, TypeRefresh)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	user = new(models.User)
+	err = models.LoadUserByIDFromCache(claims.UID, user)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	var session *models.Session
+	if claims.SessionID == 0 {
+		// tokens issued before sessions are upgraded to a new session
+		session, err = upgradeSession(user, tokenString, c.Get(fiber.HeaderUserAgent), utils.GetRealIP(c))
+	} else {
+		session, err = rotateSession(claims, c.Get(fiber.HeaderUserAgent), utils.GetRealIP(c))
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	accessToken, refreshToken, err = issue(user, session)
+	return user, accessToken, refreshToken, err
+}
+
+var errRefreshTokenReused = utils.Unauthorized().WithMessageID("refresh_token_reused")
+
+func rotateSession(claims *Claims, device, ip string) (*models.Session, error) {
+	refreshID, err := newRefreshID()
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(models.LockingClause).
+			Take(&session, "id = ? AND user_id = ?", claims.SessionID, claims.UID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return utils.Unauthorized().WithMessageID("session_not_found")
+			}
+			return err
+		}
+		if !session.Active() {
+			return utils.Unauthorized().WithMessageID("session_revoked")
+		}
+		if claims.RegisteredClaims.ID != session.RefreshID {
+			return errRefreshTokenReused
+		}
+
+		now := time.Now()
+		session.RefreshID = refreshID
+		session.Device = utils.StripContent(device, 256)
+		session.IP = ip
+		session.LastSeen = now
+		session.ExpiresAt = now.Add(refreshExpireTime())
+		return tx.Select("RefreshID", "Device", "IP", "LastSeen", "ExpiresAt").Updates(&session).Error
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		return nil, refreshTokenReused(&session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// upgradeSession creates a session for a refresh token issued before sessions. These tokens have no jti,
+// so the token is remembered by its hash, and upgrading it again is a reuse of the refresh token.
+func upgradeSession(user *models.User, tokenString, device, ip string) (*models.Session, error) {
+	sum := sha256.Sum256([]byte(tokenString))
+	legacyToken := hex.EncodeToString(sum[:])
+
+	var upgraded models.Session
+	err := models.DB.Take(&upgraded, "legacy_token = ?", legacyToken).Error
+	if err == nil {
+		return nil, refreshTokenReused(&upgraded)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	session, err := createSession(user, device, ip)
+	if err != nil {
+		return nil, err
+	}
+	// the unique index rejects the same token upgraded concurrently
+	err = models.DB.Model(session).Update("legacy_token", legacyToken).Error
+	if err != nil {
+		if revokeErr := RevokeSession(session); revokeErr != nil {
+			return nil, revokeErr
+		}
+		if models.DB.Take(&upgraded, "legacy_token = ?", legacyToken).Error == nil {
+			return nil, refreshTokenReused(&upgraded)
+		}
+		return nil, err
+	}
+	session.LegacyToken = &legacyToken
+	return session, nil
+}
+
+// refreshTokenReused revokes the session whose refresh token is used twice
+func refreshTokenReused(session *models.Session) error {
+	utils.Logger.Warn(
+		"refresh token reused, session revoked",
+		zap.Int("user_id", session.UserID),
+		zap.Int("session_id", session.ID),
+	)
+	if err := RevokeSession(session); err != nil {
+		return err
+	}
+	return errRefreshTokenReused
+}
+
+// RevokeSession invalidates all tokens of the session
+func RevokeSession(session *models.Session) error {
+	err := models.DB.Model(session).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	if !native() {
+		if session.CredentialID == "" {
+			return nil
+		}
+		return kong.DeleteJwtCredentialByID(session.UserID, session.CredentialID)
+	}
+
+	// access tokens are not checked against the database, remember the session until they expire
+	return config.SetCache(
+		sessionRevokedKey(session.ID),
+		true,
+		time.Duration(config.Config.AccessExpireTime)*time.Minute,
+	)
+}
+
+// RevokeTokens invalidates all tokens of all sessions of the user
+func RevokeTokens(userID int) error {
+	err := models.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	if !native() {
+		return kong.DeleteJwtCredential(userID)
+	}
+
+	err = models.DB.Unscoped().Model(&models.User{ID: userID}).UpdateColumn("tokens_revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	models.DeleteUserCacheByID(userID)
+	return nil
+}
+
+// SessionID returns the session of the access token in the request, 0 for tokens issued before sessions
+func SessionID(c *fiber.Ctx) int {
+	// set by the auth middleware in native auth mode
+	if sessionID, ok := c.Locals("session_id").(int); ok {
+		return sessionID
+	}
+	if native() {
+		return 0
+	}
+
+	// the token has been verified by kong
+	userID, err := models.GetUserID(c)
+	if err != nil {
+		return 0
+	}
+	var claims Claims
+	_, _, err = jwt.NewParser().ParseUnverified(FromRequest(c), &claims)
+	if err != nil || claims.UID != userID {
+		return 0
+	}
+	return claims.SessionID
+}
+
+// CleanSessionsTask deletes expired sessions, and their kong credentials in kong auth mode
+func CleanSessionsTask() {
+	var sessions []models.Session
+	err := models.DB.Find(&sessions, "expires_at < ?", time.Now()).Error
+	if err != nil {
+		utils.Logger.Error("load expired sessions error", zap.Error(err))
+		return
+	}
+
+	for i := range sessions {
+		session := &sessions[i]
+		if !native() && session.RevokedAt == nil && session.CredentialID != "" {
+			err = kong.DeleteJwtCredentialByID(session.UserID, session.CredentialID)
+			if err != nil {
+				utils.Logger.Error("delete session credential error", zap.Int("session_id", session.ID), zap.Error(err))
+				continue
+			}
+		}
+		err = models.DB.Delete(session).Error
+		if err != nil {
+			utils.Logger.Error("delete session error", zap.Int("session_id", session.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package token
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/golang-jwt/jwt/v4"
+
+	"MOSS_backend/config"
+	"MOSS_backend/models"
+	"MOSS_backend/utils"
+	"MOSS_backend/utils/kong"
+)
+
+// Tokens are issued by kong credentials in kong auth mode, or by rotating keys in native auth mode.
+
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+)
+
+// Claims of access and refresh tokens, the same in both auth modes
+type Claims struct {
+	UID        int    `json:"uid"`
+	ID         int    `json:"id"`
+	Nickname   string `json:"nickname"`
+	JoinedTime string `json:"joined_time"`
+	Type       string `json:"type"`
+	SessionID  int    `json:"sid"` // 0 for tokens issued before sessions
+	jwt.RegisteredClaims
+}
+
+func native() bool {
+	return config.Config.AuthMode == config.AuthModeNative
+}
+
+// issue signs an access token and a refresh token of the session
+func issue(user *models.User, session *models.Session) (accessToken, refreshToken string, err error) {
+	kid, issuer, secret, err := sessionKey(session)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		UID:        user.ID,
+		ID:         user.ID,
+		Nickname:   user.Nickname,
+		JoinedTime: user.JoinedTime.Format(time.RFC3339),
+		SessionID:  session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   issuer,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+	}
+
+	claims.Type = TypeAccess
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(time.Duration(config.Config.AccessExpireTime) * time.Minute))
+	accessToken, err = sign(&claims, kid, secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims.Type = TypeRefresh
+	claims.RegisteredClaims.ID = session.RefreshID
+	claims.ExpiresAt = jwt.NewNumericDate(session.ExpiresAt)
+	refreshToken, err = sign(&claims, kid, secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	return
+}
+
+// sessionKey returns the key signing tokens of the session, which is
+// the rotating key in native auth mode, or the kong credential of the session
+func sessionKey(session *models.Session) (kid, issuer string, secret []byte, err error) {
+	if native() {
+		key, err := signingKey()
+		if err != nil {
+			return "", "", nil, err
+		}
+		return strconv.Itoa(key.ID), config.AppName, []byte(key.Secret), nil
+	}
+
+	credentials, err := kong.ListJwtCredentials(session.UserID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	for _, credential := range credentials {
+		if credential.ID == session.CredentialID {
+			return "", credential.Key, []byte(credential.Secret), nil
+		}
+	}
+	return "", "", nil, utils.Unauthorized().WithMessageID("session_revoked")
+}
+
+func sign(claims *Claims, kid string, secret []byte) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(secret)
+}
+
+// Verify checks the signature, expiry and type of a token, and whether it is revoked
+func Verify(tokenString, tokenType string) (*Claims, error) {
+	if !native() {
+		return verifyKong(tokenString, tokenType)
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		id, err := strconv.Atoi(kid)
+		if err != nil {
+			return nil, errKeyNotFound
+		}
+		key, err := verificationKey(id)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(key.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !claims.valid(tokenType) {
+		return nil, utils.Unauthorized().WithMessageID("invalid_token")
+	}
+
+	var user models.User
+	err = models.LoadUserByIDFromCache(claims.UID, &user)
+	if err != nil {
+		return nil, utils.Unauthorized().WithMessageID("invalid_token")
+	}
+	if user.TokensRevokedAt != nil && claims.IssuedAt.Before(user.TokensRevokedAt.Truncate(time.Second)) {
+		return nil, utils.Unauthorized().WithMessageID("token_revoked")
+	}
+	if claims.SessionID != 0 && sessionRevoked(claims.SessionID) {
+		return nil, utils.Unauthorized().WithMessageID("token_revoked")
+	}
+
+	return &claims, nil
+}
+
+// verifyKong checks the token against the kong credential of the user named by iss.
+// Revoked tokens fail because their credentials are deleted.
+func verifyKong(tokenString, tokenType string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		credentials, err := kong.ListJwtCredentials(claims.UID)
+		if err != nil {
+			return nil, err
+		}
+		for _, credential := range credentials {
+			if credential.Key == claims.Issuer {
+				return []byte(credential.Secret), nil
+			}
+		}
+		return nil, errKeyNotFound
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !claims.valid(tokenType) {
+		return nil, utils.Unauthorized().WithMessageID("invalid_token")
+	}
+	return &claims, nil
+}
+
+// valid checks the claims not covered by jwt parsing, exp is optional there
+func (claims *Claims) valid(tokenType string) bool {
+	return claims.Type == tokenType && claims.UID > 0 && claims.ExpiresAt != nil && claims.IssuedAt != nil
+}
+
+// CreateUser creates the kong consumer of the user in kong auth mode
+func CreateUser(userID int) error {
+	if !native() {
+		return kong.CreateUser(userID)
+	}
+	return nil
+}
+
+// DeleteUser deletes the kong consumer of the user in kong auth mode
+func DeleteUser(userID int) error {
+	if !native() {
+		return kong.DeleteUser(userID)
+	}
+	return nil
+}
+
+// FromRequest extracts the token from Authorization header, access cookie or jwt query for websockets.
+// The jwt query is accepted only in websocket upgrades, which can not set headers in browsers.
+func FromRequest(c *fiber.Ctx) string {
+	if tokenString, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); found {
+		return tokenString
+	}
+	if tokenString := c.Cookies("access"); tokenString != "" {
+		return tokenString
+	}
+	if websocket.IsWebSocketUpgrade(c) {
+		return c.Query("jwt")
+	}
+	return ""
+}
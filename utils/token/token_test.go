@@ -0,0 +1,262 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
+
+	"MOSS_backend/config"
+	"MOSS_backend/models"
+	"MOSS_backend/utils/kong"
+)
+
+const (
+	kongKey    = "kong-key"
+	kongSecret = "kong-secret"
+	testUserID = 7
+)
+
+func TestMain(m *testing.M) {
+	config.Config.Mode = "test"
+	config.Config.AccessExpireTime = 30
+	config.Config.RefreshExpireTime = 30
+	config.Config.JwtKeyRotationDays = 30
+	// no redis in tests, cache misses fall back to the database
+	config.RedisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 10 * time.Millisecond})
+
+	// fake kong serving the jwt credential of the test user
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/consumers/%d/jwt", testUserID):
+			_ = json.NewEncoder(w).Encode(kong.JwtCredentials{
+				Data: []*kong.JwtCredential{{ID: "1", Key: kongKey, Secret: kongSecret, Algorithm: "HS256"}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	config.Config.KongUrl = server.URL
+
+	models.InitDB()
+	models.DB.Create(&models.User{ID: testUserID, Nickname: "test"})
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+func kongToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func kongClaims(tokenType string, expiresAt time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"uid":  testUserID,
+		"id":   testUserID,
+		"iss":  kongKey,
+		"iat":  time.Now().Unix(),
+		"exp":  expiresAt.Unix(),
+		"type": tokenType,
+	}
+}
+
+func TestVerifyKong(t *testing.T) {
+	config.Config.AuthMode = config.AuthModeKong
+	expiresAt := time.Now().Add(time.Hour)
+
+	unknownIssuer := kongClaims(TypeAccess, expiresAt)
+	unknownIssuer["iss"] = "unknown"
+	unknownUser := kongClaims(TypeAccess, expiresAt)
+	unknownUser["uid"] = testUserID + 1
+	noExpiry := kongClaims(TypeAccess, expiresAt)
+	delete(noExpiry, "exp")
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), kongClaims(TypeAccess, expiresAt)), true},
+		{"refresh as access", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), kongClaims(TypeRefresh, expiresAt)), false},
+		{"wrong secret", kongToken(t, jwt.SigningMethodHS256, []byte("wrong"), kongClaims(TypeAccess, expiresAt)), false},
+		{"alg none", kongToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, kongClaims(TypeAccess, expiresAt)), false},
+		{"expired", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), kongClaims(TypeAccess, time.Now().Add(-time.Minute))), false},
+		{"no expiry", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), noExpiry), false},
+		{"unknown issuer", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), unknownIssuer), false},
+		{"unknown user", kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), unknownUser), false},
+		{"not a jwt", "Bearer", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := Verify(test.token, TypeAccess)
+			if test.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.UID != testUserID {
+					t.Fatalf("uid %d, want %d", claims.UID, testUserID)
+				}
+			} else if err == nil {
+				t.Fatal("invalid token accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyNative(t *testing.T) {
+	config.Config.AuthMode = config.AuthModeNative
+	defer func() { config.Config.AuthMode = config.AuthModeKong }()
+
+	user := &models.User{ID: testUserID, Nickname: "test", JoinedTime: time.Now()}
+	session, err := createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, refreshToken, err := issue(user, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Verify(accessToken, TypeAccess); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(refreshToken, TypeRefresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(refreshToken, TypeAccess); err == nil {
+		t.Fatal("refresh token accepted as access token")
+	}
+
+	// signed by kong credential instead of a native key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, kongClaims(TypeAccess, time.Now().Add(time.Hour)))
+	forged.Header["kid"] = strconv.Itoa(1 << 20)
+	forgedToken, _ := forged.SignedString([]byte(kongSecret))
+	if _, err = Verify(forgedToken, TypeAccess); err == nil {
+		t.Fatal("token with unknown key accepted")
+	}
+
+	// tokens issued in the seconds before revocation are rejected
+	time.Sleep(time.Second)
+	err = RevokeTokens(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(accessToken, TypeAccess); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+	accessToken, _, err = issue(user, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(accessToken, TypeAccess); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	config.Config.AuthMode = config.AuthModeNative
+	defer func() { config.Config.AuthMode = config.AuthModeKong }()
+
+	user := &models.User{ID: testUserID, Nickname: "test", JoinedTime: time.Now()}
+	session, err := createSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refreshToken, err := issue(user, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := Verify(refreshToken, TypeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := rotateSession(claims, "test", "127.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.RefreshID == session.RefreshID || rotated.IP != "127.0.0.2" {
+		t.Fatal("session not rotated")
+	}
+
+	// the old refresh token is reused, the session is revoked
+	if _, err = rotateSession(claims, "test", "127.0.0.3"); err == nil {
+		t.Fatal("reused refresh token accepted")
+	}
+	var revoked models.Session
+	models.DB.Take(&revoked, session.ID)
+	if revoked.Active() {
+		t.Fatal("session not revoked after refresh token reuse")
+	}
+	_, newRefreshToken, err := issue(user, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClaims, err := Verify(newRefreshToken, TypeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rotateSession(newClaims, "test", "127.0.0.2"); err == nil {
+		t.Fatal("refresh token of revoked session accepted")
+	}
+
+	// a refresh token issued before sessions is upgraded once, and replaying it revokes the upgraded session
+	legacyToken := kongToken(t, jwt.SigningMethodHS256, []byte(kongSecret), kongClaims(TypeRefresh, time.Now().Add(time.Hour)))
+	upgraded, err := upgradeSession(user, legacyToken, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upgradeSession(user, legacyToken, "test", "127.0.0.4"); err == nil {
+		t.Fatal("reused legacy refresh token accepted")
+	}
+	models.DB.Take(&revoked, upgraded.ID)
+	if revoked.Active() {
+		t.Fatal("session not revoked after legacy refresh token reuse")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(FromRequest(c))
+	})
+
+	for _, test := range []struct {
+		name    string
+		url     string
+		headers map[string]string
+		token   string
+	}{
+		{"header", "/", map[string]string{"Authorization": "Bearer header"}, "header"},
+		{"cookie", "/", map[string]string{"Cookie": "access=cookie"}, "cookie"},
+		{"query in http", "/?jwt=query", nil, ""},
+		{"query in websocket", "/?jwt=query", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, "query"},
+	} {
+		req := httptest.NewRequest("GET", test.url, nil)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		rsp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rsp.Body)
+		if string(body) != test.token {
+			t.Errorf("%s: token %q, want %q", test.name, body, test.token)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package token
+
+import (
+	"github.com/gofiber/websocket/v2"
+
+	"MOSS_backend/models"
+	"MOSS_backend/utils"
+)
+
+// GetUserIDFromWs verifies the access token in jwt query or access cookie of the websocket
+func GetUserIDFromWs(c *websocket.Conn) (int, error) {
+	// set by the auth middleware in native auth mode
+	if userID, ok := c.Locals("user_id").(int); ok {
+		return userID, nil
+	}
+
+	tokenString := c.Query("jwt")
+	if tokenString == "" {
+		tokenString = c.Cookies("access")
+		if tokenString == "" {
+			return 0, utils.Unauthorized()
+		}
+	}
+
+	claims, err := Verify(tokenString, TypeAccess)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UID, nil
+}
+
+func LoadUserFromWs(c *websocket.Conn) (*models.User, error) {
+	userID, err := GetUserIDFromWs(c)
+	if err != nil {
+		return nil, err
+	}
+	return models.LoadUserByID(userID)
+}
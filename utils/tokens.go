@@ -0,0 +1,17 @@
+package utils
+
+import "unicode"
+
+// EstimateTokens roughly estimates the token number of content without a tokenizer:
+// each CJK character counts as one token, and other text counts as one token per 4 bytes
+func EstimateTokens(content string) int {
+	var tokens, otherBytes int
+	for _, r := range content {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			tokens++
+		} else {
+			otherBytes += len(string(r))
+		}
+	}
+	return tokens + (otherBytes+3)/4
+}
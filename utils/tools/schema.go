@@ -3,6 +3,9 @@ package tools
 import (
 	"errors"
 	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
 type ResultModel struct {
@@ -17,6 +20,17 @@ type ResultTotalModel struct {
 	ProcessedExtraData []*ExtraDataModel `json:"processed_extra_data"`
 }
 
+// Types returns the distinct types of tools used, comma separated
+func (r *ResultTotalModel) Types() string {
+	var types []string
+	for _, extraData := range r.ExtraData {
+		if extraData != nil && !slices.Contains(types, extraData.Type) {
+			types = append(types, extraData.Type)
+		}
+	}
+	return strings.Join(types, ",")
+}
+
 var NoneResultModel = &ResultModel{Result: "None"}
 
 var NoneResultTotalModel = &ResultTotalModel{Result: "None"}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -51,6 +53,13 @@ func CutLastAny(s string, chars string) (before, after string, found bool) {
 	}
 }
 
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// EscapeLike escapes the wildcards in s to be matched literally, use with LIKE ? ESCAPE '!'
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 type JSONReader interface {
 	ReadJson(any) error
 }